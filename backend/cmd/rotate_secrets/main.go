@@ -0,0 +1,62 @@
+// Command rotate_secrets re-encrypts provider API keys and MCP secrets under a new master key.
+//
+// Usage:
+//
+//	FNCHATBOT_OLD_MASTER_KEY=<old> FNCHATBOT_MASTER_KEY=<new> go run ./cmd/rotate_secrets
+//
+// The old key may be omitted when secrets are still stored in plaintext. Stop the
+// server first, then start it again with the new FNCHATBOT_MASTER_KEY.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"fnchatbot/internal/config"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/secrets"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func main() {
+	dbPath := flag.String("db", "fnchatbot.db", "path to the SQLite database")
+	mcpPath := flag.String("mcp", "", "path to mcp.json (default: FNCHATBOT_MCP_CONFIG or mcp.json)")
+	flag.Parse()
+
+	if *mcpPath == "" {
+		*mcpPath = os.Getenv("FNCHATBOT_MCP_CONFIG")
+		if *mcpPath == "" {
+			*mcpPath = "mcp.json"
+		}
+	}
+
+	newKey := config.LoadConfig().Security.MasterKey
+	if newKey == "" {
+		log.Fatal("New master key is required (FNCHATBOT_MASTER_KEY or security.master_key)")
+	}
+	to, err := secrets.NewCipher(newKey)
+	if err != nil {
+		log.Fatalf("Invalid new master key: %v", err)
+	}
+
+	var from *secrets.Cipher
+	if oldKey := os.Getenv("FNCHATBOT_OLD_MASTER_KEY"); oldKey != "" {
+		if from, err = secrets.NewCipher(oldKey); err != nil {
+			log.Fatalf("Invalid old master key: %v", err)
+		}
+	}
+
+	conn, err := gorm.Open(sqlite.Open(*dbPath), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	n, err := db.ReencryptSecrets(conn, *mcpPath, from, to)
+	if err != nil {
+		log.Fatalf("Rotation failed: %v", err)
+	}
+	log.Printf("Re-encrypted %d secret(s) with master key %s", n, to.KeyID())
+}
@@ -10,7 +10,10 @@ import (
 	"fnchatbot/internal/auth"
 	"fnchatbot/internal/config"
 	"fnchatbot/internal/db"
+	"fnchatbot/internal/secrets"
 	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/memory"
+	"fnchatbot/internal/storage"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -20,14 +23,30 @@ func main() {
 	// Load application configuration.
 	appCfg := config.LoadConfig()
 
+	// Master key for secrets at rest must be ready before any provider/MCP access.
+	if err := secrets.Init(appCfg.Security.MasterKey); err != nil {
+		log.Fatalf("Failed to initialize secrets: %v", err)
+	}
+
+	// Attachments are stored outside the database, addressed by content hash.
+	if err := storage.Init(appCfg.Storage.FilesDir); err != nil {
+		log.Fatalf("Failed to initialize file store: %v", err)
+	}
+
 	// Initialize Database
 	db.InitDB("fnchatbot.db")
 
+	// Full-text index of message text for conversation search.
+	if err := memory.InitSearchIndex(db.DB); err != nil {
+		log.Printf("Conversation search index unavailable: %v", err)
+	}
+
 	// Initialize MCP service (config from mcp.json; path via env FNCHATBOT_MCP_CONFIG if set)
 	mcpConfigPath := os.Getenv("FNCHATBOT_MCP_CONFIG")
 	if mcpConfigPath == "" {
 		mcpConfigPath = "mcp.json"
 	}
+	db.EncryptSecrets(mcpConfigPath)
 	services.DefaultMCPService = services.NewMCPService(mcpConfigPath)
 
 	// On exit, close all MCP clients (e.g. stdio subprocesses)
@@ -37,6 +56,14 @@ func main() {
 		}
 	}()
 
+	// Keep knowledge bases in sync with their NAS directories.
+	if watcher, err := services.StartKnowledgeWatcher(db.DB); err != nil {
+		log.Printf("Knowledge watcher disabled: %v", err)
+	} else {
+		services.DefaultKnowledgeWatcher = watcher
+		defer watcher.Close()
+	}
+
 	// Initialize authentication and authorization services.
 	if err := auth.Init(db.DB, appCfg.Auth); err != nil {
 		log.Fatalf("Failed to initialize auth: %v", err)
@@ -63,6 +90,9 @@ func main() {
 
 		// Login is public; me/reset-password need JWT but not Casbin.
 		api.RegisterPublicAuthRoutes(publicAPI)
+
+		// Shared conversations are read by people without an account.
+		api.RegisterPublicShareRoutes(publicAPI)
 	}
 
 	// Auth-required but Casbin-exempt routes (for password reset flow).
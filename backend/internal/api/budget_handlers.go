@@ -0,0 +1,214 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BudgetRequest sets the limits of a role default or user override. Zero is unlimited.
+type BudgetRequest struct {
+	DailyTokens   int64   `json:"daily_tokens"`
+	MonthlyTokens int64   `json:"monthly_tokens"`
+	DailyCost     float64 `json:"daily_cost"`
+	MonthlyCost   float64 `json:"monthly_cost"`
+	WarnAt        float64 `json:"warn_at"`
+}
+
+func (r BudgetRequest) validate() error {
+	if r.DailyTokens < 0 || r.MonthlyTokens < 0 || r.DailyCost < 0 || r.MonthlyCost < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if r.WarnAt < 0 || r.WarnAt > 1 {
+		return errors.New("warn_at must be between 0 and 1")
+	}
+	return nil
+}
+
+func (r BudgetRequest) apply(b *models.Budget) {
+	b.DailyTokens = r.DailyTokens
+	b.MonthlyTokens = r.MonthlyTokens
+	b.DailyCost = r.DailyCost
+	b.MonthlyCost = r.MonthlyCost
+	b.WarnAt = r.WarnAt
+	if b.WarnAt == 0 {
+		b.WarnAt = 0.8
+	}
+}
+
+// requireAdmin writes 401/403 and returns false unless the caller is an admin.
+func requireAdmin(c *gin.Context) bool {
+	current, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return false
+	}
+	if !auth.IsAdmin(current) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return false
+	}
+	return true
+}
+
+// GetBudgets lists role defaults and per-user overrides (admin only).
+func GetBudgets(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	var budgets []models.Budget
+	if err := db.DB.Order("user_id asc, role asc").Find(&budgets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, budgets)
+}
+
+// SetRoleBudget creates or replaces the default budget of a role (admin only).
+func SetRoleBudget(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	role := c.Param("role")
+	if role != models.BudgetRoleAdmin && role != models.BudgetRoleUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be admin or user"})
+		return
+	}
+
+	var req BudgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var budget models.Budget
+	if err := db.DB.Where("role = ? AND user_id = 0", role).Limit(1).Find(&budget).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	budget.Role = role
+	req.apply(&budget)
+	if err := db.DB.Save(&budget).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, budget)
+}
+
+// GetUserBudget returns a user's effective budget and current consumption (admin only).
+func GetUserBudget(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	var user models.User
+	if err := db.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	respondBudgetStatus(c, &user)
+}
+
+// GetMyBudget returns the caller's effective budget and current consumption.
+func GetMyBudget(c *gin.Context) {
+	current, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	respondBudgetStatus(c, current)
+}
+
+func respondBudgetStatus(c *gin.Context, user *models.User) {
+	status, err := services.NewBudgetService(db.DB).Status(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// SetUserBudget creates or replaces a user's budget override (admin only).
+func SetUserBudget(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	var user models.User
+	if err := db.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	var req BudgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var budget models.Budget
+	if err := db.DB.Where("user_id = ?", user.ID).Limit(1).Find(&budget).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	budget.UserID = user.ID
+	budget.Role = ""
+	req.apply(&budget)
+	if err := db.DB.Save(&budget).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, budget)
+}
+
+// DeleteUserBudget removes a user's override so the role default applies again (admin only).
+func DeleteUserBudget(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	if err := db.DB.Where("user_id = ?", c.Param("id")).Delete(&models.Budget{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Budget override removed"})
+}
+
+// ResetUserBudget restarts a user's daily and/or monthly consumption (admin only).
+// Body: {"period": "daily"|"monthly"}; omit period to reset both.
+func ResetUserBudget(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	var user models.User
+	if err := db.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	var req struct {
+		Period string `json:"period"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	svc := services.NewBudgetService(db.DB)
+	if err := svc.Reset(user.ID, req.Period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	respondBudgetStatus(c, &user)
+}
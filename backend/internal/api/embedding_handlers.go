@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmbeddingRequest follows the OpenAI embeddings API. The model is chosen either by
+// catalogue model_id or by provider ID plus model name.
+type EmbeddingRequest struct {
+	ModelID   uint            `json:"model_id"`
+	Provider  string          `json:"provider"`
+	Model     string          `json:"model"`
+	Input     json.RawMessage `json:"input" binding:"required"`
+	InputType llm.InputType   `json:"input_type"` // query, document or empty
+}
+
+type RerankRequest struct {
+	ModelID   uint     `json:"model_id"`
+	Provider  string   `json:"provider"`
+	Model     string   `json:"model"`
+	Query     string   `json:"query" binding:"required"`
+	Documents []string `json:"documents" binding:"required"`
+	TopN      int      `json:"top_n"`
+}
+
+// resolveModel finds the enabled provider and model name for a request and checks the
+// catalogue capability when the model is listed there. Only admins (allowUnlisted) may
+// call models that are not in the catalogue, which has no price for them.
+func resolveModel(modelID uint, providerID, modelName string, capability models.ModelCapability, allowUnlisted bool) (models.Provider, string, error) {
+	var model models.Model
+	if modelID != 0 {
+		if err := db.DB.Preload("Provider").First(&model, modelID).Error; err != nil || model.Provider == nil {
+			return models.Provider{}, "", fmt.Errorf("model %d not found", modelID)
+		}
+	} else {
+		if providerID == "" || modelName == "" {
+			return models.Provider{}, "", errors.New("model_id or provider and model are required")
+		}
+		var provider models.Provider
+		if err := db.DB.Where("provider_id = ?", providerID).First(&provider).Error; err != nil {
+			return models.Provider{}, "", fmt.Errorf("provider %s not found", providerID)
+		}
+		model = models.Model{ModelID: modelName, Provider: &provider}
+		var listed models.Model
+		if db.DB.Where("provider_id = ? AND model_id = ?", provider.ID, modelName).First(&listed).Error == nil {
+			model.Capabilities = listed.Capabilities
+		} else if !allowUnlisted {
+			return models.Provider{}, "", fmt.Errorf("model %s is not in the model catalogue", modelName)
+		}
+	}
+
+	if !model.Provider.Enabled {
+		return models.Provider{}, "", fmt.Errorf("provider %s is disabled", model.Provider.ProviderID)
+	}
+	if len(model.Capabilities) > 0 {
+		found := false
+		for _, c := range model.Capabilities {
+			found = found || c == capability
+		}
+		if !found {
+			return models.Provider{}, "", fmt.Errorf("model %s does not have the %s capability", model.ModelID, capability)
+		}
+	}
+	return *model.Provider, model.ModelID, nil
+}
+
+// checkBudget answers the request and returns false when the user has used up a budget.
+func checkBudget(c *gin.Context, user *models.User) bool {
+	err := services.NewBudgetService(db.DB).Check(user)
+	if errors.Is(err, services.ErrBudgetExceeded) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// recordInputUsage books an embedding or rerank call on the user's usage.
+func recordInputUsage(user *models.User, provider models.Provider, modelName string, texts []string) {
+	uc := llm.UsageContext{UserID: user.ID, Target: llm.ChatTarget{Provider: provider, Model: modelName}}
+	if _, err := llm.NewService(db.DB).RecordInputUsage(uc, texts); err != nil {
+		log.Printf("Failed to record usage for %s: %v", modelName, err)
+	}
+}
+
+// CreateEmbeddings embeds a string or list of strings and answers in the OpenAI format.
+func CreateEmbeddings(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req EmbeddingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var inputs []string
+	if err := json.Unmarshal(req.Input, &inputs); err != nil {
+		var single string
+		if err := json.Unmarshal(req.Input, &single); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "input must be a string or an array of strings"})
+			return
+		}
+		inputs = []string{single}
+	}
+	switch req.InputType {
+	case llm.InputTypeNone, llm.InputTypeQuery, llm.InputTypeDocument:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "input_type must be query or document"})
+		return
+	}
+
+	provider, modelName, err := resolveModel(req.ModelID, req.Provider, req.Model, models.CapabilityEmbedding, auth.IsAdmin(user))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkBudget(c, user) {
+		return
+	}
+
+	vectors, err := llm.NewService(db.DB).Embed(c.Request.Context(), provider, modelName, inputs, req.InputType)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	recordInputUsage(user, provider, modelName, inputs)
+	data := make([]gin.H, len(vectors))
+	for i, v := range vectors {
+		data[i] = gin.H{"object": "embedding", "index": i, "embedding": v}
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "model": modelName, "data": data})
+}
+
+// RerankDocuments orders documents by relevance to a query.
+func RerankDocuments(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req RerankRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	provider, modelName, err := resolveModel(req.ModelID, req.Provider, req.Model, models.CapabilityRerank, auth.IsAdmin(user))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkBudget(c, user) {
+		return
+	}
+
+	results, err := llm.NewService(db.DB).Rerank(c.Request.Context(), provider, modelName, req.Query, req.Documents, req.TopN)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	recordInputUsage(user, provider, modelName, append([]string{req.Query}, req.Documents...))
+	c.JSON(http.StatusOK, gin.H{"model": modelName, "results": results})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// inlineImageMimes are the raster image types GetFile lets browsers display inline.
+var inlineImageMimes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// GetFile serves a stored attachment by content hash. Users may only fetch files
+// attached to their own conversations; admins may fetch any.
+func GetFile(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	hash := c.Param("hash")
+	store := storage.Default()
+	if store == nil || !storage.ValidHash(hash) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	query := db.DB.Model(&models.Part{}).
+		Joins("JOIN messages ON messages.id = parts.message_id").
+		Joins("JOIN sessions ON sessions.id = messages.session_id").
+		Where("parts.type = ? AND parts.content = ?", models.PartTypeFile, hash)
+	if !auth.IsAdmin(user) {
+		query = query.Where("sessions.user_id = ?", user.ID)
+	}
+	var part models.Part
+	if err := query.Select("parts.*").First(&part).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	data, err := store.Get(hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var meta models.FilePartMeta
+	_ = json.Unmarshal(part.Meta, &meta)
+	mime := meta.Mime
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+	// Only raster images render inline; anything else (SVG, HTML, ...) is downloaded
+	// so uploaded content can never run as a page of this origin.
+	disposition := "attachment"
+	if inlineImageMimes[mime] {
+		disposition = "inline"
+	}
+	if meta.Filename != "" {
+		disposition += fmt.Sprintf("; filename=%q", meta.Filename)
+	}
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "sandbox")
+	// Content never changes for a hash, but responses are per-user.
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	c.Header("ETag", `"`+hash+`"`)
+	c.Data(http.StatusOK, mime, data)
+}
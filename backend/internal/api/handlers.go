@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"fnchatbot/internal/auth"
 	"fnchatbot/internal/db"
 	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/llm"
+	"fnchatbot/internal/services/memory"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,6 +34,11 @@ func GetModels(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range config {
+		if config[i].ProviderRef != nil {
+			maskProvider(config[i].ProviderRef)
+		}
+	}
 	c.JSON(http.StatusOK, config)
 }
 
@@ -39,6 +50,16 @@ type CreateModelConfigRequest struct {
 	Temperature float32 `json:"temperature"`
 	MaxTokens   int     `json:"max_tokens"`
 	IsDefault   bool    `json:"is_default"`
+	// Optional fallback chain and retry override
+	Fallbacks  []models.ModelFallback `json:"fallbacks"`
+	MaxRetries *int                   `json:"max_retries"`
+	// Context window handling: truncate (default), summarize or none
+	ContextStrategy string `json:"context_strategy"`
+	ContextWindow   int    `json:"context_window"`
+	// Extended thinking budget in tokens for models that need it enabled (Anthropic)
+	ThinkingBudget int `json:"thinking_budget"`
+	// Tool loop limits of replies; 0 = application default
+	models.AgentLimits
 }
 
 func CreateModel(c *gin.Context) {
@@ -59,6 +80,23 @@ func CreateModel(c *gin.Context) {
 		return
 	}
 
+	if err := validateContextSettings(&req.ContextStrategy, &req.ContextWindow, &req.ThinkingBudget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateAgentLimits(req.AgentLimits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ContextStrategy == "" {
+		req.ContextStrategy = string(llm.ContextStrategyTruncate)
+	}
+
+	if err := validateRetrySettings(req.Fallbacks, req.MaxRetries); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	config := models.ModelConfig{
 		Name:        req.Name,
 		Provider:    req.Provider,
@@ -68,6 +106,13 @@ func CreateModel(c *gin.Context) {
 		MaxTokens:   req.MaxTokens,
 		IsDefault:   req.IsDefault,
 		UserID:      user.ID,
+		Fallbacks:   req.Fallbacks,
+		MaxRetries:  req.MaxRetries,
+
+		ContextStrategy: req.ContextStrategy,
+		ContextWindow:   req.ContextWindow,
+		ThinkingBudget:  req.ThinkingBudget,
+		AgentLimits:     req.AgentLimits,
 	}
 
 	if err := db.DB.Create(&config).Error; err != nil {
@@ -77,6 +122,120 @@ func CreateModel(c *gin.Context) {
 	c.JSON(http.StatusOK, config)
 }
 
+// maxModelRetries bounds max_retries of a model config; every retry waits for a backoff.
+const maxModelRetries = 10
+
+// validateRetrySettings checks the fallback chain and retry override of a model config.
+// Fallback providers must exist and be enabled.
+func validateRetrySettings(fallbacks []models.ModelFallback, maxRetries *int) error {
+	if maxRetries != nil && (*maxRetries < 0 || *maxRetries > maxModelRetries) {
+		return fmt.Errorf("max_retries must be between 0 and %d", maxModelRetries)
+	}
+	for _, fb := range fallbacks {
+		if fb.ProviderID == 0 || fb.Model == "" {
+			return fmt.Errorf("fallback requires provider_id and model")
+		}
+		var provider models.Provider
+		if err := db.DB.First(&provider, fb.ProviderID).Error; err != nil {
+			return fmt.Errorf("fallback provider %d not found", fb.ProviderID)
+		}
+		if !provider.Enabled {
+			return fmt.Errorf("fallback provider %d is disabled", fb.ProviderID)
+		}
+	}
+	return nil
+}
+
+// UpdateModelConfigRequest changes the fallback chain, retry override and context
+// settings of a model config; nil fields are kept.
+type UpdateModelConfigRequest struct {
+	Fallbacks       *[]models.ModelFallback `json:"fallbacks"`
+	MaxRetries      *int                    `json:"max_retries"`
+	ContextStrategy *string                 `json:"context_strategy"`
+	ContextWindow   *int                    `json:"context_window"`
+	ThinkingBudget  *int                    `json:"thinking_budget"`
+}
+
+// UpdateModelConfig updates one of the caller's model configs.
+func UpdateModelConfig(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var config models.ModelConfig
+	if err := db.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&config).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Model config not found"})
+		return
+	}
+	var req UpdateModelConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Fallbacks != nil {
+		if err := validateRetrySettings(*req.Fallbacks, nil); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		config.Fallbacks = *req.Fallbacks
+	}
+	if req.MaxRetries != nil {
+		if err := validateRetrySettings(nil, req.MaxRetries); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		config.MaxRetries = req.MaxRetries
+	}
+	if err := validateContextSettings(req.ContextStrategy, req.ContextWindow, req.ThinkingBudget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ContextStrategy != nil {
+		config.ContextStrategy = *req.ContextStrategy
+		if config.ContextStrategy == "" {
+			config.ContextStrategy = string(llm.ContextStrategyTruncate)
+		}
+	}
+	if req.ContextWindow != nil {
+		config.ContextWindow = *req.ContextWindow
+	}
+	if req.ThinkingBudget != nil {
+		config.ThinkingBudget = *req.ThinkingBudget
+	}
+	if err := db.DB.Model(&config).
+		Select("fallbacks", "max_retries", "context_strategy", "context_window", "thinking_budget").
+		Updates(&config).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
+
+// validateContextSettings checks the context strategy, window and thinking budget of a
+// model config; nil values are not checked.
+func validateContextSettings(strategy *string, window, thinkingBudget *int) error {
+	if strategy != nil && !llm.ValidContextStrategy(*strategy) {
+		return fmt.Errorf("context_strategy must be truncate, summarize or none")
+	}
+	if window != nil && *window < 0 {
+		return fmt.Errorf("context_window must not be negative")
+	}
+	if thinkingBudget != nil && *thinkingBudget != 0 && (*thinkingBudget < 1024 || *thinkingBudget > 128000) {
+		return fmt.Errorf("thinking_budget must be 0 or between 1024 and 128000")
+	}
+	return nil
+}
+
+// validateAgentLimits rejects negative tool loop limits; zero inherits the default.
+func validateAgentLimits(l models.AgentLimits) error {
+	if l.MaxTurns < 0 || l.MaxToolCalls < 0 || l.MaxDurationSeconds < 0 {
+		return fmt.Errorf("max_turns, max_tool_calls and max_duration_seconds must not be negative")
+	}
+	return nil
+}
+
 func resolveProviderID(providerID uint, providerKey string) (uint, error) {
 	if providerID > 0 {
 		return providerID, nil
@@ -100,14 +259,105 @@ func GetSessions(c *gin.Context) {
 		return
 	}
 
-	var sessions []models.Session
-	if err := db.DB.Where("user_id = ?", user.ID).Order("created_at desc").Find(&sessions).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	filter, err := sessionFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sessions, total, err := services.NewSessionService(db.DB).List(user.ID, filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
 	c.JSON(http.StatusOK, sessions)
 }
 
+// sessionFilter reads the query parameters of GetSessions: archived (true, false or
+// all; default false), pinned, folder, tag, q (part of the title), model_id, sort
+// (created, updated or title), order (asc or desc; default desc, asc for title), and
+// page and page_size. Without page every conversation is returned. The number of
+// matching conversations is sent in the X-Total-Count header.
+func sessionFilter(c *gin.Context) (services.SessionFilter, error) {
+	f := services.SessionFilter{
+		Tag:   c.Query("tag"),
+		Query: c.Query("q"),
+		Sort:  c.Query("sort"),
+		Desc:  c.Query("sort") != "title",
+	}
+	switch c.Query("archived") {
+	case "", "false":
+		f.Archived = services.ArchivedExclude
+	case "true":
+		f.Archived = services.ArchivedOnly
+	case "all":
+		f.Archived = services.ArchivedAll
+	default:
+		return f, fmt.Errorf("archived must be true, false or all")
+	}
+	if v := c.Query("pinned"); v != "" {
+		pinned, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid pinned")
+		}
+		f.Pinned = &pinned
+	}
+	if folder, ok := c.GetQuery("folder"); ok {
+		f.Folder = &folder
+	}
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		f.Desc = false
+	case "desc":
+		f.Desc = true
+	default:
+		return f, fmt.Errorf("order must be asc or desc")
+	}
+	var err error
+	if f.ModelID, err = queryUint(c, "model_id"); err != nil {
+		return f, err
+	}
+	if c.Query("page") != "" || c.Query("page_size") != "" {
+		if f.Page, f.PageSize, err = queryPage(c); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// UpdateSession renames a conversation, switches its model, pins or archives it, files
+// it into a folder and tags, or changes its agent limits. Fields left out of the body
+// are kept.
+func UpdateSession(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var session models.Session
+	if err := db.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&session).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var req services.SessionUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateAgentLimits(req.AgentLimits(session.AgentLimits)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.NewSessionService(db.DB).Update(&session, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
 func CreateSession(c *gin.Context) {
 	user, ok := auth.CurrentUser(c)
 	if !ok {
@@ -115,18 +365,34 @@ func CreateSession(c *gin.Context) {
 		return
 	}
 	var input struct {
-		Title   string `json:"title"`
-		ModelID uint   `json:"model_id"`
+		Title     string `json:"title"`
+		ModelID   uint   `json:"model_id"`
+		PersonaID *uint  `json:"persona_id"`
+		models.AgentLimits
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateAgentLimits(input.AgentLimits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.PersonaID != nil && !personaVisible(*input.PersonaID, user.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "persona not found"})
+		return
+	}
 
 	session := models.Session{
-		Title:   input.Title,
-		ModelID: input.ModelID,
-		UserID:  user.ID,
+		Title:     strings.TrimSpace(input.Title),
+		ModelID:   input.ModelID,
+		UserID:    user.ID,
+		PersonaID: input.PersonaID,
+
+		AgentLimits: input.AgentLimits,
+	}
+	if session.Title != "" {
+		session.TitleSource = models.TitleSourceUser
 	}
 	if err := db.DB.Create(&session).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -135,6 +401,9 @@ func CreateSession(c *gin.Context) {
 	c.JSON(http.StatusOK, session)
 }
 
+// GetSessionMessages returns the active branch of a conversation. With branch=<message
+// id> it returns the branch through that message instead, and with branch=all every
+// message of the tree.
 func GetSessionMessages(c *gin.Context) {
 	user, ok := auth.CurrentUser(c)
 	if !ok {
@@ -142,19 +411,98 @@ func GetSessionMessages(c *gin.Context) {
 		return
 	}
 
-	id := c.Param("id")
-	// Ensure session belongs to current user.
+	// Same rule as the websocket: the owner or an admin
 	var session models.Session
-	if err := db.DB.Where("id = ? AND user_id = ?", id, user.ID).First(&session).Error; err != nil {
+	if err := db.DB.Where("id = ?", c.Param("id")).First(&session).Error; err != nil || !auth.CanAccessSession(user, &session) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 		return
 	}
 
-	var messages []models.Message
-	if err := db.DB.Where("session_id = ?", id).Preload("Parts").Order("created_at asc").Find(&messages).Error; err != nil {
+	tree, err := memory.NewSQLiteHistory(db.DB, session.ID).Tree(c.Request.Context())
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	branch := c.Query("branch")
+	if branch == "all" {
+		var messages []models.Message
+		if err := db.DB.Where("session_id = ?", session.ID).Preload("Parts").Preload("Usage").Order("created_at asc, id asc").Find(&messages).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range messages {
+			messages[i].Siblings = tree.Siblings(messages[i].ID)
+		}
+		c.JSON(http.StatusOK, messages)
+		return
+	}
+
+	leaf := tree.Leaf()
+	if branch != "" {
+		messageID, err := strconv.ParseUint(branch, 10, 32)
+		if err != nil || !tree.Has(uint(messageID)) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		leaf = tree.LatestLeaf(uint(messageID))
+	}
+	respondBranch(c, tree, leaf)
+}
+
+// SwitchSessionBranch makes the branch through a message the active branch of a
+// conversation, for moving between regenerated replies and edited prompts.
+func SwitchSessionBranch(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var session models.Session
+	if err := db.DB.Where("id = ?", c.Param("id")).First(&session).Error; err != nil || !auth.CanAccessSession(user, &session) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var req struct {
+		MessageID uint `json:"message_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hist := memory.NewSQLiteHistory(db.DB, session.ID)
+	if err := hist.Activate(c.Request.Context(), req.MessageID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	tree, err := hist.Tree(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondBranch(c, tree, tree.Leaf())
+}
+
+// respondBranch writes the messages from the root down to leaf, each with its siblings.
+func respondBranch(c *gin.Context, tree *memory.Tree, leaf uint) {
+	ids := tree.Path(leaf)
+	messages := make([]models.Message, 0, len(ids))
+	if len(ids) > 0 {
+		if err := db.DB.Where("id IN ?", ids).Preload("Parts").Preload("Usage").Find(&messages).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	order := make(map[uint]int, len(ids))
+	for i, id := range ids {
+		order[id] = i
+	}
+	sort.Slice(messages, func(i, j int) bool { return order[messages[i].ID] < order[messages[j].ID] })
+	for i := range messages {
+		messages[i].Siblings = tree.Siblings(messages[i].ID)
+	}
 	c.JSON(http.StatusOK, messages)
 }
 
@@ -170,6 +518,10 @@ func DeleteSession(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := db.DB.Where("session_id = ? AND user_id = ?", id, user.ID).Delete(&models.ShareLink{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Session deleted"})
 }
 
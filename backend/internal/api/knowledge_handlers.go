@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type KnowledgeBaseRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	Description  string   `json:"description"`
+	ModelID      uint     `json:"model_id" binding:"required"`
+	Paths        []string `json:"paths" binding:"required"`
+	ChunkSize    int      `json:"chunk_size"`
+	ChunkOverlap int      `json:"chunk_overlap"`
+}
+
+type KnowledgeSearchRequest struct {
+	Query            string `json:"query" binding:"required"`
+	KnowledgeBaseIDs []uint `json:"knowledge_base_ids"`
+	TopK             int    `json:"top_k"`
+}
+
+// findKnowledgeBase loads a knowledge base owned by the current user.
+func findKnowledgeBase(c *gin.Context) (*models.KnowledgeBase, bool) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	var kb models.KnowledgeBase
+	if err := db.DB.Preload("Model").Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&kb).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Knowledge base not found"})
+		return nil, false
+	}
+	return &kb, true
+}
+
+// applyKnowledgeRequest validates req and copies it onto kb.
+func applyKnowledgeRequest(svc *services.KnowledgeService, kb *models.KnowledgeBase, req KnowledgeBaseRequest) error {
+	if _, err := svc.EmbeddingModel(req.ModelID); err != nil {
+		return err
+	}
+	paths, err := svc.ValidatePaths(req.Paths)
+	if err != nil {
+		return err
+	}
+	if req.ChunkSize <= 0 {
+		req.ChunkSize = 1000
+	}
+	if req.ChunkOverlap < 0 || req.ChunkOverlap >= req.ChunkSize {
+		return errors.New("chunk_overlap must be between 0 and chunk_size")
+	}
+	kb.Name = req.Name
+	kb.Description = req.Description
+	kb.ModelID = req.ModelID
+	kb.Paths = paths
+	kb.ChunkSize = req.ChunkSize
+	kb.ChunkOverlap = req.ChunkOverlap
+	return nil
+}
+
+// startIndexing re-indexes a knowledge base in the background and refreshes the watcher.
+// A full run requested during another run is queued behind it.
+func startIndexing(kbID uint, full bool) {
+	if services.DefaultKnowledgeWatcher != nil {
+		services.DefaultKnowledgeWatcher.Sync()
+	}
+	go func() {
+		_, err := services.NewKnowledgeService(db.DB).Index(context.Background(), kbID, full)
+		if full && errors.Is(err, services.ErrKnowledgeIndexing) {
+			log.Printf("Knowledge base %d: full re-index queued behind the current run", kbID)
+			return
+		}
+		if err != nil {
+			log.Printf("Knowledge base %d: indexing failed: %v", kbID, err)
+		}
+	}()
+}
+
+// GetKnowledgeBases lists the caller's knowledge bases.
+func GetKnowledgeBases(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var kbs []models.KnowledgeBase
+	if err := db.DB.Preload("Model").Where("user_id = ?", user.ID).Order("id asc").Find(&kbs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, kbs)
+}
+
+// GetKnowledgeBase returns a knowledge base with its indexed files.
+func GetKnowledgeBase(c *gin.Context) {
+	kb, ok := findKnowledgeBase(c)
+	if !ok {
+		return
+	}
+	var files []models.KnowledgeFile
+	if err := db.DB.Where("knowledge_base_id = ?", kb.ID).Order("path asc").Find(&files).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"knowledge_base": kb, "files": files})
+}
+
+// CreateKnowledgeBase creates a knowledge base and starts indexing it.
+func CreateKnowledgeBase(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req KnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	kb := models.KnowledgeBase{UserID: user.ID, Status: models.KnowledgeStatusIdle}
+	if err := applyKnowledgeRequest(services.NewKnowledgeService(db.DB), &kb, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.DB.Create(&kb).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	startIndexing(kb.ID, false)
+	c.JSON(http.StatusCreated, kb)
+}
+
+// UpdateKnowledgeBase changes a knowledge base. Changing the embedding model or the
+// chunking re-embeds every file.
+func UpdateKnowledgeBase(c *gin.Context) {
+	kb, ok := findKnowledgeBase(c)
+	if !ok {
+		return
+	}
+	var req KnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prev := *kb
+	if err := applyKnowledgeRequest(services.NewKnowledgeService(db.DB), kb, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	kb.Model = nil
+	if err := db.DB.Save(kb).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	full := prev.ModelID != kb.ModelID || prev.ChunkSize != kb.ChunkSize || prev.ChunkOverlap != kb.ChunkOverlap
+	startIndexing(kb.ID, full)
+	c.JSON(http.StatusOK, kb)
+}
+
+// DeleteKnowledgeBase removes a knowledge base and its index.
+func DeleteKnowledgeBase(c *gin.Context) {
+	kb, ok := findKnowledgeBase(c)
+	if !ok {
+		return
+	}
+	if err := services.NewKnowledgeService(db.DB).Delete(kb.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if services.DefaultKnowledgeWatcher != nil {
+		services.DefaultKnowledgeWatcher.Sync()
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Knowledge base deleted"})
+}
+
+// ReindexKnowledgeBase starts indexing; ?full=true re-embeds unchanged files too and is
+// queued if a run is in progress.
+func ReindexKnowledgeBase(c *gin.Context) {
+	kb, ok := findKnowledgeBase(c)
+	if !ok {
+		return
+	}
+	full := c.Query("full") == "true"
+	if kb.Status == models.KnowledgeStatusIndexing && !full {
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrKnowledgeIndexing.Error()})
+		return
+	}
+	startIndexing(kb.ID, full)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Indexing started"})
+}
+
+// SearchKnowledge runs a semantic search over the caller's knowledge bases.
+func SearchKnowledge(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req KnowledgeSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkBudget(c, user) {
+		return
+	}
+	hits, err := services.NewKnowledgeService(db.DB).Search(c.Request.Context(), user.ID, req.Query, req.KnowledgeBaseIDs, req.TopK)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": hits})
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
@@ -36,7 +37,7 @@ func GetMCPs(c *gin.Context) {
 		}
 		list = append(list, models.MCPServerInfo{
 			Name:            name,
-			MCPServerConfig: cfg,
+			MCPServerConfig: services.MaskMCPConfig(cfg),
 			MCPStatus:       st,
 		})
 	}
@@ -70,7 +71,7 @@ func GetMCP(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, models.MCPServerInfo{
 		Name:            name,
-		MCPServerConfig: *cfg,
+		MCPServerConfig: services.MaskMCPConfig(*cfg),
 		MCPStatus:       st,
 	})
 }
@@ -102,7 +103,7 @@ func CreateMCP(c *gin.Context) {
 		return
 	}
 	if err := services.DefaultMCPService.SetServer(name, body.MCPServerConfig); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(setServerStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	status := services.DefaultMCPService.GetStatus()
@@ -112,7 +113,7 @@ func CreateMCP(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, models.MCPServerInfo{
 		Name:            name,
-		MCPServerConfig: body.MCPServerConfig,
+		MCPServerConfig: services.MaskMCPConfig(body.MCPServerConfig),
 		MCPStatus:       st,
 	})
 }
@@ -138,7 +139,7 @@ func UpdateMCP(c *gin.Context) {
 		return
 	}
 	if err := services.DefaultMCPService.SetServer(name, cfg); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(setServerStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	status := services.DefaultMCPService.GetStatus()
@@ -148,7 +149,7 @@ func UpdateMCP(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, models.MCPServerInfo{
 		Name:            name,
-		MCPServerConfig: cfg,
+		MCPServerConfig: services.MaskMCPConfig(cfg),
 		MCPStatus:       st,
 	})
 }
@@ -211,3 +212,11 @@ func CheckMCP(c *gin.Context) {
 	st := services.DefaultMCPService.CheckServer(ctx, name)
 	c.JSON(http.StatusOK, st)
 }
+
+// setServerStatus maps a SetServer error to an HTTP status.
+func setServerStatus(err error) int {
+	if errors.Is(err, services.ErrMaskedSecret) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -32,8 +32,10 @@ type AddModelRequest struct {
 	SupportedEndpointTypes []models.EndpointType    `json:"supported_endpoint_types"`
 	EndpointType           models.EndpointType      `json:"endpoint_type"`
 	MaxTokens              int                      `json:"max_tokens"`
+	ContextLength          int                      `json:"context_length"`
 	InputPrice             float64                  `json:"input_price"`
 	OutputPrice            float64                  `json:"output_price"`
+	ImagePrice             float64                  `json:"image_price"`
 	SupportedTextDelta     bool                     `json:"supported_text_delta"`
 	Enabled                bool                     `json:"enabled"`
 }
@@ -64,8 +66,10 @@ func AddModelToProvider(c *gin.Context) {
 		SupportedEndpointTypes: req.SupportedEndpointTypes,
 		EndpointType:           req.EndpointType,
 		MaxTokens:              req.MaxTokens,
+		ContextLength:          req.ContextLength,
 		InputPrice:             req.InputPrice,
 		OutputPrice:            req.OutputPrice,
+		ImagePrice:             req.ImagePrice,
 		SupportedTextDelta:     req.SupportedTextDelta,
 		Enabled:                req.Enabled,
 		IsDefault:              false,
@@ -93,8 +97,10 @@ type UpdateModelRequest struct {
 	SupportedEndpointTypes []models.EndpointType    `json:"supported_endpoint_types"`
 	EndpointType           models.EndpointType      `json:"endpoint_type"`
 	MaxTokens              int                      `json:"max_tokens"`
+	ContextLength          int                      `json:"context_length"`
 	InputPrice             float64                  `json:"input_price"`
 	OutputPrice            float64                  `json:"output_price"`
+	ImagePrice             float64                  `json:"image_price"`
 	SupportedTextDelta     *bool                    `json:"supported_text_delta"`
 	Enabled                *bool                    `json:"enabled"`
 }
@@ -141,12 +147,18 @@ func UpdateModelHandler(c *gin.Context) {
 	if req.MaxTokens > 0 {
 		model.MaxTokens = req.MaxTokens
 	}
+	if req.ContextLength > 0 {
+		model.ContextLength = req.ContextLength
+	}
 	if req.InputPrice > 0 {
 		model.InputPrice = req.InputPrice
 	}
 	if req.OutputPrice > 0 {
 		model.OutputPrice = req.OutputPrice
 	}
+	if req.ImagePrice > 0 {
+		model.ImagePrice = req.ImagePrice
+	}
 	if req.SupportedTextDelta != nil {
 		model.SupportedTextDelta = *req.SupportedTextDelta
 	}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"net/http"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SystemPromptRequest struct {
+	Name        string             `json:"name" binding:"required"`
+	Description string             `json:"description"`
+	Scope       models.PromptScope `json:"scope" binding:"required"`
+	Content     string             `json:"content" binding:"required"`
+	Enabled     *bool              `json:"enabled"`
+	Shared      bool               `json:"shared"` // persona visible to all users (admin only)
+}
+
+type PreviewPromptRequest struct {
+	Content   string `json:"content"`
+	SessionID uint   `json:"session_id"`
+}
+
+// canEditPrompt reports whether user may modify prompt: owners edit their own,
+// admins edit global prompts and shared personas.
+func canEditPrompt(user *models.User, prompt models.SystemPrompt) bool {
+	if prompt.UserID == 0 {
+		return auth.IsAdmin(user)
+	}
+	return prompt.UserID == user.ID
+}
+
+// GetSystemPrompts lists global prompts, shared personas and the caller's own prompts.
+func GetSystemPrompts(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	query := db.DB.Where("user_id = 0 OR user_id = ?", user.ID)
+	if scope := c.Query("scope"); scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+	var prompts []models.SystemPrompt
+	if err := query.Order("scope asc, id asc").Find(&prompts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prompts)
+}
+
+// GetPromptVariables lists the variables available in prompt templates.
+func GetPromptVariables(c *gin.Context) {
+	c.JSON(http.StatusOK, services.PromptVariableNames)
+}
+
+// CreateSystemPrompt creates a prompt template. Global prompts and shared personas
+// require admin.
+func CreateSystemPrompt(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req SystemPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt := models.SystemPrompt{
+		Name:        req.Name,
+		Description: req.Description,
+		Scope:       req.Scope,
+		Content:     req.Content,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+		UserID:      user.ID,
+	}
+	switch req.Scope {
+	case models.PromptScopeGlobal:
+		prompt.UserID = 0
+	case models.PromptScopePersona:
+		if req.Shared {
+			prompt.UserID = 0
+		}
+	case models.PromptScopeUser:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be global, user or persona"})
+		return
+	}
+	if !canEditPrompt(user, prompt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	if err := db.DB.Create(&prompt).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, prompt)
+}
+
+// UpdateSystemPrompt edits a prompt's name, description, content or enabled flag.
+func UpdateSystemPrompt(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var prompt models.SystemPrompt
+	if err := db.DB.First(&prompt, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt not found"})
+		return
+	}
+	if !canEditPrompt(user, prompt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var req SystemPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Scope != prompt.Scope {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope cannot be changed"})
+		return
+	}
+
+	prompt.Name = req.Name
+	prompt.Description = req.Description
+	prompt.Content = req.Content
+	if req.Enabled != nil {
+		prompt.Enabled = *req.Enabled
+	}
+	if err := db.DB.Save(&prompt).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prompt)
+}
+
+// DeleteSystemPrompt removes a prompt; sessions using it as persona fall back to none.
+func DeleteSystemPrompt(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var prompt models.SystemPrompt
+	if err := db.DB.First(&prompt, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt not found"})
+		return
+	}
+	if !canEditPrompt(user, prompt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	if err := db.DB.Delete(&prompt).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if prompt.Scope == models.PromptScopePersona {
+		db.DB.Model(&models.Session{}).Where("persona_id = ?", prompt.ID).Update("persona_id", nil)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Prompt deleted"})
+}
+
+// PreviewSystemPrompt renders a template (or, without content, the full prompt of a
+// session) with the caller's current variables.
+func PreviewSystemPrompt(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req PreviewPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var session models.Session
+	if req.SessionID != 0 {
+		if err := db.DB.Preload("Model").Where("id = ? AND user_id = ?", req.SessionID, user.ID).First(&session).Error; err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
+	svc := services.NewPromptService(db.DB)
+	if req.Content != "" {
+		c.JSON(http.StatusOK, gin.H{"prompt": services.RenderPrompt(req.Content, svc.Variables(*user, session))})
+		return
+	}
+	prompt, err := svc.BuildSystemPrompt(*user, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"prompt": prompt})
+}
+
+// SetSessionPersona selects (or clears, with null) the persona of a conversation.
+func SetSessionPersona(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var session models.Session
+	if err := db.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&session).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var req struct {
+		PersonaID *uint `json:"persona_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.PersonaID != nil && !personaVisible(*req.PersonaID, user.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "persona not found"})
+		return
+	}
+
+	if err := db.DB.Model(&session).Update("persona_id", req.PersonaID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	session.PersonaID = req.PersonaID
+	c.JSON(http.StatusOK, session)
+}
+
+// personaVisible reports whether personaID is a persona owned by userID or shared.
+func personaVisible(personaID, userID uint) bool {
+	var count int64
+	db.DB.Model(&models.SystemPrompt{}).
+		Where("id = ? AND scope = ? AND (user_id = ? OR user_id = 0)", personaID, models.PromptScopePersona, userID).
+		Count(&count)
+	return count > 0
+}
@@ -5,8 +5,10 @@ import (
 
 	"fnchatbot/internal/db"
 	"fnchatbot/internal/models"
+	"fnchatbot/internal/secrets"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type CreateProviderRequest struct {
@@ -17,16 +19,20 @@ type CreateProviderRequest struct {
 	APIKey     string                    `json:"api_key"`
 	Enabled    bool                      `json:"enabled"`
 	ApiOptions models.ProviderApiOptions `json:"api_options"`
+	// Additional keys for rotation; stored in provider_keys
+	APIKeys      []string            `json:"api_keys"`
+	KeySelection models.KeySelection `json:"key_selection"`
 }
 
 type UpdateProviderRequest struct {
-	ProviderID string                    `json:"provider_id"`
-	Name       string                    `json:"name"`
-	Type       models.ProviderType       `json:"type"`
-	BaseURL    string                    `json:"base_url"`
-	APIKey     string                    `json:"api_key"`
-	Enabled    bool                      `json:"enabled"`
-	ApiOptions models.ProviderApiOptions `json:"api_options"`
+	ProviderID   string                    `json:"provider_id"`
+	Name         string                    `json:"name"`
+	Type         models.ProviderType       `json:"type"`
+	BaseURL      string                    `json:"base_url"`
+	APIKey       string                    `json:"api_key"`
+	Enabled      bool                      `json:"enabled"`
+	ApiOptions   models.ProviderApiOptions `json:"api_options"`
+	KeySelection models.KeySelection       `json:"key_selection"`
 }
 
 type FetchModelsResponse struct {
@@ -46,20 +52,24 @@ type ModelDetail struct {
 
 func GetProviders(c *gin.Context) {
 	var providers []models.Provider
-	if err := db.DB.Preload("Models").Find(&providers).Error; err != nil {
+	if err := db.DB.Preload("Models").Preload("Keys").Find(&providers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range providers {
+		maskProvider(&providers[i])
+	}
 	c.JSON(http.StatusOK, providers)
 }
 
 func GetProvider(c *gin.Context) {
 	id := c.Param("id")
 	var provider models.Provider
-	if err := db.DB.Preload("Models").First(&provider, id).Error; err != nil {
+	if err := db.DB.Preload("Models").Preload("Keys").First(&provider, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
 		return
 	}
+	maskProvider(&provider)
 	c.JSON(http.StatusOK, provider)
 }
 
@@ -69,16 +79,40 @@ func CreateProvider(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !validKeySelection(req.KeySelection) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key_selection"})
+		return
+	}
+	if req.KeySelection == "" {
+		req.KeySelection = models.KeySelectionRoundRobin
+	}
+	apiKey, err := secrets.Encrypt(req.APIKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt api key"})
+		return
+	}
 
 	provider := models.Provider{
-		ProviderID: req.ProviderID,
-		Name:       req.Name,
-		Type:       req.Type,
-		BaseURL:    req.BaseURL,
-		APIKey:     req.APIKey,
-		Enabled:    req.Enabled,
-		IsSystem:   false,
-		ApiOptions: req.ApiOptions,
+		ProviderID:   req.ProviderID,
+		Name:         req.Name,
+		Type:         req.Type,
+		BaseURL:      req.BaseURL,
+		APIKey:       apiKey,
+		Enabled:      req.Enabled,
+		IsSystem:     false,
+		ApiOptions:   req.ApiOptions,
+		KeySelection: req.KeySelection,
+	}
+	for _, key := range req.APIKeys {
+		if key == "" {
+			continue
+		}
+		encrypted, err := secrets.Encrypt(key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt api key"})
+			return
+		}
+		provider.Keys = append(provider.Keys, models.ProviderKey{Key: encrypted, Enabled: true})
 	}
 
 	if err := db.DB.Create(&provider).Error; err != nil {
@@ -86,6 +120,7 @@ func CreateProvider(c *gin.Context) {
 		return
 	}
 
+	maskProvider(&provider)
 	c.JSON(http.StatusOK, provider)
 }
 
@@ -115,17 +150,35 @@ func UpdateProvider(c *gin.Context) {
 	if req.BaseURL != "" {
 		provider.BaseURL = req.BaseURL
 	}
-	if req.APIKey != "" {
-		provider.APIKey = req.APIKey
+	// Clients echo back the masked key when it is unchanged
+	if secrets.LooksMasked(req.APIKey) && !secrets.IsMasked(req.APIKey, provider.APIKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_key is masked but does not match the stored key"})
+		return
+	}
+	if req.APIKey != "" && !secrets.IsMasked(req.APIKey, provider.APIKey) {
+		apiKey, err := secrets.Encrypt(req.APIKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt api key"})
+			return
+		}
+		provider.APIKey = apiKey
 	}
 	provider.Enabled = req.Enabled
 	provider.ApiOptions = req.ApiOptions
+	if req.KeySelection != "" {
+		if !validKeySelection(req.KeySelection) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key_selection"})
+			return
+		}
+		provider.KeySelection = req.KeySelection
+	}
 
 	if err := db.DB.Save(&provider).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	maskProvider(&provider)
 	c.JSON(http.StatusOK, provider)
 }
 
@@ -142,7 +195,14 @@ func DeleteProvider(c *gin.Context) {
 		return
 	}
 
-	if err := db.DB.Delete(&provider).Error; err != nil {
+	// The key pool goes with the provider
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("provider_id = ?", provider.ID).Delete(&models.ProviderKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&provider).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -164,6 +224,7 @@ func ToggleProvider(c *gin.Context) {
 		return
 	}
 
+	maskProvider(&provider)
 	c.JSON(http.StatusOK, provider)
 }
 
@@ -179,7 +240,11 @@ func FetchModels(c *gin.Context) {
 	_ = c.ShouldBindJSON(&req)
 
 	// baseURL := provider.BaseURL
-	apiKey := provider.APIKey
+	apiKey, err := secrets.Decrypt(provider.APIKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt api key"})
+		return
+	}
 	if req.BaseURL != "" {
 		// baseURL = req.BaseURL
 	}
@@ -219,3 +284,11 @@ func FetchModels(c *gin.Context) {
 
 	c.JSON(http.StatusOK, FetchModelsResponse{Models: []ModelDetail{}}) // result})
 }
+
+// maskProvider replaces stored secrets with display-safe values before responding.
+func maskProvider(p *models.Provider) {
+	p.APIKey = secrets.Mask(p.APIKey)
+	for i := range p.Keys {
+		p.Keys[i].MaskedKey = secrets.Mask(p.Keys[i].Key)
+	}
+}
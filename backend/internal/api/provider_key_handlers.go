@@ -0,0 +1,137 @@
+package api
+
+import (
+	"net/http"
+
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/secrets"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AddProviderKeyRequest struct {
+	Key     string `json:"key" binding:"required"`
+	Label   string `json:"label"`
+	Enabled *bool  `json:"enabled"`
+}
+
+type UpdateProviderKeyRequest struct {
+	Label         *string `json:"label"`
+	Enabled       *bool   `json:"enabled"`
+	ResetCooldown bool    `json:"reset_cooldown"`
+	ResetCounters bool    `json:"reset_counters"`
+}
+
+func validKeySelection(selection models.KeySelection) bool {
+	switch selection {
+	case "", models.KeySelectionRoundRobin, models.KeySelectionLeastRateLimited:
+		return true
+	}
+	return false
+}
+
+// GetProviderKeys lists a provider's pooled keys (masked) with usage and health.
+func GetProviderKeys(c *gin.Context) {
+	var provider models.Provider
+	if err := db.DB.First(&provider, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	var keys []models.ProviderKey
+	if err := db.DB.Where("provider_id = ?", provider.ID).Order("id asc").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range keys {
+		keys[i].MaskedKey = secrets.Mask(keys[i].Key)
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// AddProviderKey adds a key to a provider's rotation pool.
+func AddProviderKey(c *gin.Context) {
+	var provider models.Provider
+	if err := db.DB.First(&provider, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	var req AddProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	encrypted, err := secrets.Encrypt(req.Key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt api key"})
+		return
+	}
+
+	key := models.ProviderKey{
+		ProviderID: provider.ID,
+		Label:      req.Label,
+		Key:        encrypted,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+	}
+	if err := db.DB.Create(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	key.MaskedKey = secrets.Mask(key.Key)
+	c.JSON(http.StatusCreated, key)
+}
+
+// UpdateProviderKey toggles a pooled key, renames it, or clears its cooldown/counters.
+func UpdateProviderKey(c *gin.Context) {
+	var key models.ProviderKey
+	if err := db.DB.Where("id = ? AND provider_id = ?", c.Param("keyId"), c.Param("id")).First(&key).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+
+	var req UpdateProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Label != nil {
+		key.Label = *req.Label
+	}
+	if req.Enabled != nil {
+		key.Enabled = *req.Enabled
+	}
+	if req.ResetCooldown {
+		key.CooldownUntil = nil
+		key.LastError = ""
+	}
+	if req.ResetCounters {
+		key.UsageCount = 0
+		key.FailureCount = 0
+		key.RateLimitCount = 0
+	}
+
+	if err := db.DB.Save(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	key.MaskedKey = secrets.Mask(key.Key)
+	c.JSON(http.StatusOK, key)
+}
+
+// DeleteProviderKey removes a key from the pool.
+func DeleteProviderKey(c *gin.Context) {
+	result := db.DB.Where("id = ? AND provider_id = ?", c.Param("keyId"), c.Param("id")).Delete(&models.ProviderKey{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Key deleted"})
+}
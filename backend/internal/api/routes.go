@@ -15,6 +15,12 @@ func RegisterRoutes(r *gin.RouterGroup) {
 	r.PATCH("/providers/:id/toggle", ToggleProvider)
 	r.POST("/providers/:id/fetch-models", FetchModels)
 
+	// 供应商 API Key 池
+	r.GET("/providers/:id/keys", GetProviderKeys)
+	r.POST("/providers/:id/keys", AddProviderKey)
+	r.PATCH("/providers/:id/keys/:keyId", UpdateProviderKey)
+	r.DELETE("/providers/:id/keys/:keyId", DeleteProviderKey)
+
 	// 模型管理
 	r.GET("/providers/:id/models", GetProviderModels)
 	r.POST("/providers/:id/models", AddModelToProvider)
@@ -27,11 +33,63 @@ func RegisterRoutes(r *gin.RouterGroup) {
 	r.POST("/models", CreateModel)                  // 废弃：使用 POST /providers/:id/models
 	r.POST("/models/available", GetAvailableModels) // 废弃：使用 POST /providers/:id/fetch-models
 
+	// 对话模型配置：回退链与重试次数
+	r.PATCH("/models/configs/:id", UpdateModelConfig)
+
 	// 对话
 	r.GET("/conversations", GetSessions)
 	r.POST("/conversations", CreateSession)
+	r.GET("/conversations/search", SearchConversations) // 全文搜索消息
+	r.GET("/conversations/export", ExportSessions)      // 批量导出（zip）
+	r.POST("/conversations/import", ImportSessions)     // 导入 JSON / ChatGPT / Claude 导出文件
+	r.GET("/conversations/:id/export", ExportSession)   // 导出为 Markdown / JSON / HTML / OpenAI JSONL
 	r.GET("/conversations/:id/messages", GetSessionMessages)
+	r.POST("/conversations/:id/branch", SwitchSessionBranch) // 切换到兄弟分支
+	r.PATCH("/conversations/:id", UpdateSession)             // 重命名、切换模型、置顶、归档、文件夹和标签
 	r.DELETE("/conversations/:id", DeleteSession)
+	r.POST("/conversations/:id/persona", SetSessionPersona)
+
+	// 只读分享链接（公开访问见 RegisterPublicShareRoutes）
+	r.GET("/conversations/:id/shares", GetShares)
+	r.POST("/conversations/:id/shares", CreateShare)
+	r.DELETE("/conversations/:id/shares", RevokeShares)
+	r.DELETE("/conversations/:id/shares/:shareId", RevokeShare)
+
+	// 附件文件（内容寻址存储）
+	r.GET("/files/:hash", GetFile)
+
+	// 系统提示词与角色
+	r.GET("/prompts", GetSystemPrompts)
+	r.GET("/prompts/variables", GetPromptVariables)
+	r.POST("/prompts", CreateSystemPrompt)
+	r.POST("/prompts/preview", PreviewSystemPrompt)
+	r.PUT("/prompts/:id", UpdateSystemPrompt)
+	r.DELETE("/prompts/:id", DeleteSystemPrompt)
+
+	// 向量嵌入与重排序
+	r.POST("/embeddings", CreateEmbeddings)
+	r.POST("/rerank", RerankDocuments)
+
+	// 知识库（RAG）
+	r.GET("/knowledge", GetKnowledgeBases)
+	r.POST("/knowledge", CreateKnowledgeBase)
+	r.POST("/knowledge/search", SearchKnowledge)
+	r.GET("/knowledge/:id", GetKnowledgeBase)
+	r.PUT("/knowledge/:id", UpdateKnowledgeBase)
+	r.DELETE("/knowledge/:id", DeleteKnowledgeBase)
+	r.POST("/knowledge/:id/reindex", ReindexKnowledgeBase)
+
+	// 用量统计
+	r.GET("/usage", GetUsage)
+	r.GET("/usage/budget", GetMyBudget)
+
+	// 用量预算（管理员）
+	r.GET("/budgets", GetBudgets)
+	r.PUT("/budgets/roles/:role", SetRoleBudget)
+	r.GET("/users/:id/budget", GetUserBudget)
+	r.PUT("/users/:id/budget", SetUserBudget)
+	r.DELETE("/users/:id/budget", DeleteUserBudget)
+	r.POST("/users/:id/budget/reset", ResetUserBudget)
 
 	// Skills
 	r.GET("/skills", GetSkills)
@@ -48,6 +106,11 @@ func RegisterRoutes(r *gin.RouterGroup) {
 	r.DELETE("/mcp/:name", DeleteMCP)
 	r.POST("/mcp/:name/check", CheckMCP)
 
+	// 工具审批策略（auto / ask / deny）
+	r.GET("/tool-policies", GetToolPolicies)
+	r.PUT("/tool-policies", SetToolPolicy)
+	r.DELETE("/tool-policies/:id", DeleteToolPolicy)
+
 	// Sandbox
 	r.GET("/sandbox", GetSandboxConfig)
 	r.PUT("/sandbox", UpdateSandboxConfig)
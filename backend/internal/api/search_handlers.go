@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/memory"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// SearchConversations searches the text of the caller's messages.
+//
+// Query parameters: q (required), role (user or assistant), model_id, from and to
+// (dates as 2006-01-02, to inclusive, or RFC 3339 times), page and page_size. Admins can
+// pass all=true to search every user's conversations, optionally narrowed by user_id.
+func SearchConversations(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	q := memory.SearchQuery{
+		Text:   c.Query("q"),
+		UserID: user.ID,
+		Role:   models.MessageRole(c.Query("role")),
+	}
+	if q.Text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if q.Role != "" && q.Role != models.RoleUser && q.Role != models.RoleAssistant {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be user or assistant"})
+		return
+	}
+
+	var err error
+	if q.ModelID, err = queryUint(c, "model_id"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.From, err = queryTime(c, "from", false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.To, err = queryTime(c, "to", true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.Page, q.PageSize, err = queryPage(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("all") == "true" {
+		if !auth.IsAdmin(user) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only admins can search all conversations"})
+			return
+		}
+		if q.UserID, err = queryUint(c, "user_id"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	res, err := memory.Search(db.DB, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// queryPage parses the page (1-based) and page_size query parameters.
+func queryPage(c *gin.Context) (page, pageSize int, err error) {
+	page, pageSize = 1, defaultPageSize
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("page must be a positive number")
+		}
+	}
+	if v := c.Query("page_size"); v != "" {
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize < 1 || pageSize > maxPageSize {
+			return 0, 0, fmt.Errorf("page_size must be between 1 and %d", maxPageSize)
+		}
+	}
+	return page, pageSize, nil
+}
+
+func queryUint(c *gin.Context, name string) (uint, error) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return uint(n), nil
+}
+
+// queryTime parses a date or an RFC 3339 time. A date used as the end of a range
+// includes the whole day.
+func queryTime(c *gin.Context, name string, end bool) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: use 2006-01-02 or an RFC 3339 time", name)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/transcript"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterPublicShareRoutes registers the read-only view of shared conversations,
+// which needs no login.
+func RegisterPublicShareRoutes(r *gin.RouterGroup) {
+	r.GET("/share/:token", GetSharedConversation)
+}
+
+// ownSession loads a conversation of the caller, answering the request if it fails.
+func ownSession(c *gin.Context) (*models.Session, bool) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	var session models.Session
+	if err := db.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&session).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return &session, true
+}
+
+// CreateShare creates a read-only link to a conversation. The body optionally sets
+// expires_at and redact_tool_outputs.
+func CreateShare(c *gin.Context) {
+	session, ok := ownSession(c)
+	if !ok {
+		return
+	}
+	var opts services.ShareOptions
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	link, err := services.NewShareService(db.DB).Create(session.UserID, session.ID, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, link)
+}
+
+// GetShares lists the links to a conversation.
+func GetShares(c *gin.Context) {
+	session, ok := ownSession(c)
+	if !ok {
+		return
+	}
+	links, err := services.NewShareService(db.DB).List(session.UserID, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// RevokeShare deletes a link to a conversation.
+func RevokeShare(c *gin.Context) {
+	session, ok := ownSession(c)
+	if !ok {
+		return
+	}
+	linkID, err := strconv.ParseUint(c.Param("shareId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share id"})
+		return
+	}
+	err = services.NewShareService(db.DB).Revoke(session.UserID, session.ID, uint(linkID))
+	if errors.Is(err, services.ErrShareNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// RevokeShares deletes every link to a conversation.
+func RevokeShares(c *gin.Context) {
+	session, ok := ownSession(c)
+	if !ok {
+		return
+	}
+	if err := services.NewShareService(db.DB).RevokeAll(session.UserID, session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Share links revoked"})
+}
+
+// GetSharedConversation returns the active branch of a shared conversation, sanitized
+// by transcript.Sanitize. The format query parameter selects json (default), markdown
+// or html, which can be opened directly in a browser.
+func GetSharedConversation(c *gin.Context) {
+	format := c.DefaultQuery("format", transcript.FormatJSON)
+	if format != transcript.FormatJSON && format != transcript.FormatMarkdown && format != transcript.FormatHTML {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, markdown or html"})
+		return
+	}
+	link, session, err := services.NewShareService(db.DB).Resolve(c.Param("token"))
+	switch {
+	case errors.Is(err, services.ErrShareNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrShareExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	doc, err := transcript.LoadShared(c.Request.Context(), db.DB, *session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Revoked links must stop working at once, and shared pages stay out of search engines
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Header("Content-Security-Policy", "default-src 'none'; img-src data: https:; style-src 'unsafe-inline'")
+	c.Header("Content-Type", transcript.ContentType(format))
+	if err := transcript.Write(c.Writer, transcript.Sanitize(doc, link.RedactToolOutputs), format); err != nil {
+		log.Printf("Failed to render shared session %d: %v", session.ID, err)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ToolPolicyRequest sets the policy of a tool. Global policies apply to every user
+// and can only be set by admins.
+type ToolPolicyRequest struct {
+	Tool   string `json:"tool" binding:"required"`
+	Policy string `json:"policy" binding:"required"`
+	Global bool   `json:"global"`
+}
+
+// GetToolPolicies lists the admin-wide policies and the caller's own.
+func GetToolPolicies(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	policies, err := services.NewToolPolicyService(db.DB).List(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// SetToolPolicy creates or replaces the policy of a tool.
+func SetToolPolicy(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req ToolPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := user.ID
+	if req.Global {
+		if !auth.IsAdmin(user) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only admins can set global tool policies"})
+			return
+		}
+		userID = 0
+	}
+
+	policy, err := services.NewToolPolicyService(db.DB).Set(userID, req.Tool, req.Policy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeleteToolPolicy removes one of the caller's policies, or a global one for admins.
+func DeleteToolPolicy(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var policy models.ToolPolicy
+	if err := db.DB.First(&policy, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tool policy not found"})
+		return
+	}
+	if policy.UserID != user.ID && !(policy.UserID == 0 && auth.IsAdmin(user)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if err := db.DB.Delete(&policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tool policy deleted"})
+}
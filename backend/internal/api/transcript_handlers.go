@@ -0,0 +1,224 @@
+package api
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/transcript"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportBytes bounds uploaded export files; ChatGPT archives of long-time users are large.
+const maxImportBytes = 512 << 20
+
+// exportFormat reads the format query parameter, markdown by default.
+func exportFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", transcript.FormatMarkdown)
+	if transcript.Extension(format) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of " + strings.Join(transcript.Formats, ", ")})
+		return "", false
+	}
+	return format, true
+}
+
+// ExportSession downloads a conversation as markdown, json (every branch, attachments
+// inlined), html or openai-jsonl.
+func ExportSession(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	var session models.Session
+	if err := db.DB.Preload("Model").Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&session).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	doc, err := transcript.Load(c.Request.Context(), db.DB, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", transcript.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="conversation-%d.%s"`, session.ID, transcript.Extension(format)))
+	if err := transcript.Write(c.Writer, doc, format); err != nil {
+		log.Printf("Failed to export session %d: %v", session.ID, err)
+	}
+}
+
+// ExportSessions downloads several conversations as a zip with one file per
+// conversation. ids is a comma-separated list; without it every conversation of the
+// caller is exported, archived ones included.
+func ExportSessions(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	query := db.DB.Preload("Model").Where("user_id = ?", user.ID)
+	if v := c.Query("ids"); v != "" {
+		var ids []uint
+		for _, s := range strings.Split(v, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ids"})
+				return
+			}
+			ids = append(ids, uint(id))
+		}
+		query = query.Where("id IN ?", ids)
+	}
+	var sessions []models.Session
+	if err := query.Order("created_at asc").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(sessions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no conversations to export"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="conversations-%s.zip"`, time.Now().Format("20060102")))
+	zw := zip.NewWriter(c.Writer)
+	defer zw.Close()
+	for _, s := range sessions {
+		doc, err := transcript.Load(c.Request.Context(), db.DB, s)
+		if err != nil {
+			log.Printf("Failed to export session %d: %v", s.ID, err)
+			return
+		}
+		w, err := zw.Create(fmt.Sprintf("%d-%s.%s", s.ID, exportFileName(s.Title), transcript.Extension(format)))
+		if err != nil {
+			return
+		}
+		if err := transcript.Write(w, doc, format); err != nil {
+			log.Printf("Failed to export session %d: %v", s.ID, err)
+			return
+		}
+	}
+}
+
+// exportFileName makes a title usable as a file name.
+func exportFileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if r := []rune(name); len(r) > 60 {
+		name = string(r[:60])
+	}
+	if name == "" {
+		name = "conversation"
+	}
+	return name
+}
+
+// ImportSessions creates conversations from an uploaded export: a JSON or zip export of
+// this application, or a ChatGPT or Claude export (the zip or its conversations.json).
+// The file is sent as the multipart field "file" or as the request body. Imported
+// conversations use the model config model_id, or the caller's default one.
+func ImportSessions(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	// Exports are read from disk (multipart spills large files there, a raw body is
+	// spooled), never held in memory as a whole
+	var src io.ReaderAt
+	var size int64
+	var err error
+	if file, ferr := c.FormFile("file"); ferr == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer f.Close()
+		src, size = f, file.Size
+	} else {
+		tmp, terr := os.CreateTemp("", "import-*")
+		if terr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": terr.Error()})
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, c.Request.Body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		src = tmp
+	}
+
+	var modelID uint64
+	if v := c.DefaultQuery("model_id", c.PostForm("model_id")); v != "" {
+		if modelID, err = strconv.ParseUint(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid model_id"})
+			return
+		}
+	}
+	var model models.ModelConfig
+	q := db.DB.Where("user_id = ?", user.ID)
+	if modelID != 0 {
+		q = q.Where("id = ?", modelID)
+	}
+	if err := q.Order("is_default desc, id asc").Limit(1).Find(&model).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if modelID != 0 && model.ID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model config not found"})
+		return
+	}
+
+	// Each conversation is saved as soon as it is parsed; on failure the response lists
+	// what was imported before it
+	sessions := []models.Session{}
+	var saveErr error
+	err = transcript.Parse(src, size, func(doc *transcript.Document) error {
+		session, err := transcript.Save(c.Request.Context(), db.DB, user.ID, model.ID, doc)
+		if err != nil {
+			saveErr = err
+			return err
+		}
+		sessions = append(sessions, *session)
+		return nil
+	})
+	if saveErr != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": saveErr.Error(), "imported": sessions})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "imported": sessions})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"imported": sessions})
+}
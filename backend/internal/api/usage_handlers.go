@@ -0,0 +1,157 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"fnchatbot/internal/auth"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// usageGroupColumns maps the group_by query value to the column aggregated on.
+var usageGroupColumns = map[string]string{
+	"user":     "user_id",
+	"model":    "model",
+	"provider": "provider_id",
+	"session":  "session_id",
+	"day":      "substr(created_at, 1, 10)",
+}
+
+// UsageRow is one aggregated bucket of token usage.
+type UsageRow struct {
+	Key              string  `json:"key"`
+	Label            string  `json:"label,omitempty"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	ReasoningTokens  int64   `json:"reasoning_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+const usageSums = "COUNT(*) AS requests, " +
+	"COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(reasoning_tokens), 0) AS reasoning_tokens, " +
+	"COALESCE(SUM(cached_tokens), 0) AS cached_tokens, " +
+	"COALESCE(SUM(total_tokens), 0) AS total_tokens, " +
+	"COALESCE(SUM(cost), 0) AS cost"
+
+// GetUsage aggregates token usage and cost.
+//
+// Query params: group_by (user|model|provider|session|day, default day),
+// from/to (YYYY-MM-DD, inclusive), user_id (admins only), session_id, provider_id, model.
+// Non-admin users only ever see their own usage.
+func GetUsage(c *gin.Context) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", "day")
+	column, ok := usageGroupColumns[groupBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be one of user, model, provider, session, day"})
+		return
+	}
+
+	query := db.DB.Model(&models.TokenUsage{})
+	if auth.IsAdmin(user) {
+		if uid := c.Query("user_id"); uid != "" {
+			query = query.Where("user_id = ?", uid)
+		}
+	} else {
+		query = query.Where("user_id = ?", user.ID)
+	}
+	if sid := c.Query("session_id"); sid != "" {
+		query = query.Where("session_id = ?", sid)
+	}
+	if pid := c.Query("provider_id"); pid != "" {
+		query = query.Where("provider_id = ?", pid)
+	}
+	if model := c.Query("model"); model != "" {
+		query = query.Where("model = ?", model)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("created_at < ?", t.AddDate(0, 0, 1))
+	}
+
+	var totals UsageRow
+	if err := query.Session(&gorm.Session{}).Select(usageSums).Scan(&totals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows := []UsageRow{}
+	if err := query.Select(column + " AS `key`, " + usageSums).Group(column).Order("`key` asc").Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	labelUsageRows(groupBy, rows)
+
+	c.JSON(http.StatusOK, gin.H{
+		"group_by": groupBy,
+		"totals":   totals,
+		"items":    rows,
+	})
+}
+
+// labelUsageRows attaches human-readable names to ID-keyed buckets.
+func labelUsageRows(groupBy string, rows []UsageRow) {
+	ids := make([]uint, 0, len(rows))
+	for _, r := range rows {
+		if id, err := strconv.ParseUint(r.Key, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	labels := map[string]string{}
+	switch groupBy {
+	case "user":
+		var users []models.User
+		db.DB.Select("id", "username").Where("id IN ?", ids).Find(&users)
+		for _, u := range users {
+			labels[strconv.FormatUint(uint64(u.ID), 10)] = u.Username
+		}
+	case "provider":
+		var providers []models.Provider
+		db.DB.Select("id", "name").Where("id IN ?", ids).Find(&providers)
+		for _, p := range providers {
+			labels[strconv.FormatUint(uint64(p.ID), 10)] = p.Name
+		}
+	case "session":
+		var sessions []models.Session
+		db.DB.Select("id", "title").Where("id IN ?", ids).Find(&sessions)
+		for _, s := range sessions {
+			labels[strconv.FormatUint(uint64(s.ID), 10)] = s.Title
+		}
+	default:
+		return
+	}
+	for i := range rows {
+		rows[i].Label = labels[rows[i].Key]
+	}
+}
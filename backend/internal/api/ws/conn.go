@@ -0,0 +1,174 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait bounds a single frame write and how long a sender waits for queue space
+	writeWait = 10 * time.Second
+	// pongWait is how long the connection may stay silent before it is considered dead
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so pongs arrive in time
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize allows attachments, which are sent inline as base64
+	maxMessageSize = 64 << 20
+	// sendQueueSize is the number of outbound frames buffered per connection
+	sendQueueSize = 256
+)
+
+// errConnClosed is returned when sending on a connection that is shutting down.
+var errConnClosed = errors.New("websocket connection closed")
+
+// Conn wraps a websocket so that frames from the read loop, the reply goroutine and
+// keepalive pings are written by a single writer goroutine, as gorilla/websocket
+// allows only one concurrent writer.
+type Conn struct {
+	ws   *websocket.Conn
+	send chan []byte
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	closeMsg  []byte
+	done      chan struct{}
+}
+
+// newConn starts the writer goroutine and keepalive for ws.
+func newConn(ws *websocket.Conn) *Conn {
+	c := &Conn{
+		ws:      ws,
+		send:    make(chan []byte, sendQueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	ws.SetReadLimit(maxMessageSize)
+	_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go c.writeLoop()
+	return c
+}
+
+// ReadMessage reads the next frame; any frame from the client proves it is alive.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	_, p, err := c.ws.ReadMessage()
+	if err == nil {
+		_ = c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	return p, err
+}
+
+// Send queues v as a JSON frame. When the client does not keep up and the queue stays
+// full for writeWait, the connection is closed rather than buffering without bound.
+func (c *Conn) Send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.queue(data, writeWait)
+}
+
+// queue adds an encoded frame to the send queue, waiting up to wait for space. A client
+// whose queue stays full is closed; it resumes from its last frame when it reconnects.
+func (c *Conn) queue(data []byte, wait time.Duration) error {
+	select {
+	case <-c.closing:
+		return errConnClosed
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return nil
+	case <-c.closing:
+		return errConnClosed
+	default:
+	}
+
+	if wait > 0 {
+		// Queue full: block the sender for a while
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case c.send <- data:
+			return nil
+		case <-c.closing:
+			return errConnClosed
+		case <-timer.C:
+		}
+	}
+	log.Printf("Websocket client too slow, closing connection")
+	c.Close(websocket.CloseTryAgainLater, "client too slow")
+	return errConnClosed
+}
+
+// Close flushes queued frames, sends a close frame with code and reason and closes the
+// socket. Only the first call has an effect; it does not wait for the writer.
+func (c *Conn) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(code, reason)
+		close(c.closing)
+	})
+}
+
+// Wait blocks until the writer goroutine has closed the socket.
+func (c *Conn) Wait() {
+	<-c.done
+}
+
+func (c *Conn) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		if err := c.ws.Close(); err != nil {
+			log.Printf("Failed to close websocket: %v", err)
+		}
+		close(c.done)
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			if err := c.write(websocket.TextMessage, data); err != nil {
+				log.Printf("Failed to write websocket frame: %v", err)
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.closing:
+			c.flush()
+			_ = c.ws.WriteControl(websocket.CloseMessage, c.closeMsg, time.Now().Add(writeWait))
+			return
+		}
+	}
+}
+
+// flush writes frames still queued when the connection is closed gracefully.
+func (c *Conn) flush() {
+	for {
+		select {
+		case data := <-c.send:
+			if err := c.write(websocket.TextMessage, data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Conn) write(messageType int, data []byte) error {
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(messageType, data)
+}
@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// startConn serves one websocket, hands the server side to fn and returns the client.
+func startConn(t *testing.T, fn func(c *Conn)) *websocket.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		fn(newConn(ws))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestConn_ConcurrentSends(t *testing.T) {
+	const senders, perSender = 8, 100
+	client := startConn(t, func(c *Conn) {
+		var wg sync.WaitGroup
+		for i := 0; i < senders; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < perSender; j++ {
+					assert.NoError(t, c.Send(WSMessage{Type: TypeMessage, Delta: fmt.Sprintf("%d-%d", i, j)}))
+				}
+			}(i)
+		}
+		wg.Wait()
+		c.Close(websocket.CloseNormalClosure, "done")
+		c.Wait()
+	})
+
+	// Every frame arrives intact and each sender's frames keep their order
+	next := make([]int, senders)
+	for n := 0; n < senders*perSender; n++ {
+		var msg WSMessage
+		if !assert.NoError(t, client.ReadJSON(&msg)) {
+			return
+		}
+		var i, j int
+		_, err := fmt.Sscanf(msg.Delta, "%d-%d", &i, &j)
+		assert.NoError(t, err)
+		assert.Equal(t, next[i], j)
+		next[i]++
+	}
+
+	_, _, err := client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+}
+
+func TestConn_SendAfterClose(t *testing.T) {
+	result := make(chan error, 1)
+	client := startConn(t, func(c *Conn) {
+		c.Close(websocket.CloseGoingAway, "bye")
+		result <- c.Send(WSMessage{Type: TypeNotice})
+		c.Wait()
+	})
+
+	_, _, err := client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+	assert.ErrorIs(t, <-result, errConnClosed)
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"fnchatbot/internal/config"
+	"fnchatbot/internal/models"
+)
+
+// Limits reported in limit_reached frames
+const (
+	LimitMaxTurns     = "max_turns"
+	LimitMaxToolCalls = "max_tool_calls"
+	LimitMaxDuration  = "max_duration"
+)
+
+// limitNote is added to the system prompt of the final call made once a limit is
+// reached; that call is made without tools.
+const limitNote = "The tool use limit for this reply has been reached and no more tools can be called. Answer the user now with what you have found so far, and say what is left undone."
+
+// errToolLimit is the result of tool calls dropped because the reply used up its tool calls.
+var errToolLimit = errors.New("tool call limit reached; the call was not executed")
+
+// agentLimits tracks the tool loop of one reply against its limits. Zero limits are
+// unlimited.
+type agentLimits struct {
+	models.AgentLimits
+	started   time.Time
+	turns     int
+	toolCalls int
+}
+
+// newAgentLimits resolves the limits of a reply: the session's, then its model
+// config's, then the application defaults.
+func newAgentLimits(session models.Session) *agentLimits {
+	cfg := config.GetConfig().Agent
+	defaults := models.AgentLimits{
+		MaxTurns:           cfg.MaxTurns,
+		MaxToolCalls:       cfg.MaxToolCalls,
+		MaxDurationSeconds: cfg.MaxDurationSeconds,
+	}
+	return &agentLimits{
+		AgentLimits: session.AgentLimits.Or(session.Model.AgentLimits).Or(defaults),
+		started:     time.Now(),
+	}
+}
+
+// reached returns the limit that keeps the reply from calling more tools, or "".
+func (l *agentLimits) reached() string {
+	switch {
+	case l.MaxTurns > 0 && l.turns >= l.MaxTurns:
+		return LimitMaxTurns
+	case l.MaxToolCalls > 0 && l.toolCalls >= l.MaxToolCalls:
+		return LimitMaxToolCalls
+	case l.MaxDurationSeconds > 0 && time.Since(l.started) >= time.Duration(l.MaxDurationSeconds)*time.Second:
+		return LimitMaxDuration
+	}
+	return ""
+}
+
+// allowToolCalls counts n requested tool calls and returns how many of them may run.
+func (l *agentLimits) allowToolCalls(n int) int {
+	if l.MaxToolCalls > 0 && l.toolCalls+n > l.MaxToolCalls {
+		n = l.MaxToolCalls - l.toolCalls
+		if n < 0 {
+			n = 0
+		}
+	}
+	l.toolCalls += n
+	return n
+}
+
+// describe explains a reached limit to the user.
+func (l *agentLimits) describe(limit string) string {
+	switch limit {
+	case LimitMaxTurns:
+		return fmt.Sprintf("Reached the limit of %d model turns for this reply", l.MaxTurns)
+	case LimitMaxToolCalls:
+		return fmt.Sprintf("Reached the limit of %d tool calls for this reply", l.MaxToolCalls)
+	case LimitMaxDuration:
+		return fmt.Sprintf("Reached the time limit of %s for this reply", time.Duration(l.MaxDurationSeconds)*time.Second)
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentLimits(t *testing.T) {
+	session := models.Session{
+		AgentLimits: models.AgentLimits{MaxToolCalls: 3},
+		Model:       models.ModelConfig{AgentLimits: models.AgentLimits{MaxTurns: 2, MaxToolCalls: 10}},
+	}
+	limits := newAgentLimits(session)
+	// Session overrides the model config, which overrides the application default
+	assert.Equal(t, 2, limits.MaxTurns)
+	assert.Equal(t, 3, limits.MaxToolCalls)
+	assert.Equal(t, 300, limits.MaxDurationSeconds)
+
+	assert.Equal(t, "", limits.reached())
+	assert.Equal(t, 2, limits.allowToolCalls(2))
+	assert.Equal(t, 1, limits.allowToolCalls(4))
+	assert.Equal(t, LimitMaxToolCalls, limits.reached())
+	assert.Equal(t, 0, limits.allowToolCalls(1))
+
+	limits.turns = 2
+	assert.Equal(t, LimitMaxTurns, limits.reached())
+	assert.Equal(t, "Reached the limit of 2 model turns for this reply", limits.describe(LimitMaxTurns))
+
+	timed := &agentLimits{AgentLimits: models.AgentLimits{MaxDurationSeconds: 1}, started: time.Now().Add(-2 * time.Second)}
+	assert.Equal(t, LimitMaxDuration, timed.reached())
+}
@@ -0,0 +1,267 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runRetention is how long the event log of a finished run is kept so that a client
+// reconnecting right after the reply ended still receives its tail.
+const runRetention = 5 * time.Minute
+
+// sender is where a reply writes its frames: a connection or a session hub.
+type sender interface {
+	Send(v interface{}) error
+}
+
+// run is one reply being generated for a session. Its frames are numbered and kept so
+// that connections joining later can replay them.
+type run struct {
+	id       string
+	seq      uint64
+	events   []*event
+	cancel   context.CancelFunc
+	done     chan struct{}
+	finished time.Time
+}
+
+// event is a recorded frame. Consecutive deltas of the same stream are merged into one
+// event to bound the log: msg carries the Seq of the last merged frame, first the Seq
+// of the first, and ends the length of the delta after each merged frame, so a replay
+// can resume in the middle.
+type event struct {
+	msg   WSMessage
+	first uint64
+	delta strings.Builder
+	ends  []int
+}
+
+// isDelta reports whether msg only streams a piece of text that can be merged.
+func isDelta(msg WSMessage) bool {
+	switch msg.Type {
+	case TypeMessage, TypeReasoning, TypeToolCallArgsDelta:
+		return msg.Delta != "" && msg.Content == ""
+	}
+	return false
+}
+
+// record numbers msg and appends it to the log, merging it into the previous event
+// when both are deltas of the same stream. Called with h.mu held.
+func (r *run) record(msg WSMessage) WSMessage {
+	r.seq++
+	msg.RunID = r.id
+	msg.Seq = r.seq
+	if n := len(r.events); n > 0 && isDelta(msg) {
+		last := r.events[n-1]
+		if last.ends != nil && last.msg.Type == msg.Type && last.msg.ToolCallID == msg.ToolCallID {
+			last.delta.WriteString(msg.Delta)
+			last.ends = append(last.ends, last.delta.Len())
+			last.msg.Delta = last.delta.String()
+			last.msg.Seq = msg.Seq
+			return msg
+		}
+	}
+	e := &event{msg: msg, first: msg.Seq}
+	if isDelta(msg) {
+		e.delta.WriteString(msg.Delta)
+		e.ends = []int{e.delta.Len()}
+	}
+	r.events = append(r.events, e)
+	return msg
+}
+
+func (r *run) active() bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// sessionHub fans the frames of a session's current run out to every connection open
+// on that session, so replies survive reconnects and can be watched from several tabs.
+//
+// mu guards the fields. Live frames are queued on each connection without waiting, so
+// one slow connection cannot stall the run or the others; it is closed instead.
+type sessionHub struct {
+	sessionID uint
+	mu        sync.Mutex
+	conns     map[*Conn]struct{}
+	run       *run
+	// attaching counts connections still replaying; they keep the hub alive.
+	attaching int
+}
+
+var hubs = struct {
+	sync.Mutex
+	m map[uint]*sessionHub
+}{m: make(map[uint]*sessionHub)}
+
+// attach subscribes conn to the hub of a session, creating the hub on first use, and
+// replays the current run's frames after lastSeq. A client that last saw a different
+// run gets an active run from the start; finished runs are only replayed on resume.
+// Frames are replayed without holding any lock; conn only joins the live fan-out once
+// it has caught up, so it sees every frame exactly once and in order.
+func attach(sessionID uint, conn *Conn, runID string, lastSeq uint64) *sessionHub {
+	hubs.Lock()
+	h, ok := hubs.m[sessionID]
+	if !ok {
+		h = &sessionHub{sessionID: sessionID, conns: make(map[*Conn]struct{})}
+		hubs.m[sessionID] = h
+	}
+	h.mu.Lock()
+	hubs.Unlock()
+	defer h.mu.Unlock()
+
+	r := h.run
+	if r == nil || (runID != r.id && !r.active()) {
+		h.conns[conn] = struct{}{}
+		return h
+	}
+	if runID != r.id {
+		lastSeq = 0
+	}
+	h.attaching++
+	for {
+		if h.run != r {
+			// A new run started while replaying; its frames are all new to conn
+			r, lastSeq = h.run, 0
+		}
+		pending := pendingFrames(r, lastSeq)
+		if len(pending) == 0 {
+			break
+		}
+		h.mu.Unlock()
+		err := sendFrames(conn, pending)
+		h.mu.Lock()
+		if err != nil {
+			h.attaching--
+			return h
+		}
+		lastSeq = pending[len(pending)-1].Seq
+	}
+	h.attaching--
+	h.conns[conn] = struct{}{}
+	return h
+}
+
+// pendingFrames returns copies of the frames of r after lastSeq. A merged delta the
+// client saw part of is cut to the part it missed. Called with h.mu held.
+func pendingFrames(r *run, lastSeq uint64) []WSMessage {
+	if r == nil {
+		return nil
+	}
+	i := sort.Search(len(r.events), func(i int) bool { return r.events[i].msg.Seq > lastSeq })
+	frames := make([]WSMessage, 0, len(r.events)-i)
+	for j, e := range r.events[i:] {
+		msg := e.msg
+		if j == 0 && lastSeq >= e.first {
+			msg.Delta = msg.Delta[e.ends[lastSeq-e.first]:]
+		}
+		frames = append(frames, msg)
+	}
+	return frames
+}
+
+// sendFrames sends frames to conn in order, stopping at the first failure.
+func sendFrames(conn *Conn, frames []WSMessage) error {
+	for _, ev := range frames {
+		if err := conn.Send(ev); err != nil {
+			log.Printf("Failed to replay frame %d: %v", ev.Seq, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribe removes conn; the hub is dropped once nothing uses it any more.
+func (h *sessionHub) unsubscribe(conn *Conn) {
+	h.mu.Lock()
+	delete(h.conns, conn)
+	h.mu.Unlock()
+	h.prune()
+}
+
+// prune drops the hub when no connection is open and no run needs to be kept.
+func (h *sessionHub) prune() {
+	hubs.Lock()
+	defer hubs.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.conns) > 0 || h.attaching > 0 || (h.run != nil && (h.run.active() || time.Since(h.run.finished) < runRetention)) {
+		return
+	}
+	if hubs.m[h.sessionID] == h {
+		delete(hubs.m, h.sessionID)
+	}
+}
+
+// start runs fn as the session's new run, detached from any connection. It returns
+// false while a previous run is still active.
+func (h *sessionHub) start(fn func(ctx context.Context, out sender)) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run != nil && h.run.active() {
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &run{
+		id:     fmt.Sprintf("%d-%d", h.sessionID, time.Now().UnixNano()),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	h.run = r
+	go func() {
+		defer func() {
+			cancel()
+			h.mu.Lock()
+			r.finished = time.Now()
+			close(r.done)
+			h.mu.Unlock()
+			time.AfterFunc(runRetention, h.prune)
+		}()
+		fn(ctx, h)
+	}()
+	return true
+}
+
+// stop cancels the active run, if any.
+func (h *sessionHub) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run != nil {
+		h.run.cancel()
+	}
+}
+
+// Send numbers a frame of the current run, records it and queues it on every
+// connection on the session. Connections that cannot take it are dropped.
+func (h *sessionHub) Send(v interface{}) error {
+	msg, ok := v.(WSMessage)
+	if !ok {
+		return fmt.Errorf("unsupported frame %T", v)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run == nil {
+		return fmt.Errorf("no run for session %d", h.sessionID)
+	}
+	msg = h.run.record(msg)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	for conn := range h.conns {
+		if err := conn.queue(data, 0); err != nil {
+			delete(h.conns, conn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package ws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func readSeqs(t *testing.T, client *websocket.Conn, n int) (string, []uint64) {
+	var runID string
+	var seqs []uint64
+	for i := 0; i < n; i++ {
+		var msg WSMessage
+		if !assert.NoError(t, client.ReadJSON(&msg)) {
+			break
+		}
+		runID = msg.RunID
+		seqs = append(seqs, msg.Seq)
+	}
+	return runID, seqs
+}
+
+func TestSessionHub_ResumeAndFanOut(t *testing.T) {
+	const sessionID = 4242
+	release := make(chan struct{})
+	attached := make(chan *sessionHub, 2)
+	serve := func(runID string, lastSeq uint64) func(c *Conn) {
+		return func(c *Conn) {
+			h := attach(sessionID, c, runID, lastSeq)
+			attached <- h
+			c.Wait()
+			h.unsubscribe(c)
+		}
+	}
+
+	first := startConn(t, serve("", 0))
+	hub := <-attached
+	assert.True(t, hub.start(func(ctx context.Context, out sender) {
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, sendJSON(out, WSMessage{Type: TypeMessage, Delta: "x"}))
+		}
+		<-release
+		assert.NoError(t, sendJSON(out, WSMessage{Type: TypeMessageEnd}))
+	}))
+	assert.False(t, hub.start(func(context.Context, sender) {}), "only one run per session")
+
+	runID, seqs := readSeqs(t, first, 3)
+	assert.Equal(t, []uint64{1, 2, 3}, seqs)
+
+	// A reconnecting tab resumes after the last frame it saw
+	second := startConn(t, serve(runID, 2))
+	<-attached
+	_, seqs = readSeqs(t, second, 1)
+	assert.Equal(t, []uint64{3}, seqs)
+
+	close(release)
+	_, seqs = readSeqs(t, first, 1)
+	assert.Equal(t, []uint64{4}, seqs)
+	_, seqs = readSeqs(t, second, 1)
+	assert.Equal(t, []uint64{4}, seqs)
+}
+
+func TestRun_MergesDeltas(t *testing.T) {
+	r := &run{id: "r"}
+	r.record(WSMessage{Type: TypeReasoning, Delta: "think"})
+	for _, d := range []string{"a", "bc", "d"} {
+		r.record(WSMessage{Type: TypeMessage, Delta: d})
+	}
+	r.record(WSMessage{Type: TypeMessageEnd})
+	assert.Len(t, r.events, 3)
+
+	frames := pendingFrames(r, 0)
+	if assert.Len(t, frames, 3) {
+		assert.Equal(t, "think", frames[0].Delta)
+		assert.Equal(t, "abcd", frames[1].Delta)
+		assert.Equal(t, uint64(4), frames[1].Seq)
+		assert.Equal(t, uint64(5), frames[2].Seq)
+	}
+
+	// A client that saw "a" and "bc" only gets the rest of the merged delta
+	frames = pendingFrames(r, 3)
+	if assert.Len(t, frames, 2) {
+		assert.Equal(t, "d", frames[0].Delta)
+		assert.Equal(t, uint64(4), frames[0].Seq)
+	}
+	assert.Empty(t, pendingFrames(r, 5))
+}
@@ -0,0 +1,157 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/config"
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/llm"
+	"fnchatbot/internal/services/memory"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// titleTimeout bounds title generation, which delays the end of the first reply.
+const titleTimeout = 30 * time.Second
+
+// autoTitle names a conversation the user has not titled once its first exchange is
+// answered, and sends the title in a title frame. Failures are only logged.
+func autoTitle(ctx context.Context, out sender, llmService *llm.Service, session models.Session, targets []llm.ChatTarget, answer string) {
+	cfg := config.GetConfig().Conversation
+	if !cfg.AutoTitle || session.TitleSource != "" || strings.TrimSpace(answer) == "" || ctx.Err() != nil {
+		return
+	}
+	var prompts int64
+	if err := db.DB.Model(&models.Message{}).Where("session_id = ? AND role = ?", session.ID, models.RoleUser).Count(&prompts).Error; err != nil || prompts != 1 {
+		return
+	}
+	question := firstPrompt(ctx, session.ID)
+	if question == "" {
+		return
+	}
+
+	modelConfigID := session.Model.ID
+	if cfg.TitleModelConfigID != 0 {
+		titleTargets, err := modelConfigTargets(cfg.TitleModelConfigID)
+		if err != nil {
+			log.Printf("Title model unavailable, using the conversation's model: %v", err)
+		} else {
+			targets, modelConfigID = titleTargets, cfg.TitleModelConfigID
+		}
+	} else if len(targets) > 0 {
+		// Without a configured title model, the cheapest chat model of the conversation's
+		// provider is tried first; the conversation's own targets remain the fallback
+		if model := cheapestTitleModel(targets[0].Provider.ID); model != nil && model.ModelID != targets[0].Model {
+			targets = append([]llm.ChatTarget{{Provider: targets[0].Provider, Model: model.ModelID}}, targets...)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, titleTimeout)
+	defer cancel()
+	title, resp, target, err := llmService.GenerateTitle(ctx, targets, question, answer)
+	if resp != nil {
+		if _, err := llmService.RecordUsage(llm.UsageContext{
+			SessionID:     session.ID,
+			UserID:        session.UserID,
+			ModelConfigID: modelConfigID,
+			Target:        target,
+		}, nil, resp); err != nil {
+			log.Printf("Failed to record token usage: %v", err)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to generate a title for session %d: %v", session.ID, err)
+		return
+	}
+
+	// The user may have named the conversation in the meantime
+	res := db.DB.Model(&models.Session{}).
+		Where("id = ? AND (title_source = '' OR title_source IS NULL)", session.ID).
+		Updates(map[string]interface{}{"title": title, "title_source": models.TitleSourceAuto})
+	if res.Error != nil {
+		log.Printf("Failed to save title: %v", res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		return
+	}
+	if err := sendJSON(out, WSMessage{Type: TypeTitle, Title: title}); err != nil {
+		log.Printf("Failed to send title: %v", err)
+	}
+}
+
+// firstPrompt returns the text of the first user message of the active branch.
+func firstPrompt(ctx context.Context, sessionID uint) string {
+	msgs, err := memory.NewSQLiteHistory(db.DB, sessionID).Messages(ctx)
+	if err != nil {
+		log.Printf("Failed to load messages for title: %v", err)
+		return ""
+	}
+	for _, m := range msgs {
+		if m.GetType() == llms.ChatMessageTypeHuman {
+			return m.GetContent()
+		}
+	}
+	return ""
+}
+
+// cheapestTitleModel returns the enabled, priced chat model of a provider with the lowest
+// input and output price, or nil if the catalogue has none.
+func cheapestTitleModel(providerID uint) *models.Model {
+	var candidates []models.Model
+	err := db.DB.Where("provider_id = ? AND enabled = ? AND input_price + output_price > 0", providerID, true).
+		Order("input_price + output_price asc, id asc").
+		Find(&candidates).Error
+	if err != nil {
+		log.Printf("Failed to load title model candidates: %v", err)
+		return nil
+	}
+	return pickTitleModel(candidates)
+}
+
+// pickTitleModel returns the first plain text model of candidates. Reasoning models are
+// skipped because they are slow to answer, and so are models that cannot chat.
+func pickTitleModel(candidates []models.Model) *models.Model {
+next:
+	for i, m := range candidates {
+		if m.EndpointType == models.EndpointTypeImageGeneration {
+			continue
+		}
+		text := false
+		for _, c := range m.Capabilities {
+			switch c {
+			case models.CapabilityText:
+				text = true
+			case models.CapabilityReasoning, models.CapabilityEmbedding, models.CapabilityRerank, models.CapabilityImageGeneration:
+				continue next
+			}
+		}
+		if text {
+			return &candidates[i]
+		}
+	}
+	return nil
+}
+
+// modelConfigTargets returns the chat targets of a model config.
+func modelConfigTargets(id uint) ([]llm.ChatTarget, error) {
+	var cfg models.ModelConfig
+	if err := db.DB.Preload("ProviderRef").First(&cfg, id).Error; err != nil {
+		return nil, fmt.Errorf("model config %d: %w", id, err)
+	}
+	var provider models.Provider
+	if cfg.ProviderRef != nil {
+		provider = *cfg.ProviderRef
+	} else if err := db.DB.First(&provider, cfg.ProviderID).Error; err != nil {
+		return nil, fmt.Errorf("provider of model config %d: %w", id, err)
+	}
+	if !provider.Enabled {
+		return nil, fmt.Errorf("provider %s is disabled", provider.ProviderID)
+	}
+	return buildChatTargets(cfg, provider), nil
+}
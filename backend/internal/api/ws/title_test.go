@@ -0,0 +1,24 @@
+package ws
+
+import (
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickTitleModel(t *testing.T) {
+	text := []models.ModelCapability{models.CapabilityText}
+	candidates := []models.Model{
+		{ModelID: "embed", Capabilities: []models.ModelCapability{models.CapabilityEmbedding}},
+		{ModelID: "mini-reasoner", Capabilities: []models.ModelCapability{models.CapabilityText, models.CapabilityReasoning}},
+		{ModelID: "painter", EndpointType: models.EndpointTypeImageGeneration, Capabilities: text},
+		{ModelID: "nano", Capabilities: text},
+		{ModelID: "large", Capabilities: text},
+	}
+	if m := pickTitleModel(candidates); assert.NotNil(t, m) {
+		assert.Equal(t, "nano", m.ModelID)
+	}
+	assert.Nil(t, pickTitleModel(candidates[:3]))
+}
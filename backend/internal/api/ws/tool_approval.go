@@ -0,0 +1,151 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// approvalTimeout is how long a tool call waits for the user to answer a permission request.
+const approvalTimeout = 5 * time.Minute
+
+var errToolDenied = errors.New("the user denied the call")
+
+// toolApprover enforces tool policies on the calls of one reply. Calls to "ask" tools
+// send a permission_request frame with the arguments and wait for the user's
+// permission_response; "always allow" answers are kept on the session.
+type toolApprover struct {
+	out       sender
+	sessionID uint
+	tools     map[string]services.Tool
+	policies  services.ToolPolicies
+
+	mu      sync.Mutex
+	allowed map[string]bool // policy names allowed for the rest of the session
+}
+
+func newToolApprover(out sender, session models.Session, tools []services.Tool, policies services.ToolPolicies) *toolApprover {
+	a := &toolApprover{
+		out:       out,
+		sessionID: session.ID,
+		tools:     make(map[string]services.Tool, len(tools)),
+		policies:  policies,
+		allowed:   make(map[string]bool, len(session.AllowedTools)),
+	}
+	for _, t := range tools {
+		a.tools[t.Function.Name] = t
+	}
+	for _, name := range session.AllowedTools {
+		a.allowed[name] = true
+	}
+	return a
+}
+
+// offered drops denied tools so the model is not told about them.
+func (a *toolApprover) offered(tools []services.Tool) []services.Tool {
+	out := make([]services.Tool, 0, len(tools))
+	for _, t := range tools {
+		if a.policies.For(t) != models.ToolPolicyDeny {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// check returns nil when tc may run, asking the user first if the tool's policy says so.
+func (a *toolApprover) check(ctx context.Context, tc llms.ToolCall) error {
+	t, ok := a.tools[tc.FunctionCall.Name]
+	if !ok {
+		return fmt.Errorf("unknown tool %s", tc.FunctionCall.Name)
+	}
+	name := services.ToolPolicyName(t)
+	switch a.policies.For(t) {
+	case models.ToolPolicyDeny:
+		return fmt.Errorf("%s is blocked by a tool policy", name)
+	case models.ToolPolicyAsk:
+		a.mu.Lock()
+		allowed := a.allowed[name]
+		a.mu.Unlock()
+		if allowed {
+			return nil
+		}
+		return a.ask(ctx, tc, t, name)
+	}
+	return nil
+}
+
+func (a *toolApprover) ask(ctx context.Context, tc llms.ToolCall, t services.Tool, name string) error {
+	requestID, err := newRequestID()
+	if err != nil {
+		return err
+	}
+	ch := permissionManager.CreateRequest(requestID)
+	defer permissionManager.RemoveRequest(requestID)
+
+	if err := sendJSON(a.out, WSMessage{
+		Type:       TypePermissionRequest,
+		RequestID:  requestID,
+		ToolCallID: tc.ID,
+		ToolName:   tc.FunctionCall.Name,
+		Server:     t.Server,
+		Arguments:  tc.FunctionCall.Arguments,
+	}); err != nil {
+		log.Printf("Failed to send permission request: %v", err)
+	}
+
+	timer := time.NewTimer(approvalTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if !resp.Approved {
+			return errToolDenied
+		}
+		if resp.Remember {
+			a.remember(name)
+		}
+		return nil
+	case <-timer.C:
+		return errors.New("the permission request was not answered")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// remember allows a tool for the rest of the session.
+func (a *toolApprover) remember(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.allowed[name] {
+		return
+	}
+	a.allowed[name] = true
+	names := make([]string, 0, len(a.allowed))
+	for n := range a.allowed {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	if err := db.DB.Model(&models.Session{ID: a.sessionID}).Select("AllowedTools").Updates(models.Session{AllowedTools: names}).Error; err != nil {
+		log.Printf("Failed to save allowed tools: %v", err)
+	}
+}
+
+// newRequestID returns an unguessable permission request ID, since any connection may answer.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/db"
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestToolApprover_AskDenyAndRemember(t *testing.T) {
+	var err error
+	db.DB, err = gorm.Open(sqlite.Open("file:approver?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.DB.AutoMigrate(&models.Session{}, &models.ToolPolicy{}))
+	session := models.Session{Title: "t", UserID: 1}
+	require.NoError(t, db.DB.Create(&session).Error)
+
+	policySvc := services.NewToolPolicyService(db.DB)
+	_, err = policySvc.Set(1, "mail__send", models.ToolPolicyAsk)
+	require.NoError(t, err)
+	_, err = policySvc.Set(1, "mail__delete", models.ToolPolicyDeny)
+	require.NoError(t, err)
+	policies, err := policySvc.Policies(1)
+	require.NoError(t, err)
+
+	tools := []services.Tool{
+		{Function: services.ToolSchema{Name: "send"}, Server: "mail"},
+		{Function: services.ToolSchema{Name: "delete"}, Server: "mail"},
+		{Function: services.ToolSchema{Name: "TodoWrite"}, Server: services.ToolOriginBuiltin},
+	}
+	out := &recorder{}
+	approver := newToolApprover(out, session, tools, policies)
+	assert.Len(t, approver.offered(tools), 2, "denied tools are not offered")
+
+	ctx := context.Background()
+	assert.NoError(t, approver.check(ctx, toolCall("1", "TodoWrite")))
+	assert.EqualError(t, approver.check(ctx, toolCall("2", "delete")), "mail__delete is blocked by a tool policy")
+	assert.Error(t, approver.check(ctx, toolCall("3", "unknown")))
+
+	// answer answers the next permission request once it was sent
+	answer := func(resp PermissionResponse) {
+		go func() {
+			for {
+				out.mu.Lock()
+				n := len(out.frames)
+				var id string
+				if n > 0 && out.frames[n-1].Type == TypePermissionRequest {
+					id = out.frames[n-1].RequestID
+				}
+				out.mu.Unlock()
+				if id != "" {
+					permissionManager.SetResponse(id, resp)
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	send := toolCall("4", "send")
+	send.FunctionCall.Arguments = `{"to":"a"}`
+	answer(PermissionResponse{Approved: false})
+	assert.Equal(t, errToolDenied, approver.check(ctx, send))
+	assert.Equal(t, `{"to":"a"}`, out.frames[0].Arguments)
+	assert.Equal(t, "mail", out.frames[0].Server)
+
+	out.frames = nil
+	answer(PermissionResponse{Approved: true, Remember: true})
+	assert.NoError(t, approver.check(ctx, toolCall("5", "send")))
+
+	// Remembered for the session: no further prompt, and kept for the next reply
+	out.frames = nil
+	assert.NoError(t, approver.check(ctx, toolCall("6", "send")))
+	assert.Empty(t, out.frames)
+	var saved models.Session
+	require.NoError(t, db.DB.First(&saved, session.ID).Error)
+	assert.Equal(t, []string{"mail__send"}, saved.AllowedTools)
+}
+
+func TestPermissionManager_DropsUnknownResponses(t *testing.T) {
+	pm := &PermissionManager{pending: make(map[string]chan PermissionResponse)}
+	pm.SetResponse("unknown", PermissionResponse{Approved: true})
+	assert.Empty(t, pm.pending)
+
+	ch := pm.CreateRequest("1")
+	pm.SetResponse("1", PermissionResponse{Approved: true})
+	assert.True(t, (<-ch).Approved)
+	assert.Empty(t, pm.pending, "answered requests are forgotten")
+}
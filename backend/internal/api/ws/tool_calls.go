@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/llm"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// maxToolOutput bounds the tool output sent in tool_call_result frames; the model still
+// receives the full output.
+const maxToolOutput = 4000
+
+// toolCallEvents sends the structured tool call frames of one reply: tool_call_start
+// once per call, tool_call_args_delta while the model streams the arguments, then
+// tool_call_result or tool_call_error after execution.
+type toolCallEvents struct {
+	out     sender
+	servers map[string]string // tool name -> origin
+
+	mu      sync.Mutex      // calls of a turn may finish concurrently
+	started map[string]bool // call ID -> tool_call_start sent
+}
+
+func newToolCallEvents(out sender, tools []services.Tool) *toolCallEvents {
+	servers := make(map[string]string, len(tools))
+	for _, t := range tools {
+		servers[t.Function.Name] = t.Server
+	}
+	return &toolCallEvents{out: out, servers: servers, started: make(map[string]bool)}
+}
+
+func (e *toolCallEvents) server(name string) string {
+	if s := e.servers[name]; s != "" {
+		return s
+	}
+	return services.ToolOriginBuiltin
+}
+
+// start announces a call unless it was already announced while streaming.
+func (e *toolCallEvents) start(id, name string) {
+	e.mu.Lock()
+	sent := e.started[id]
+	e.started[id] = true
+	e.mu.Unlock()
+	if sent {
+		return
+	}
+	e.send(WSMessage{Type: TypeToolCallStart, ToolCallID: id, ToolName: name, Server: e.server(name)})
+}
+
+// delta forwards a streamed piece of a tool call.
+func (e *toolCallEvents) delta(d llm.ToolCallDelta) {
+	if d.Name != "" {
+		e.start(d.ID, d.Name)
+	}
+	if d.Arguments != "" {
+		e.send(WSMessage{Type: TypeToolCallArgsDelta, ToolCallID: d.ID, Delta: d.Arguments})
+	}
+}
+
+// finish reports the outcome of an executed call.
+func (e *toolCallEvents) finish(tc llms.ToolCall, result string, err error, elapsed time.Duration) {
+	name := tc.FunctionCall.Name
+	e.start(tc.ID, name)
+	msg := WSMessage{
+		ToolCallID: tc.ID,
+		ToolName:   name,
+		Server:     e.server(name),
+		Arguments:  tc.FunctionCall.Arguments,
+		DurationMs: elapsed.Milliseconds(),
+	}
+	if err != nil {
+		msg.Type = TypeToolCallError
+		msg.Error = err.Error()
+	} else {
+		msg.Type = TypeToolCallResult
+		msg.Output, msg.Truncated = truncateOutput(result, maxToolOutput)
+	}
+	e.send(msg)
+}
+
+func (e *toolCallEvents) send(msg WSMessage) {
+	if err := sendJSON(e.out, msg); err != nil {
+		log.Printf("Failed to send %s frame: %v", msg.Type, err)
+	}
+}
+
+// truncateOutput cuts s to at most max bytes without splitting a UTF-8 sequence.
+func truncateOutput(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut], true
+}
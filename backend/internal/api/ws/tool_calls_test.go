@@ -0,0 +1,64 @@
+package ws
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/llm"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	frames []WSMessage
+}
+
+func (r *recorder) Send(v interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, v.(WSMessage))
+	return nil
+}
+
+func TestToolCallEvents(t *testing.T) {
+	out := &recorder{}
+	events := newToolCallEvents(out, []services.Tool{
+		{Function: services.ToolSchema{Name: "read_file"}, Server: "filesystem"},
+	})
+
+	events.delta(llm.ToolCallDelta{ID: "call_1", Name: "read_file"})
+	events.delta(llm.ToolCallDelta{ID: "call_1", Arguments: `{"path":"a"}`})
+	call := llms.ToolCall{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "read_file", Arguments: `{"path":"a"}`}}
+	events.finish(call, strings.Repeat("x", maxToolOutput+10), nil, 1500*time.Millisecond)
+
+	// A call that was not streamed is announced when it finishes
+	other := llms.ToolCall{ID: "call_2", FunctionCall: &llms.FunctionCall{Name: "TodoWrite"}}
+	events.finish(other, "", errors.New("boom"), 0)
+
+	types := make([]string, len(out.frames))
+	for i, f := range out.frames {
+		types[i] = f.Type
+	}
+	assert.Equal(t, []string{TypeToolCallStart, TypeToolCallArgsDelta, TypeToolCallResult, TypeToolCallStart, TypeToolCallError}, types)
+	assert.Equal(t, "filesystem", out.frames[0].Server)
+	assert.Equal(t, int64(1500), out.frames[2].DurationMs)
+	assert.True(t, out.frames[2].Truncated)
+	assert.Len(t, out.frames[2].Output, maxToolOutput)
+	assert.Equal(t, services.ToolOriginBuiltin, out.frames[3].Server)
+	assert.Equal(t, "boom", out.frames[4].Error)
+}
+
+func TestTruncateOutput(t *testing.T) {
+	s, truncated := truncateOutput("héllo", 2)
+	assert.Equal(t, "h", s)
+	assert.True(t, truncated)
+	s, truncated = truncateOutput("abc", 5)
+	assert.Equal(t, "abc", s)
+	assert.False(t, truncated)
+}
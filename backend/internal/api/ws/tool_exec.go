@@ -0,0 +1,166 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"fnchatbot/internal/config"
+	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/llm"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// errToolCancelled is the result of calls aborted or skipped because the user cancelled.
+var errToolCancelled = errors.New("cancelled by the user")
+
+// toolFunc executes a single tool call.
+type toolFunc func(ctx context.Context, tc llms.ToolCall) (string, []llm.GeneratedImage, error)
+
+// toolResult is the outcome of one tool call. ran is false for calls skipped after a cancel.
+type toolResult struct {
+	output string
+	images []llm.GeneratedImage
+	err    error
+	ran    bool
+}
+
+// toolRunner executes the tool calls of one model turn. Consecutive calls to tools that
+// may run concurrently form a batch executed in parallel; a sequential tool waits for
+// the calls before it and blocks the calls after it.
+type toolRunner struct {
+	exec        toolFunc
+	tools       map[string]services.Tool
+	concurrency int
+	timeout     time.Duration
+	events      *toolCallEvents
+	// approve, when set, is asked before every call; an error stops the call
+	approve func(ctx context.Context, tc llms.ToolCall) error
+}
+
+// newToolRunner creates a runner with the configured concurrency and timeout.
+func newToolRunner(exec toolFunc, tools []services.Tool, events *toolCallEvents) *toolRunner {
+	cfg := config.GetConfig().Agent
+	byName := make(map[string]services.Tool, len(tools))
+	for _, t := range tools {
+		byName[t.Function.Name] = t
+	}
+	return &toolRunner{
+		exec:        exec,
+		tools:       byName,
+		concurrency: cfg.ToolConcurrency,
+		timeout:     time.Duration(cfg.ToolTimeoutSeconds) * time.Second,
+		events:      events,
+	}
+}
+
+// run executes calls and returns their results in the order of calls.
+func (r *toolRunner) run(ctx context.Context, calls []llms.ToolCall) []toolResult {
+	results := make([]toolResult, len(calls))
+	limit := r.concurrency
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	for _, batch := range r.batches(calls) {
+		var wg sync.WaitGroup
+		for _, i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				defer func() { <-sem }()
+				if ctx.Err() == nil {
+					results[i] = r.call(ctx, calls[i])
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	for i := range results {
+		if !results[i].ran {
+			results[i].err = errToolCancelled
+		}
+	}
+	return results
+}
+
+// batches groups the indexes of calls into batches that may run concurrently.
+func (r *toolRunner) batches(calls []llms.ToolCall) [][]int {
+	var out [][]int
+	var cur []int
+	for i, tc := range calls {
+		if !r.tools[tc.FunctionCall.Name].Sequential {
+			cur = append(cur, i)
+			continue
+		}
+		if len(cur) > 0 {
+			out = append(out, cur)
+			cur = nil
+		}
+		out = append(out, []int{i})
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// call executes one tool call within its timeout and reports it to the client. A tool
+// that ignores its context is abandoned when the timeout expires.
+func (r *toolRunner) call(ctx context.Context, tc llms.ToolCall) toolResult {
+	name := tc.FunctionCall.Name
+	timeout := r.timeout
+	if t := r.tools[name].Timeout; t > 0 {
+		timeout = t
+	}
+	r.events.start(tc.ID, name)
+	if r.approve != nil {
+		if err := r.approve(ctx, tc); err != nil {
+			if ctx.Err() != nil {
+				err = errToolCancelled
+			}
+			r.events.finish(tc, "", err, 0)
+			return toolResult{err: err, ran: true}
+		}
+	}
+	// The timeout starts once the call is approved
+	callCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		callCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	started := time.Now()
+	done := make(chan toolResult, 1)
+	go func() {
+		output, images, err := r.exec(callCtx, tc)
+		done <- toolResult{output: output, images: images, err: err}
+	}()
+
+	var res toolResult
+	select {
+	case res = <-done:
+	case <-callCtx.Done():
+		res.err = callCtx.Err()
+	}
+	res.ran = true
+	if res.err != nil {
+		switch {
+		case ctx.Err() != nil:
+			res.err = errToolCancelled
+		case errors.Is(callCtx.Err(), context.DeadlineExceeded):
+			res.err = fmt.Errorf("%s timed out after %s", name, timeout)
+		}
+	}
+	r.events.finish(tc, res.output, res.err, time.Since(started))
+	return res
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/llm"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func toolCall(id, name string) llms.ToolCall {
+	return llms.ToolCall{ID: id, FunctionCall: &llms.FunctionCall{Name: name}}
+}
+
+func TestToolRunner_ParallelBatchesKeepOrder(t *testing.T) {
+	tools := []services.Tool{
+		{Function: services.ToolSchema{Name: "read"}},
+		{Function: services.ToolSchema{Name: "write"}, Sequential: true},
+	}
+	var running, peak int32
+	exec := func(ctx context.Context, tc llms.ToolCall) (string, []llm.GeneratedImage, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		if tc.FunctionCall.Name == "write" {
+			assert.Equal(t, int32(1), n, "sequential tool must run alone")
+		}
+		time.Sleep(20 * time.Millisecond)
+		return "out " + tc.ID, nil, nil
+	}
+	runner := newToolRunner(exec, tools, newToolCallEvents(&recorder{}, tools))
+	runner.concurrency = 2
+
+	calls := []llms.ToolCall{toolCall("1", "read"), toolCall("2", "read"), toolCall("3", "read"), toolCall("4", "write"), toolCall("5", "read")}
+	assert.Equal(t, [][]int{{0, 1, 2}, {3}, {4}}, runner.batches(calls))
+
+	results := runner.run(context.Background(), calls)
+	for i, res := range results {
+		assert.True(t, res.ran)
+		assert.NoError(t, res.err)
+		assert.Equal(t, "out "+calls[i].ID, res.output)
+	}
+	assert.Equal(t, int32(2), peak)
+}
+
+func TestToolRunner_TimeoutAndCancel(t *testing.T) {
+	tools := []services.Tool{
+		{Function: services.ToolSchema{Name: "slow"}, Timeout: 20 * time.Millisecond},
+		{Function: services.ToolSchema{Name: "stop"}, Sequential: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exec := func(callCtx context.Context, tc llms.ToolCall) (string, []llm.GeneratedImage, error) {
+		if tc.FunctionCall.Name == "stop" {
+			cancel()
+			return "", nil, context.Canceled
+		}
+		// Ignores its context; the runner abandons it after the timeout
+		time.Sleep(time.Second)
+		return "late", nil, nil
+	}
+	out := &recorder{}
+	runner := newToolRunner(exec, tools, newToolCallEvents(out, tools))
+
+	results := runner.run(ctx, []llms.ToolCall{toolCall("1", "slow"), toolCall("2", "stop"), toolCall("3", "slow")})
+	assert.EqualError(t, results[0].err, "slow timed out after 20ms")
+	assert.Equal(t, errToolCancelled, results[1].err)
+	assert.True(t, results[1].ran)
+	assert.Equal(t, errToolCancelled, results[2].err)
+	assert.False(t, results[2].ran)
+
+	// Skipped calls are not announced to the client
+	for _, f := range out.frames {
+		assert.NotEqual(t, "3", f.ToolCallID)
+	}
+}
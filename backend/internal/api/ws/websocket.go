@@ -2,17 +2,23 @@ package ws
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 
 	"fnchatbot/internal/auth"
+	"fnchatbot/internal/config"
 	"fnchatbot/internal/db"
 	"fnchatbot/internal/models"
 	"fnchatbot/internal/services"
+	"fnchatbot/internal/services/documents"
 	"fnchatbot/internal/services/llm"
 	"fnchatbot/internal/services/memory"
 
@@ -34,25 +40,76 @@ const (
 	TypeMessageEnd         = "message_end"
 	TypePermissionResponse = "permission_response"
 	TypeImage              = "image"
+	TypeNotice             = "notice"
+	TypeBudgetWarning      = "budget_warning"
+	TypeBudgetExceeded     = "budget_exceeded"
+	TypeImageGenerated     = "image_generated"
+	TypeReasoning          = "reasoning"
+	TypeCancel             = "cancel"
+	TypeHello              = "hello"
+	TypeToolCallStart      = "tool_call_start"
+	TypeToolCallArgsDelta  = "tool_call_args_delta"
+	TypeToolCallResult     = "tool_call_result"
+	TypeToolCallError      = "tool_call_error"
+	TypeLimitReached       = "limit_reached"
+	TypePermissionRequest  = "permission_request"
+	TypeRegenerate         = "regenerate"
+	TypeEditMessage        = "edit_message"
+	TypeTitle              = "title"
 )
 
+// ProtocolVersion is announced in the hello frame sent on connect. Version 2 replaced
+// the markdown tool call notices in message deltas with tool_call_* frames.
+const ProtocolVersion = 2
+
 type WSMessage struct {
-	Type          string         `json:"type"`
-	Content       string         `json:"content,omitempty"`
-	Images        []ImagePayload `json:"images,omitempty"`
-	ModelID       uint           `json:"model_id,omitempty"`
-	Options       map[string]any `json:"options,omitempty"`
-	Delta         string         `json:"delta,omitempty"`
-	Tasks         []TaskDTO      `json:"tasks,omitempty"`
-	RequestID     string         `json:"request_id,omitempty"`
-	RequestedPath string         `json:"requested_path,omitempty"`
-	Command       string         `json:"command,omitempty"`
-	BlockedPaths  []string       `json:"blocked_paths,omitempty"`
-	Approved      bool           `json:"approved,omitempty"`
-	Remember      bool           `json:"remember,omitempty"`
+	Type          string                 `json:"type"`
+	Content       string                 `json:"content,omitempty"`
+	Images        []ImagePayload         `json:"images,omitempty"`
+	Files         []FilePayload          `json:"files,omitempty"`
+	ModelID       uint                   `json:"model_id,omitempty"`
+	Options       map[string]any         `json:"options,omitempty"`
+	Delta         string                 `json:"delta,omitempty"`
+	Tasks         []TaskDTO              `json:"tasks,omitempty"`
+	RequestID     string                 `json:"request_id,omitempty"`
+	RequestedPath string                 `json:"requested_path,omitempty"`
+	Command       string                 `json:"command,omitempty"`
+	BlockedPaths  []string               `json:"blocked_paths,omitempty"`
+	Approved      bool                   `json:"approved,omitempty"`
+	Remember      bool                   `json:"remember,omitempty"`
+	Provider      string                 `json:"provider,omitempty"`
+	Model         string                 `json:"model,omitempty"`
+	Budget        *services.BudgetStatus `json:"budget,omitempty"`
+	Interrupted   bool                   `json:"interrupted,omitempty"` // set on message_end after a cancel
+	RunID         string                 `json:"run_id,omitempty"`      // reply the frame belongs to
+	Seq           uint64                 `json:"seq,omitempty"`         // position of the frame in its reply
+	MessageID     uint                   `json:"message_id,omitempty"`  // message to regenerate or edit
+	// Tool call frames
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	ToolName   string `json:"tool_name,omitempty"`
+	Server     string `json:"server,omitempty"` // MCP server, "builtin" or "skill"
+	Arguments  string `json:"arguments,omitempty"`
+	Output     string `json:"output,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Limit is the reply limit of a limit_reached frame: max_turns, max_tool_calls or max_duration
+	Limit string `json:"limit,omitempty"`
+	// Title is the generated title of a title frame
+	Title string `json:"title,omitempty"`
+
+	ProtocolVersion int `json:"protocol_version,omitempty"`
 }
 
 type ImagePayload struct {
+	Data string `json:"data,omitempty"`
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"` // set for stored files sent to the client
+}
+
+// FilePayload is a document attached to a user message, base64 encoded.
+type FilePayload struct {
+	Name string `json:"name"`
 	Data string `json:"data"`
 	Type string `json:"type"`
 }
@@ -68,15 +125,15 @@ type PermissionResponse struct {
 	Remember bool
 }
 
+// PermissionManager hands approval answers from the client to the waiting tool call.
+// Answers for unknown or already answered requests are dropped.
 type PermissionManager struct {
-	mu        sync.RWMutex
-	pending   map[string]chan PermissionResponse
-	responses map[string]PermissionResponse
+	mu      sync.Mutex
+	pending map[string]chan PermissionResponse
 }
 
 var permissionManager = &PermissionManager{
-	pending:   make(map[string]chan PermissionResponse),
-	responses: make(map[string]PermissionResponse),
+	pending: make(map[string]chan PermissionResponse),
 }
 
 func (pm *PermissionManager) CreateRequest(requestID string) chan PermissionResponse {
@@ -94,47 +151,71 @@ func (pm *PermissionManager) SetResponse(requestID string, response PermissionRe
 		ch <- response
 		delete(pm.pending, requestID)
 	}
-	pm.responses[requestID] = response
-}
-
-func (pm *PermissionManager) GetResponse(requestID string) (PermissionResponse, bool) {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-	resp, exists := pm.responses[requestID]
-	return resp, exists
 }
 
 func (pm *PermissionManager) RemoveRequest(requestID string) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	delete(pm.pending, requestID)
-	delete(pm.responses, requestID)
 }
 
+// HandleWebSocket serves /ws/chat/:id. Replies run detached from the connection and
+// are shared by all connections on the session; a client that reconnects passes the
+// run_id and last_seq it saw to receive the frames it missed.
 func HandleWebSocket(c *gin.Context) {
 	sessionID := c.Param("id")
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	currentUser, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	id, err := strconv.ParseUint(sessionID, 10, 32)
 	if err != nil {
-		log.Printf("Failed to upgrade websocket: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
 		return
 	}
-	// Close websocket and report errors to avoid silent failures.
-	defer func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("Failed to close websocket: %v", err)
-		}
-	}()
+	var session models.Session
+	if err := db.DB.First(&session, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if !auth.CanAccessSession(currentUser, &session) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	lastSeq, _ := strconv.ParseUint(c.Query("last_seq"), 10, 64)
 
-	currentUser, ok := auth.CurrentUser(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade websocket: %v", err)
 		return
 	}
+	conn := newConn(ws)
+	if err := sendJSON(conn, WSMessage{Type: TypeHello, ProtocolVersion: ProtocolVersion}); err != nil {
+		log.Printf("Failed to send hello: %v", err)
+	}
+	hub := attach(session.ID, conn, c.Query("run_id"), lastSeq)
+
+	closeCode, closeReason := websocket.CloseNormalClosure, ""
+	defer func() {
+		hub.unsubscribe(conn)
+		conn.Close(closeCode, closeReason)
+		conn.Wait()
+	}()
 
 	for {
-		_, p, err := conn.ReadMessage()
+		p, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Error reading message: %v", err)
+			}
+			var closeErr *websocket.CloseError
+			if errors.Is(err, websocket.ErrReadLimit) {
+				closeCode, closeReason = websocket.CloseMessageTooBig, "message too big"
+			} else if !errors.As(err, &closeErr) {
+				// Read deadline passed without a pong, or the socket broke
+				closeCode, closeReason = websocket.CloseGoingAway, "connection timed out"
+			}
 			break
 		}
 
@@ -145,17 +226,23 @@ func HandleWebSocket(c *gin.Context) {
 		}
 
 		switch msg.Type {
-		case TypeUserMessage:
-			handleUserMessage(conn, sessionID, msg, currentUser)
-		case TypeImage:
-			handleUserMessage(conn, sessionID, msg, currentUser)
+		case TypeUserMessage, TypeImage, TypeRegenerate, TypeEditMessage:
+			if !hub.start(func(ctx context.Context, out sender) {
+				handleUserMessage(ctx, out, sessionID, msg, currentUser)
+			}) {
+				if err := sendJSON(conn, WSMessage{Type: TypeNotice, Content: "A reply is already being generated; cancel it before sending another message."}); err != nil {
+					log.Printf("Failed to send busy notice: %v", err)
+				}
+			}
+		case TypeCancel:
+			hub.stop()
 		case TypePermissionResponse:
 			HandlePermissionResponse(msg)
 		}
 	}
 }
 
-func handleUserMessage(conn *websocket.Conn, sessionIDStr string, msg WSMessage, currentUser *models.User) {
+func handleUserMessage(ctx context.Context, conn sender, sessionIDStr string, msg WSMessage, currentUser *models.User) {
 	sessionID, err := strconv.ParseUint(sessionIDStr, 10, 32)
 	if err != nil {
 		log.Printf("Invalid session ID: %v", err)
@@ -174,8 +261,7 @@ func handleUserMessage(conn *websocket.Conn, sessionIDStr string, msg WSMessage,
 		return
 	}
 
-	// Ensure only session owner or admin can access this session.
-	if currentUser == nil || (!auth.IsAdmin(currentUser) && session.UserID != currentUser.ID) {
+	if !auth.CanAccessSession(currentUser, &session) {
 		if err := sendJSON(conn, WSMessage{Type: TypeMessage, Content: "Error: Forbidden."}); err != nil {
 			log.Printf("Failed to send forbidden message: %v", err)
 		}
@@ -221,28 +307,121 @@ func handleUserMessage(conn *websocket.Conn, sessionIDStr string, msg WSMessage,
 		return
 	}
 
-	ctx := context.Background()
+	// Budgets are charged to the session owner, who may differ from an admin viewer
+	budgetService := services.NewBudgetService(db.DB)
+	var owner models.User
+	if err := db.DB.First(&owner, session.UserID).Error; err != nil {
+		log.Printf("Session owner not found: %v", err)
+	}
+	budgetWarned := false
+	if !checkBudget(conn, budgetService, &owner, &budgetWarned) {
+		if err := sendJSON(conn, WSMessage{Type: TypeMessageEnd}); err != nil {
+			log.Printf("Failed to send message end: %v", err)
+		}
+		return
+	}
+
+	// Extract attached documents up front so a rejected file does not leave a
+	// half-saved message behind
+	images, attachments, err := prepareFiles(msg.Files)
+	if err != nil {
+		if err := sendJSON(conn, WSMessage{Type: TypeNotice, Content: err.Error()}); err != nil {
+			log.Printf("Failed to send notice: %v", err)
+		}
+		if err := sendJSON(conn, WSMessage{Type: TypeMessageEnd}); err != nil {
+			log.Printf("Failed to send message end: %v", err)
+		}
+		return
+	}
+	msg.Images = append(msg.Images, images...)
+
 	llmService := llm.NewService(db.DB)
 
-	// Save User Message
-	if err := llmService.SaveUserMessage(ctx, uint(sessionID), msg.Content); err != nil {
+	// Save User Message, with any images and documents as file parts so later turns still
+	// see them; regenerate and edit_message start a new branch instead
+	hist := memory.NewSQLiteHistory(db.DB, uint(sessionID))
+	if err := saveUserTurn(ctx, hist, &msg, attachments); err != nil {
 		log.Printf("Failed to save user message: %v", err)
+		if msg.Type == TypeRegenerate || msg.Type == TypeEditMessage {
+			if err := sendJSON(conn, WSMessage{Type: TypeNotice, Content: err.Error()}); err != nil {
+				log.Printf("Failed to send notice: %v", err)
+			}
+			if err := sendJSON(conn, WSMessage{Type: TypeMessageEnd}); err != nil {
+				log.Printf("Failed to send message end: %v", err)
+			}
+			return
+		}
+	}
+
+	// Image generation models answer with images instead of chat text
+	if imageModel, ok := services.NewImageService(db.DB).FindImageModel(provider.ID, session.Model.Model); ok {
+		generateImageReply(ctx, conn, hist, session, *imageModel, msg)
+		if err := sendJSON(conn, WSMessage{Type: TypeMessageEnd, Interrupted: ctx.Err() != nil}); err != nil {
+			log.Printf("Failed to send message end: %v", err)
+		}
+		return
+	}
+
+	// Primary provider followed by the model config's fallback chain
+	targets := buildChatTargets(session.Model, provider)
+	retryPolicy := llm.DefaultRetryPolicy
+	if session.Model.MaxRetries != nil {
+		retryPolicy.MaxRetries = *session.Model.MaxRetries
 	}
 
 	// Prepare Tools scoped to session owner
 	toolService := services.NewToolService(session.UserID)
 	svcTools, _ := toolService.GetAvailableTools()
-	lcTools := convertToLangChainTools(svcTools)
+	policies, err := services.NewToolPolicyService(db.DB).Policies(session.UserID)
+	if err != nil {
+		log.Printf("Failed to load tool policies: %v", err)
+	}
+	approver := newToolApprover(conn, session, svcTools, policies)
+	lcTools := convertToLangChainTools(approver.offered(svcTools))
+	toolEvents := newToolCallEvents(conn, svcTools)
+	// Generated images are stored on the tool result and shown right away
+	runner := newToolRunner(func(ctx context.Context, tc llms.ToolCall) (string, []llm.GeneratedImage, error) {
+		if tc.FunctionCall.Name == "ImageGenerate" {
+			return toolService.GenerateImage(ctx, tc.FunctionCall.Arguments)
+		}
+		result, err := toolService.ExecuteSkill(ctx, tc.FunctionCall.Name, tc.FunctionCall.Arguments)
+		return result, nil, err
+	}, svcTools, toolEvents)
+	runner.approve = approver.check
 
-	// Loop for Multi-turn (Tool Execution)
-	maxTurns := 5
-	currentTurn := 0
+	// System prompt rendered from the global, per-user and session persona templates
+	systemPrompt, err := services.NewPromptService(db.DB).BuildSystemPrompt(owner, session)
+	if err != nil {
+		log.Printf("Failed to build system prompt: %v", err)
+	}
 
-	for currentTurn < maxTurns {
-		currentTurn++
+	// Loop for Multi-turn (Tool Execution). Once a limit is reached the model is asked
+	// for a final answer without tools.
+	limits := newAgentLimits(session)
+	limitReached := ""
+	// answer is the text of the final reply, used to title new conversations
+	answer := ""
+
+	for {
+		// Tool turns consume budget too; re-check before every model call after the first
+		if limits.turns > 0 && !checkBudget(conn, budgetService, &owner, &budgetWarned) {
+			break
+		}
+		if limitReached == "" {
+			if limitReached = limits.reached(); limitReached != "" {
+				if err := sendJSON(conn, WSMessage{Type: TypeLimitReached, Limit: limitReached, Content: limits.describe(limitReached)}); err != nil {
+					log.Printf("Failed to send limit notice: %v", err)
+				}
+			}
+		}
+		limits.turns++
+		turnPrompt, turnTools := systemPrompt, lcTools
+		if limitReached != "" {
+			turnPrompt, turnTools = strings.TrimSpace(systemPrompt+"\n\n"+limitNote), nil
+		}
 
 		// Load History (including just saved user message or previous tool outputs)
-		history, err := llmService.GetHistory(ctx, uint(sessionID))
+		history, err := hist.Messages(ctx)
 		if err != nil {
 			log.Printf("Failed to load history: %v", err)
 			break
@@ -251,16 +430,7 @@ func handleUserMessage(conn *websocket.Conn, sessionIDStr string, msg WSMessage,
 		// Convert History to []llms.MessageContent
 		var contentMessages []llms.MessageContent
 
-		// Find the index of the last human message to attach images to
-		lastHumanIdx := -1
-		for j := len(history) - 1; j >= 0; j-- {
-			if history[j].GetType() == llms.ChatMessageTypeHuman {
-				lastHumanIdx = j
-				break
-			}
-		}
-
-		for i, m := range history {
+		for _, m := range history {
 			parts := []llms.ContentPart{}
 
 			// Handle Tool Calls
@@ -284,24 +454,12 @@ func handleUserMessage(conn *websocket.Conn, sessionIDStr string, msg WSMessage,
 					Content:    toolMsg.Content,
 					Name:       "", // Name is not stored in ToolChatMessage
 				})
+			} else if mm, ok := m.(memory.MultiModalMessage); ok {
+				// Stored text and attachments (images)
+				parts = append(parts, mm.Parts...)
 			} else {
-				// Normal text message or Human message with images
-				if i == lastHumanIdx && len(msg.Images) > 0 && m.GetType() == llms.ChatMessageTypeHuman {
-					content := m.GetContent()
-					if content != "" {
-						parts = append(parts, llms.TextPart(content))
-					}
-					for _, img := range msg.Images {
-						mimeType := img.Type
-						if mimeType == "" {
-							mimeType = "image/png"
-						}
-						url := fmt.Sprintf("data:%s;base64,%s", mimeType, img.Data)
-						parts = append(parts, llms.ImageURLPart(url))
-					}
-				} else {
-					parts = append(parts, llms.TextPart(m.GetContent()))
-				}
+				// Normal text message
+				parts = append(parts, llms.TextPart(m.GetContent()))
 			}
 
 			contentMessages = append(contentMessages, llms.MessageContent{
@@ -310,17 +468,57 @@ func handleUserMessage(conn *websocket.Conn, sessionIDStr string, msg WSMessage,
 			})
 		}
 
-		// Stream Chat
-		resp, err := llmService.StreamChat(ctx, provider, session.Model.Model, contentMessages, lcTools, func(ctx context.Context, chunk []byte) error {
+		if turnPrompt != "" {
+			contentMessages = append([]llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, turnPrompt)}, contentMessages...)
+		}
+
+		// Keep the prompt within the primary model's context window
+		contentMessages = applyContextStrategy(ctx, llmService, hist, session, targets[0], contentMessages)
+		contentMessages = mergeSystemMessages(contentMessages)
+
+		// Stream Chat, keeping the streamed text so a cancelled reply can be saved
+		var partial, partialReasoning strings.Builder
+		resp, target, err := llmService.StreamChatWithFallback(ctx, targets, retryPolicy, contentMessages, turnTools, llm.StreamHandlers{
+			Content: func(ctx context.Context, chunk []byte) error {
+				partial.Write(chunk)
+				if err := sendJSON(conn, WSMessage{
+					Type:  TypeMessage,
+					Delta: string(chunk),
+				}); err != nil {
+					log.Printf("Failed to send chunk: %v", err)
+				}
+				return nil
+			},
+			Reasoning: func(ctx context.Context, chunk []byte) error {
+				partialReasoning.Write(chunk)
+				if err := sendJSON(conn, WSMessage{
+					Type:  TypeReasoning,
+					Delta: string(chunk),
+				}); err != nil {
+					log.Printf("Failed to send reasoning chunk: %v", err)
+				}
+				return nil
+			},
+			ToolCall: func(ctx context.Context, delta llm.ToolCallDelta) error {
+				toolEvents.delta(delta)
+				return nil
+			},
+		}, func(from, to llm.ChatTarget, cause error) {
+			log.Printf("Falling back from %s to %s: %v", from, to, cause)
 			if err := sendJSON(conn, WSMessage{
-				Type:  TypeMessage,
-				Delta: string(chunk),
+				Type:     TypeNotice,
+				Content:  fmt.Sprintf("%s is unavailable, falling back to %s", from, to),
+				Provider: to.Provider.ProviderID,
+				Model:    to.Model,
 			}); err != nil {
-				log.Printf("Failed to send chunk: %v", err)
+				log.Printf("Failed to send fallback notice: %v", err)
 			}
-			return nil
 		})
 
+		if err != nil && ctx.Err() != nil {
+			saveInterrupted(hist, llms.AIChatMessage{Content: partial.String(), ReasoningContent: partialReasoning.String()})
+			break
+		}
 		if err != nil {
 			log.Printf("StreamChat error: %v", err)
 			if err := sendJSON(conn, WSMessage{Type: TypeMessage, Content: fmt.Sprintf("\nError: %v", err)}); err != nil {
@@ -335,76 +533,452 @@ func handleUserMessage(conn *websocket.Conn, sessionIDStr string, msg WSMessage,
 
 		choice := resp.Choices[0]
 
-		// If there are tool calls
-		if len(choice.ToolCalls) > 0 {
+		// If there are tool calls, unless this was the final answer after a limit
+		if len(choice.ToolCalls) > 0 && limitReached == "" {
 			// Save AI Message with Tool Calls
-			// We need to manually construct AIChatMessage with ToolCalls and save it
-			// Note: We might need to handle partial text content too if any
 			aiMsg := llms.AIChatMessage{
-				Content:   choice.Content,
-				ToolCalls: choice.ToolCalls,
+				Content:          choice.Content,
+				ToolCalls:        choice.ToolCalls,
+				ReasoningContent: choice.ReasoningContent,
 			}
-			// Use internal memory helper to save complex message (since SaveAIMessage only takes string)
-			// We need to expose a SaveMessage method or use memory package directly.
-			// Since llmService exposes GetHistory which returns memory.SQLiteHistory, let's use memory package directly here or add helper.
-			// Ideally llmService should have `SaveMessage`.
-			// Let's assume we can access `memory` package since we imported it (but we didn't import it in this file yet, let's check imports).
-			// We imported `fnchatbot/internal/services/memory`? No, we need to.
-			// Or we can add `SaveMessage` to `llm.Service`.
-			// I'll add `SaveMessage` to `llm.Service` via a separate edit or just rely on `SaveAIMessage` for text and fail for tools?
-			// No, tools are critical.
-
-			// Let's hack it: `llmService.SaveAIMessage` only saves text.
-			// I need to use `memory.NewSQLiteHistory(db.DB, ...).AddMessage(...)`.
-			hist := memory.NewSQLiteHistory(db.DB, uint(sessionID))
-			if err := hist.AddMessage(ctx, aiMsg); err != nil {
+			saved, err := hist.SaveMessage(ctx, aiMsg)
+			if err != nil {
 				log.Printf("Failed to save tool-call message: %v", err)
 			}
+			recordUsage(llmService, saved, session, target, contentMessages, resp)
 
-			// Execute Tools
-			for _, tc := range choice.ToolCalls {
-				if err := sendJSON(conn, WSMessage{
-					Type:    TypeMessage,
-					Content: fmt.Sprintf("\n\n> Calling tool: %s...\n", tc.FunctionCall.Name),
-				}); err != nil {
-					log.Printf("Failed to send tool notice: %v", err)
-				}
-
-				// Execute
-				result, err := toolService.ExecuteSkill(tc.FunctionCall.Name, tc.FunctionCall.Arguments)
-				if err != nil {
-					result = fmt.Sprintf("Error: %v", err)
+			// Execute Tools, independent calls concurrently; results are saved in call order.
+			// Calls beyond the tool call limit are answered without running them.
+			allowed := limits.allowToolCalls(len(choice.ToolCalls))
+			results := runner.run(ctx, choice.ToolCalls[:allowed])
+			for range choice.ToolCalls[allowed:] {
+				results = append(results, toolResult{err: errToolLimit})
+			}
+			for i, tc := range choice.ToolCalls {
+				res := results[i]
+				result := res.output
+				if res.err != nil {
+					result = fmt.Sprintf("Error: %v", res.err)
 				}
 
 				// Handle specific tool UI updates (TodoWrite, etc) - Copied from old code
-				handleToolUIUpdates(conn, tc.FunctionCall.Name, tc.FunctionCall.Arguments)
-
-				// Save Tool Output
-				toolMsg := llms.ToolChatMessage{
-					ID:      tc.ID,
-					Content: result,
+				if res.ran && res.err == nil {
+					handleToolUIUpdates(conn, tc.FunctionCall.Name, tc.FunctionCall.Arguments)
 				}
-				if err := hist.AddMessage(ctx, toolMsg); err != nil {
+
+				// Save Tool Output, even when the reply was just cancelled; calls that were
+				// skipped still need a result to keep the history valid
+				saved, err := hist.SaveMessage(context.Background(), memory.MultiModalMessage{
+					Type:        llms.ChatMessageTypeTool,
+					Content:     result,
+					ToolCallID:  tc.ID,
+					Attachments: imageAttachments(res.images),
+				})
+				if err != nil {
 					log.Printf("Failed to save tool result: %v", err)
+				} else if len(res.images) > 0 {
+					sendGeneratedImages(conn, saved)
 				}
 			}
 
+			if ctx.Err() != nil {
+				break
+			}
 			// Continue loop to generate next response
 		} else {
 			// No tool calls, just text response
 			// Save it
-			if err := llmService.SaveAIMessage(ctx, uint(sessionID), choice.Content); err != nil {
+			saved, err := hist.SaveMessage(ctx, llms.AIChatMessage{Content: choice.Content, ReasoningContent: choice.ReasoningContent})
+			if err != nil {
 				log.Printf("Failed to save AI message: %v", err)
 			}
+			recordUsage(llmService, saved, session, target, contentMessages, resp)
+			answer = choice.Content
 			break
 		}
 	}
 
-	if err := sendJSON(conn, WSMessage{Type: TypeMessageEnd}); err != nil {
+	autoTitle(ctx, conn, llmService, session, targets, answer)
+
+	if err := sendJSON(conn, WSMessage{Type: TypeMessageEnd, Interrupted: ctx.Err() != nil}); err != nil {
 		log.Printf("Failed to send message end: %v", err)
 	}
 }
 
+// saveInterrupted stores the output streamed before a reply was cancelled and marks the
+// message as interrupted. Nothing is saved before the answer itself started: providers
+// reject assistant turns without content, so reasoning alone would break later turns.
+func saveInterrupted(hist *memory.SQLiteHistory, partial llms.AIChatMessage) {
+	if partial.Content == "" {
+		return
+	}
+	saved, err := hist.SaveMessage(context.Background(), partial)
+	if err != nil {
+		log.Printf("Failed to save interrupted reply: %v", err)
+		return
+	}
+	if err := db.DB.Model(saved).Update("interrupted", true).Error; err != nil {
+		log.Printf("Failed to mark reply as interrupted: %v", err)
+	}
+}
+
+// generateImageReply answers a message sent to an image generation model with images
+// stored as file parts of the assistant message. Message options may set size, quality
+// and n. The images are billed to the session owner.
+func generateImageReply(ctx context.Context, conn sender, hist *memory.SQLiteHistory, session models.Session, model models.Model, msg WSMessage) {
+	opts := llm.ImageOptions{}
+	if size, ok := msg.Options["size"].(string); ok {
+		opts.Size = size
+	}
+	if quality, ok := msg.Options["quality"].(string); ok {
+		opts.Quality = quality
+	}
+	if n, ok := msg.Options["n"].(float64); ok {
+		opts.N = int(n)
+	}
+
+	uc := llm.UsageContext{SessionID: session.ID, UserID: session.UserID, ModelConfigID: session.Model.ID}
+	images, err := services.NewImageService(db.DB).Generate(ctx, uc, model, msg.Content, opts)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Image generation error: %v", err)
+		if err := sendJSON(conn, WSMessage{Type: TypeMessage, Content: fmt.Sprintf("\nError: %v", err)}); err != nil {
+			log.Printf("Failed to send image error: %v", err)
+		}
+		return
+	}
+
+	// Providers reject empty assistant turns, so the message also gets a short text
+	content := fmt.Sprintf("[Generated %d image(s) with %s]", len(images), model.ModelID)
+	if images[0].RevisedPrompt != "" {
+		content += "\n" + images[0].RevisedPrompt
+	}
+	saved, err := hist.SaveMessage(ctx, memory.MultiModalMessage{
+		Type:        llms.ChatMessageTypeAI,
+		Content:     content,
+		Attachments: imageAttachments(images),
+	})
+	if err != nil {
+		log.Printf("Failed to save generated images: %v", err)
+		return
+	}
+	sendGeneratedImages(conn, saved)
+}
+
+// imageAttachments converts generated images into attachments saved as file parts.
+func imageAttachments(images []llm.GeneratedImage) []memory.Attachment {
+	attachments := make([]memory.Attachment, len(images))
+	for i, img := range images {
+		attachments[i] = memory.Attachment{
+			Filename: fmt.Sprintf("image-%d.%s", i+1, strings.TrimPrefix(img.Mime, "image/")),
+			Mime:     img.Mime,
+			Data:     img.Data,
+		}
+	}
+	return attachments
+}
+
+// sendGeneratedImages sends the image file parts of a saved message, by URL when they
+// live in the file store and inline otherwise.
+func sendGeneratedImages(conn sender, saved *models.Message) {
+	var images []ImagePayload
+	for _, part := range saved.Parts {
+		if part.Type != models.PartTypeFile {
+			continue
+		}
+		var meta models.FilePartMeta
+		_ = json.Unmarshal(part.Meta, &meta)
+		payload := ImagePayload{Type: meta.Mime, URL: meta.URL}
+		if meta.URL == "" {
+			payload.Data = part.Content
+		}
+		images = append(images, payload)
+	}
+	if err := sendJSON(conn, WSMessage{Type: TypeImageGenerated, Images: images}); err != nil {
+		log.Printf("Failed to send generated images: %v", err)
+	}
+}
+
+// saveUserMessage stores the user's text, attached images and documents as a child of
+// parentID. Attachments are saved as file parts so later turns still see them.
+func saveUserMessage(ctx context.Context, hist *memory.SQLiteHistory, parentID uint, msg WSMessage, attachments []memory.Attachment) error {
+	if len(msg.Images) == 0 && len(attachments) == 0 {
+		_, err := hist.SaveMessageUnder(ctx, parentID, llms.HumanChatMessage{Content: msg.Content})
+		return err
+	}
+
+	var parts []llms.ContentPart
+	if msg.Content != "" {
+		parts = append(parts, llms.TextPart(msg.Content))
+	}
+	for _, img := range msg.Images {
+		mimeType := img.Type
+		if mimeType == "" {
+			mimeType = "image/png"
+		}
+		parts = append(parts, llms.ImageURLPart(fmt.Sprintf("data:%s;base64,%s", mimeType, img.Data)))
+	}
+	_, err := hist.SaveMessageUnder(ctx, parentID, memory.MultiModalMessage{
+		Type:        llms.ChatMessageTypeHuman,
+		Content:     msg.Content,
+		Parts:       parts,
+		Attachments: attachments,
+	})
+	return err
+}
+
+// saveUserTurn prepares hist for a reply. A user message is appended to the active
+// branch; edit_message saves the new prompt next to the edited one. Regenerate copies
+// the text of the prompt of the reply being replaced into msg and points hist.Head at
+// that prompt, so the new reply is saved next to the old one, which stays active until
+// then. Both leave the previous branch in place.
+func saveUserTurn(ctx context.Context, hist *memory.SQLiteHistory, msg *WSMessage, attachments []memory.Attachment) error {
+	tree, err := hist.Tree(ctx)
+	if err != nil {
+		return err
+	}
+	switch msg.Type {
+	case TypeRegenerate:
+		prompt, err := branchPrompt(tree, hist.SessionID, msg.MessageID)
+		if err != nil {
+			return err
+		}
+		for _, p := range prompt.Parts {
+			if p.Type == models.PartTypeText {
+				msg.Content += p.Content
+			}
+		}
+		hist.Head = prompt.ID
+		return nil
+	case TypeEditMessage:
+		if !tree.Has(msg.MessageID) {
+			return fmt.Errorf("message %d not found", msg.MessageID)
+		}
+		var edited models.Message
+		if err := db.DB.Where("id = ? AND role = ?", msg.MessageID, models.RoleUser).First(&edited).Error; err != nil {
+			return fmt.Errorf("only user messages can be edited")
+		}
+		return saveUserMessage(ctx, hist, tree.Parent(edited.ID), *msg, attachments)
+	}
+	return saveUserMessage(ctx, hist, tree.Leaf(), *msg, attachments)
+}
+
+// branchPrompt returns the user message a reply answers: messageID itself if it is a
+// user message, otherwise its closest user ancestor.
+func branchPrompt(tree *memory.Tree, sessionID, messageID uint) (*models.Message, error) {
+	if !tree.Has(messageID) {
+		return nil, fmt.Errorf("message %d not found", messageID)
+	}
+	path := tree.Path(messageID)
+	var prompt models.Message
+	err := db.DB.Where("id IN ? AND session_id = ? AND role = ?", path, sessionID, models.RoleUser).
+		Preload("Parts").Order("id desc").First(&prompt).Error
+	if err != nil {
+		return nil, fmt.Errorf("no prompt to regenerate the reply to message %d", messageID)
+	}
+	return &prompt, nil
+}
+
+// prepareFiles decodes attached files, passing images through and extracting the text
+// of documents within the configured limits. Any invalid file rejects the message.
+func prepareFiles(files []FilePayload) ([]ImagePayload, []memory.Attachment, error) {
+	if len(files) == 0 {
+		return nil, nil, nil
+	}
+	cfg := config.GetConfig().Storage
+	limits := documents.Limits{
+		MaxBytes: int64(cfg.MaxAttachmentMB) << 20,
+		MaxPages: cfg.MaxDocumentPages,
+		MaxChars: cfg.MaxExtractedChars,
+	}
+
+	var images []ImagePayload
+	var attachments []memory.Attachment
+	for _, f := range files {
+		name := filepath.Base(f.Name)
+		if name == "." || name == "/" {
+			name = "attachment"
+		}
+		// Reject oversized payloads before decoding them
+		if limits.MaxBytes > 0 && int64(base64.StdEncoding.DecodedLen(len(f.Data))) > limits.MaxBytes+2 {
+			return nil, nil, fmt.Errorf("attachment %s exceeds the %d MB limit", name, cfg.MaxAttachmentMB)
+		}
+		data, err := base64.StdEncoding.DecodeString(f.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("attachment %s is not valid base64", name)
+		}
+
+		mimeType := documents.DetectMime(name, f.Type, data)
+		if documents.IsImage(mimeType) {
+			images = append(images, ImagePayload{Data: f.Data, Type: mimeType})
+			continue
+		}
+		if !documents.Supported(mimeType) {
+			return nil, nil, fmt.Errorf("attachment %s: unsupported file type %s", name, mimeType)
+		}
+		doc, err := documents.Extract(name, mimeType, data, limits)
+		if err != nil {
+			return nil, nil, fmt.Errorf("attachment rejected: %v", err)
+		}
+		attachments = append(attachments, memory.Attachment{
+			Filename:  name,
+			Mime:      mimeType,
+			Data:      data,
+			Text:      doc.Text,
+			Pages:     doc.Pages,
+			Truncated: doc.Truncated,
+		})
+	}
+	return images, attachments, nil
+}
+
+// checkBudget reports whether the user may call the model. It sends a budget_exceeded
+// frame when a limit is exhausted and, once per request, a budget_warning frame when a
+// threshold is crossed. Budget lookup failures are logged and do not block chatting.
+func checkBudget(conn sender, budgetService *services.BudgetService, user *models.User, warned *bool) bool {
+	if user.ID == 0 {
+		return true
+	}
+	status, err := budgetService.Status(user)
+	if err != nil {
+		log.Printf("Failed to check budget for user %d: %v", user.ID, err)
+		return true
+	}
+	if status.Exceeded {
+		if err := sendJSON(conn, WSMessage{
+			Type:    TypeBudgetExceeded,
+			Content: "Usage budget exceeded: " + strings.Join(status.Warnings, "; "),
+			Budget:  status,
+		}); err != nil {
+			log.Printf("Failed to send budget exceeded: %v", err)
+		}
+		return false
+	}
+	if len(status.Warnings) > 0 && !*warned {
+		*warned = true
+		if err := sendJSON(conn, WSMessage{
+			Type:    TypeBudgetWarning,
+			Content: strings.Join(status.Warnings, "; "),
+			Budget:  status,
+		}); err != nil {
+			log.Printf("Failed to send budget warning: %v", err)
+		}
+	}
+	return true
+}
+
+// applyContextStrategy trims history that exceeds the model's input budget according to
+// the model config's strategy. With "summarize" the dropped turns are folded into the
+// session's rolling summary; if summarizing fails the turns are simply dropped. History
+// is sent unchanged when the model's window is unknown.
+func applyContextStrategy(ctx context.Context, llmService *llm.Service, hist *memory.SQLiteHistory, session models.Session, target llm.ChatTarget, messages []llms.MessageContent) []llms.MessageContent {
+	strategy := llm.ContextStrategy(session.Model.ContextStrategy)
+	if strategy == llm.ContextStrategyNone {
+		return messages
+	}
+
+	window := llmService.ContextWindow(session.Model, target)
+	if window == 0 {
+		return messages
+	}
+	budget := llm.InputBudget(window, session.Model.MaxTokens)
+	fit := llm.FitContext(target.Model, messages, budget)
+	if len(fit.Dropped) == 0 && fit.Trimmed == 0 {
+		return fit.Messages
+	}
+	log.Printf("Session %d: context over %d tokens, dropped %d message(s), trimmed %d tool exchange(s)",
+		session.ID, budget, len(fit.Dropped), fit.Trimmed)
+	if strategy != llm.ContextStrategySummarize || len(fit.Dropped) == 0 {
+		return fit.Messages
+	}
+
+	previous, err := hist.Summary(ctx)
+	if err != nil {
+		log.Printf("Failed to load context summary: %v", err)
+		return fit.Messages
+	}
+	summary, resp, err := llmService.Summarize(ctx, target, previous, fit.Dropped)
+	if resp != nil {
+		recordUsage(llmService, nil, session, target, fit.Dropped, resp)
+	}
+	if err != nil {
+		log.Printf("Failed to summarize context, truncating instead: %v", err)
+		return fit.Messages
+	}
+	if err := hist.AddSummary(ctx, summary, len(fit.Dropped)); err != nil {
+		log.Printf("Failed to save context summary: %v", err)
+	}
+
+	// Swap the previous summary (a leading system message) for the new one, after the system prompt
+	var result []llms.MessageContent
+	for i, m := range fit.Messages {
+		if m.Role != llms.ChatMessageTypeSystem {
+			result = append(result, llms.TextParts(llms.ChatMessageTypeSystem, summary))
+			return append(result, fit.Messages[i:]...)
+		}
+		if previous == "" || len(m.Parts) != 1 || m.Parts[0] != llms.TextPart(previous) {
+			result = append(result, m)
+		}
+	}
+	return append(result, llms.TextParts(llms.ChatMessageTypeSystem, summary))
+}
+
+// mergeSystemMessages joins the leading system messages (system prompt, rolling summary)
+// into one, since some providers concatenate them without separators or accept only one.
+func mergeSystemMessages(messages []llms.MessageContent) []llms.MessageContent {
+	n := 0
+	var texts []string
+	for n < len(messages) && messages[n].Role == llms.ChatMessageTypeSystem {
+		for _, p := range messages[n].Parts {
+			if t, ok := p.(llms.TextContent); ok && t.Text != "" {
+				texts = append(texts, t.Text)
+			}
+		}
+		n++
+	}
+	if n < 2 {
+		return messages
+	}
+	merged := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, strings.Join(texts, "\n\n"))}
+	return append(merged, messages[n:]...)
+}
+
+// recordUsage stores token usage for an assistant message; failures are only logged.
+func recordUsage(llmService *llm.Service, saved *models.Message, session models.Session, target llm.ChatTarget, messages []llms.MessageContent, resp *llms.ContentResponse) {
+	var messageID uint
+	if saved != nil {
+		messageID = saved.ID
+	}
+	if _, err := llmService.RecordUsage(llm.UsageContext{
+		MessageID:     messageID,
+		SessionID:     session.ID,
+		UserID:        session.UserID,
+		ModelConfigID: session.Model.ID,
+		Target:        target,
+	}, messages, resp); err != nil {
+		log.Printf("Failed to record token usage: %v", err)
+	}
+}
+
+// buildChatTargets returns the primary provider/model followed by any enabled fallbacks.
+func buildChatTargets(cfg models.ModelConfig, primary models.Provider) []llm.ChatTarget {
+	targets := []llm.ChatTarget{{Provider: primary, Model: cfg.Model, ThinkingBudget: cfg.ThinkingBudget}}
+	for _, fb := range cfg.Fallbacks {
+		var p models.Provider
+		if err := db.DB.First(&p, fb.ProviderID).Error; err != nil {
+			log.Printf("Skipping fallback provider %d: %v", fb.ProviderID, err)
+			continue
+		}
+		if !p.Enabled {
+			continue
+		}
+		targets = append(targets, llm.ChatTarget{Provider: p, Model: fb.Model, ThinkingBudget: cfg.ThinkingBudget})
+	}
+	return targets
+}
+
 func convertToLangChainTools(tools []services.Tool) []llms.Tool {
 	var lcTools []llms.Tool
 	for _, t := range tools {
@@ -420,7 +994,7 @@ func convertToLangChainTools(tools []services.Tool) []llms.Tool {
 	return lcTools
 }
 
-func handleToolUIUpdates(conn *websocket.Conn, name, args string) {
+func handleToolUIUpdates(conn sender, name, args string) {
 	if name == "TodoWrite" {
 		var todoArgs struct {
 			Items []TaskDTO `json:"items"`
@@ -480,6 +1054,6 @@ func HandlePermissionResponse(msg WSMessage) {
 		msg.RequestID, msg.Approved, msg.Remember)
 }
 
-func sendJSON(conn *websocket.Conn, v interface{}) error {
-	return conn.WriteJSON(v)
+func sendJSON(conn sender, v interface{}) error {
+	return conn.Send(v)
 }
@@ -70,11 +70,24 @@ func Init(db *gorm.DB, authCfg config.AuthConfig) error {
 
 	// Normal user can use most read/write APIs but not user/sandbox admin endpoints.
 	_, _ = enf.AddPolicy("role_user", "/api/auth/*", "(GET|POST)")
-	_, _ = enf.AddPolicy("role_user", "/api/conversations*", "(GET|POST|DELETE)")
+	_, _ = enf.AddPolicy("role_user", "/api/conversations*", "(GET|POST|PATCH|DELETE)")
+	_, _ = enf.AddPolicy("role_user", "/api/conversations/*", "(GET|POST|PATCH|DELETE)")
 	_, _ = enf.AddPolicy("role_user", "/api/models*", "(GET|POST)")
+	_, _ = enf.AddPolicy("role_user", "/api/models/configs/*", "PATCH")
 	_, _ = enf.AddPolicy("role_user", "/api/skills*", "(GET|POST|PATCH|DELETE)")
 	_, _ = enf.AddPolicy("role_user", "/api/mcp*", "(GET|POST|PUT|DELETE)")
 	_, _ = enf.AddPolicy("role_user", "/api/providers*", "(GET|POST|PUT)")
+	_, _ = enf.AddPolicy("role_user", "/api/usage*", "GET")
+	_, _ = enf.AddPolicy("role_user", "/api/usage/*", "GET")
+	_, _ = enf.AddPolicy("role_user", "/api/prompts*", "(GET|POST|PUT|DELETE)")
+	_, _ = enf.AddPolicy("role_user", "/api/prompts/*", "(GET|POST|PUT|DELETE)")
+	_, _ = enf.AddPolicy("role_user", "/api/files/*", "GET")
+	_, _ = enf.AddPolicy("role_user", "/api/embeddings", "POST")
+	_, _ = enf.AddPolicy("role_user", "/api/rerank", "POST")
+	_, _ = enf.AddPolicy("role_user", "/api/knowledge*", "(GET|POST|PUT|DELETE)")
+	_, _ = enf.AddPolicy("role_user", "/api/knowledge/*", "(GET|POST|PUT|DELETE)")
+	_, _ = enf.AddPolicy("role_user", "/api/tool-policies", "(GET|PUT)")
+	_, _ = enf.AddPolicy("role_user", "/api/tool-policies/*", "DELETE")
 	_, _ = enf.AddPolicy("role_user", "/api/sandbox", "GET")
 	_, _ = enf.AddPolicy("role_user", "/api/sandbox/paths*", "GET")
 	if err := enf.SavePolicy(); err != nil {
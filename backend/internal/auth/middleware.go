@@ -134,6 +134,12 @@ func IsAdmin(u *models.User) bool {
 	return u != nil && u.IsAdmin
 }
 
+// CanAccessSession returns true if u owns session or is admin. Chat endpoints and the
+// websocket use it so the same users can read and continue a conversation.
+func CanAccessSession(u *models.User, session *models.Session) bool {
+	return u != nil && (u.IsAdmin || session.UserID == u.ID)
+}
+
 // IsInitialAdmin returns true if user is the initial admin (ID == 1 and admin).
 func IsInitialAdmin(u *models.User) bool {
 	return u != nil && u.IsAdmin && u.ID == 1
@@ -26,10 +26,56 @@ type AuthConfig struct {
 	TokenLifetime int64              `mapstructure:"token_lifetime_seconds"`
 }
 
+// SecurityConfig holds encryption settings for secrets at rest.
+type SecurityConfig struct {
+	// MasterKey encrypts provider API keys and MCP secrets. Overridden by FNCHATBOT_MASTER_KEY.
+	MasterKey string `mapstructure:"master_key"`
+}
+
+// StorageConfig holds where uploaded files are kept.
+type StorageConfig struct {
+	// FilesDir is the content-addressed store for chat attachments. Overridden by FNCHATBOT_FILES_DIR.
+	FilesDir string `mapstructure:"files_dir"`
+	// MaxAttachmentMB rejects larger chat attachments.
+	MaxAttachmentMB int `mapstructure:"max_attachment_mb"`
+	// MaxDocumentPages rejects PDFs with more pages.
+	MaxDocumentPages int `mapstructure:"max_document_pages"`
+	// MaxExtractedChars truncates text extracted from a document before it reaches the model.
+	MaxExtractedChars int `mapstructure:"max_extracted_chars"`
+}
+
+// AgentConfig holds limits for the tool calls a reply makes.
+type AgentConfig struct {
+	// ToolConcurrency is the number of tool calls of one turn executed at the same time.
+	ToolConcurrency int `mapstructure:"tool_concurrency"`
+	// ToolTimeoutSeconds bounds a single tool call unless the tool declares its own timeout.
+	ToolTimeoutSeconds int `mapstructure:"tool_timeout_seconds"`
+	// MaxTurns, MaxToolCalls and MaxDurationSeconds are the default limits of a reply's
+	// tool loop; model configs and sessions may override them.
+	MaxTurns           int `mapstructure:"max_turns"`
+	MaxToolCalls       int `mapstructure:"max_tool_calls"`
+	MaxDurationSeconds int `mapstructure:"max_duration_seconds"`
+}
+
+// ConversationConfig controls how conversations are kept.
+type ConversationConfig struct {
+	// AutoTitle names untitled conversations after their first exchange.
+	AutoTitle bool `mapstructure:"auto_title"`
+	// TitleModelConfigID is the model config used to write titles, typically a cheap
+	// model; 0 uses the cheapest priced chat model of the conversation's provider, then
+	// the conversation's own model.
+	TitleModelConfigID uint `mapstructure:"title_model_config_id"`
+}
+
 // AppConfig is the root configuration structure.
 type AppConfig struct {
-	Server ServerConfig `mapstructure:"server"`
-	Auth   AuthConfig   `mapstructure:"auth"`
+	Server   ServerConfig   `mapstructure:"server"`
+	Auth     AuthConfig     `mapstructure:"auth"`
+	Security SecurityConfig `mapstructure:"security"`
+	Storage  StorageConfig  `mapstructure:"storage"`
+	Agent    AgentConfig    `mapstructure:"agent"`
+
+	Conversation ConversationConfig `mapstructure:"conversation"`
 }
 
 var (
@@ -53,6 +99,17 @@ func LoadConfig() AppConfig {
 		v.SetDefault("server.port", "8080")
 		v.SetDefault("auth.jwt_secret", "change-me-in-config")
 		v.SetDefault("auth.token_lifetime_seconds", 86400)
+		v.SetDefault("storage.files_dir", "data/files")
+		v.SetDefault("storage.max_attachment_mb", 20)
+		v.SetDefault("storage.max_document_pages", 200)
+		v.SetDefault("storage.max_extracted_chars", 200000)
+		v.SetDefault("agent.tool_concurrency", 4)
+		v.SetDefault("agent.tool_timeout_seconds", 60)
+		v.SetDefault("agent.max_turns", 5)
+		v.SetDefault("agent.max_tool_calls", 20)
+		v.SetDefault("agent.max_duration_seconds", 300)
+		v.SetDefault("conversation.auto_title", true)
+		v.SetDefault("conversation.title_model_config_id", 0)
 
 		if err := v.ReadInConfig(); err != nil {
 			log.Printf("Config: unable to read config file %s, using defaults: %v", configPath, err)
@@ -61,6 +118,13 @@ func LoadConfig() AppConfig {
 		if err := v.Unmarshal(&appConfig); err != nil {
 			log.Printf("Config: failed to unmarshal config: %v", err)
 		}
+
+		if masterKey := os.Getenv("FNCHATBOT_MASTER_KEY"); masterKey != "" {
+			appConfig.Security.MasterKey = masterKey
+		}
+		if filesDir := os.Getenv("FNCHATBOT_FILES_DIR"); filesDir != "" {
+			appConfig.Storage.FilesDir = filesDir
+		}
 	})
 
 	return appConfig
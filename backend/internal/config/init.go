@@ -51,8 +51,10 @@ func InitSystemProviders(db *gorm.DB) error {
 				SupportedEndpointTypes: modelDef.SupportedEndpointTypes,
 				EndpointType:           modelDef.EndpointType,
 				MaxTokens:              modelDef.MaxTokens,
+				ContextLength:          modelDef.ContextLength,
 				InputPrice:             modelDef.InputPrice,
 				OutputPrice:            modelDef.OutputPrice,
+				ImagePrice:             modelDef.ImagePrice,
 				Enabled:                true,
 			}
 
@@ -144,10 +146,14 @@ func syncProviderModels(db *gorm.DB, providerDBID uint, providerID string) error
 				"max_tokens":               modelDef.MaxTokens,
 				"input_price":              modelDef.InputPrice,
 				"output_price":             modelDef.OutputPrice,
+				"image_price":              modelDef.ImagePrice,
 			}
 			if updates["max_tokens"].(int) == 0 {
 				updates["max_tokens"] = 4096
 			}
+			if modelDef.ContextLength > 0 {
+				updates["context_length"] = modelDef.ContextLength
+			}
 			if err := db.Model(&existingModel).Updates(updates).Error; err != nil {
 				log.Printf("Failed to update model %s: %v", modelDef.ModelID, err)
 			}
@@ -168,8 +174,10 @@ func syncProviderModels(db *gorm.DB, providerDBID uint, providerID string) error
 				SupportedEndpointTypes: modelDef.SupportedEndpointTypes,
 				EndpointType:           modelDef.EndpointType,
 				MaxTokens:              maxTokens,
+				ContextLength:          modelDef.ContextLength,
 				InputPrice:             modelDef.InputPrice,
 				OutputPrice:            modelDef.OutputPrice,
+				ImagePrice:             modelDef.ImagePrice,
 				Enabled:                true,
 			}
 
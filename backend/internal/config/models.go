@@ -12,8 +12,10 @@ type ModelDefinition struct {
 	SupportedEndpointTypes []models.EndpointType    `json:"supported_endpoint_types"`
 	EndpointType           models.EndpointType      `json:"endpoint_type"`
 	MaxTokens              int                      `json:"max_tokens"`
+	ContextLength          int                      `json:"context_length"`
 	InputPrice             float64                  `json:"input_price"`
 	OutputPrice            float64                  `json:"output_price"`
+	ImagePrice             float64                  `json:"image_price"`
 }
 
 type ProviderModels struct {
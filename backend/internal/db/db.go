@@ -47,15 +47,25 @@ func InitDB(dbPath string) {
 	err = DB.AutoMigrate(
 		&models.User{},
 		&models.Provider{},
+		&models.ProviderKey{},
 		&models.Model{},
 		&models.ModelConfig{},
 		&models.Session{},
 		&models.Message{},
 		&models.Part{},
+		&models.TokenUsage{},
+		&models.Budget{},
+		&models.BudgetReset{},
+		&models.SystemPrompt{},
+		&models.KnowledgeBase{},
+		&models.KnowledgeFile{},
+		&models.KnowledgeChunk{},
 		&models.Skill{},
 		&models.AgentTask{},
 		&models.SandboxConfig{},
 		&models.SandboxPath{},
+		&models.ToolPolicy{},
+		&models.ShareLink{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database schema: %v", err)
@@ -64,6 +74,7 @@ func InitDB(dbPath string) {
 	// Seed initial data if needed
 	seedSkills()
 	seedSandboxConfig()
+	seedSystemPrompt()
 
 	// Migrate legacy MCP config from DB to mcp.json if that file does not exist
 	if err := MigrateMCPConfigToFile("mcp.json"); err != nil {
@@ -182,6 +193,24 @@ func seedSkills() {
 	}
 }
 
+func seedSystemPrompt() {
+	var count int64
+	DB.Model(&models.SystemPrompt{}).Where("scope = ?", models.PromptScopeGlobal).Count(&count)
+	if count == 0 {
+		prompt := models.SystemPrompt{
+			Name:    "Default",
+			Scope:   models.PromptScopeGlobal,
+			Content: models.DefaultSystemPrompt,
+			Enabled: true,
+		}
+		if err := DB.Create(&prompt).Error; err != nil {
+			log.Printf("Failed to seed system prompt: %v", err)
+		} else {
+			log.Println("Seeded default system prompt")
+		}
+	}
+}
+
 func seedSandboxConfig() {
 	var count int64
 	DB.Model(&models.SandboxConfig{}).Count(&count)
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/secrets"
+
+	"gorm.io/gorm"
+)
+
+// EncryptSecrets encrypts any plaintext provider keys and MCP secrets with the
+// configured master key. It is a no-op when no master key is set.
+func EncryptSecrets(mcpFilePath string) {
+	c := secrets.Default()
+	if c == nil {
+		log.Println("No master key configured; provider API keys and MCP secrets are stored in plaintext")
+		return
+	}
+	n, err := ReencryptSecrets(DB, mcpFilePath, c, c)
+	if err != nil {
+		log.Printf("Failed to encrypt secrets at rest: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Encrypted %d plaintext secret(s) at rest", n)
+	}
+}
+
+// ReencryptSecrets re-seals every stored secret with to. Values are opened with from,
+// which may be nil when only plaintext is expected. Values already sealed by to are
+// left untouched, so running it twice is safe. It returns the number of values rewritten.
+// The new mcp.json is written to a temporary file before the database commits and moved
+// into place after, so a failed write rolls the database back.
+func ReencryptSecrets(conn *gorm.DB, mcpFilePath string, from, to *secrets.Cipher) (int, error) {
+	reseal := func(value string) (string, bool, error) {
+		if value == "" || secrets.KeyIDOf(value) == to.KeyID() {
+			return value, false, nil
+		}
+		plain := value
+		if secrets.IsEncrypted(value) {
+			if from == nil {
+				return "", false, secrets.ErrNoMasterKey
+			}
+			var err error
+			if plain, err = from.Decrypt(value); err != nil {
+				return "", false, err
+			}
+		}
+		sealed, err := to.Encrypt(plain)
+		return sealed, err == nil, err
+	}
+
+	count := 0
+	var mcpTemp string
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		var providers []models.Provider
+		if err := tx.Where("api_key <> ''").Find(&providers).Error; err != nil {
+			return err
+		}
+		for _, p := range providers {
+			sealed, changed, err := reseal(p.APIKey)
+			if err != nil {
+				return fmt.Errorf("provider %s: %w", p.ProviderID, err)
+			}
+			if changed {
+				if err := tx.Model(&models.Provider{}).Where("id = ?", p.ID).UpdateColumn("api_key", sealed).Error; err != nil {
+					return err
+				}
+				count++
+			}
+		}
+
+		var keys []models.ProviderKey
+		if err := tx.Find(&keys).Error; err != nil {
+			return err
+		}
+		for _, k := range keys {
+			sealed, changed, err := reseal(k.Key)
+			if err != nil {
+				return fmt.Errorf("provider key %d: %w", k.ID, err)
+			}
+			if changed {
+				if err := tx.Model(&models.ProviderKey{}).Where("id = ?", k.ID).UpdateColumn("key", sealed).Error; err != nil {
+					return err
+				}
+				count++
+			}
+		}
+
+		n, temp, err := reencryptMCPFile(mcpFilePath, reseal)
+		if err != nil {
+			return fmt.Errorf("mcp.json: %w", err)
+		}
+		count, mcpTemp = count+n, temp
+		return nil
+	})
+	if err != nil {
+		if mcpTemp != "" {
+			os.Remove(mcpTemp)
+		}
+		return 0, err
+	}
+	if mcpTemp != "" {
+		if err := os.Rename(mcpTemp, mcpFilePath); err != nil {
+			os.Remove(mcpTemp)
+			return count, fmt.Errorf("mcp.json: %w", err)
+		}
+	}
+	return count, nil
+}
+
+// reencryptMCPFile reseals the secrets of the MCP config at path into a temporary file
+// next to it and returns that file's path, or "" when nothing changed.
+func reencryptMCPFile(path string, reseal func(string) (string, bool, error)) (int, string, error) {
+	if path == "" {
+		return 0, "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, "", nil
+		}
+		return 0, "", err
+	}
+	var f models.MCPFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return 0, "", err
+	}
+
+	count := 0
+	resealMap := func(m map[string]string) error {
+		for k, v := range m {
+			sealed, changed, err := reseal(v)
+			if err != nil {
+				return err
+			}
+			if changed {
+				m[k] = sealed
+				count++
+			}
+		}
+		return nil
+	}
+	for name, cfg := range f.Servers {
+		sealed, changed, err := reseal(cfg.ApiKey)
+		if err != nil {
+			return 0, "", fmt.Errorf("mcp %s: %w", name, err)
+		}
+		if changed {
+			cfg.ApiKey = sealed
+			count++
+		}
+		if err := resealMap(cfg.Env); err != nil {
+			return 0, "", fmt.Errorf("mcp %s env: %w", name, err)
+		}
+		if err := resealMap(cfg.Headers); err != nil {
+			return 0, "", fmt.Errorf("mcp %s headers: %w", name, err)
+		}
+		f.Servers[name] = cfg
+	}
+	if count == 0 {
+		return 0, "", nil
+	}
+
+	out, err := json.MarshalIndent(&f, "", "  ")
+	if err != nil {
+		return 0, "", err
+	}
+	// CreateTemp opens the file owner-only, as mcp.json must be
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".mcp-*.json")
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return 0, "", err
+	}
+	return count, tmp.Name(), nil
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Budget roles match the user "type" used by the user management API.
+const (
+	BudgetRoleAdmin = "admin"
+	BudgetRoleUser  = "user"
+)
+
+// Budget caps a user's token usage and cost per day and per month.
+// A row either sets the default for a role (Role set, UserID 0) or overrides
+// it for one user (UserID set). Zero limits mean unlimited.
+type Budget struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Role          string    `gorm:"type:varchar(20);index" json:"role,omitempty"`
+	UserID        uint      `gorm:"index" json:"user_id,omitempty"`
+	DailyTokens   int64     `json:"daily_tokens"`
+	MonthlyTokens int64     `json:"monthly_tokens"`
+	DailyCost     float64   `json:"daily_cost"`
+	MonthlyCost   float64   `json:"monthly_cost"`
+	WarnAt        float64   `gorm:"default:0.8" json:"warn_at"` // fraction of a limit that triggers a warning
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// BudgetReset marks the point from which a user's consumption is counted again.
+// Usage records are kept; a reset only moves the start of the current period.
+type BudgetReset struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         uint       `gorm:"uniqueIndex" json:"user_id"`
+	DailyResetAt   *time.Time `json:"daily_reset_at"`
+	MonthlyResetAt *time.Time `json:"monthly_reset_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// KnowledgeStatus is the indexing state of a knowledge base
+type KnowledgeStatus string
+
+const (
+	KnowledgeStatusIdle     KnowledgeStatus = "idle"
+	KnowledgeStatusIndexing KnowledgeStatus = "indexing"
+	KnowledgeStatusError    KnowledgeStatus = "error"
+)
+
+// KnowledgeBase is a set of NAS directories indexed for semantic search. Paths must lie
+// inside the sandbox allowed paths.
+type KnowledgeBase struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Name        string `gorm:"type:varchar(100);not null" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+	UserID      uint   `gorm:"index" json:"user_id"`
+	// ModelID references a catalogue Model with the embedding capability
+	ModelID      uint            `gorm:"index;not null" json:"model_id"`
+	Model        *Model          `gorm:"foreignKey:ModelID" json:"model,omitempty"`
+	Paths        []string        `gorm:"type:text;serializer:json" json:"paths"`
+	ChunkSize    int             `gorm:"default:1000" json:"chunk_size"`   // characters per chunk
+	ChunkOverlap int             `gorm:"default:200" json:"chunk_overlap"` // characters shared by neighbouring chunks
+	Status       KnowledgeStatus `gorm:"type:varchar(20);default:'idle'" json:"status"`
+	LastError    string          `gorm:"type:text" json:"last_error"`
+	FileCount    int             `gorm:"default:0" json:"file_count"`
+	ChunkCount   int             `gorm:"default:0" json:"chunk_count"`
+	IndexedAt    *time.Time      `json:"indexed_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// KnowledgeFile tracks an indexed file so unchanged files are skipped on re-index
+type KnowledgeFile struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	KnowledgeBaseID uint      `gorm:"uniqueIndex:idx_knowledge_file;not null" json:"knowledge_base_id"`
+	Path            string    `gorm:"type:varchar(1000);uniqueIndex:idx_knowledge_file;not null" json:"path"`
+	Size            int64     `json:"size"`
+	ModTime         time.Time `json:"mod_time"`
+	Chunks          int       `json:"chunks"`
+	Error           string    `gorm:"type:text" json:"error,omitempty"`
+	IndexedAt       time.Time `json:"indexed_at"`
+}
+
+// KnowledgeChunk is a piece of a file's text with its embedding vector, stored as
+// little-endian float32 values
+type KnowledgeChunk struct {
+	ID              uint   `gorm:"primaryKey" json:"id"`
+	KnowledgeBaseID uint   `gorm:"index;not null" json:"knowledge_base_id"`
+	FileID          uint   `gorm:"index;not null" json:"file_id"`
+	Seq             int    `json:"seq"`
+	Content         string `gorm:"type:text;not null" json:"content"`
+	Embedding       []byte `json:"-"`
+}
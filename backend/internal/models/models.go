@@ -18,10 +18,48 @@ type ModelConfig struct {
 	MaxTokens   int       `gorm:"default:2048" json:"max_tokens"`
 	IsDefault   bool      `gorm:"default:false;index" json:"is_default"`
 	UserID      uint      `gorm:"index" json:"user_id"`
+	// Fallbacks are tried in order when the primary provider keeps failing with 429/5xx.
+	Fallbacks  []ModelFallback `gorm:"type:text;serializer:json" json:"fallbacks"`
+	MaxRetries *int            `json:"max_retries,omitempty"` // nil = service default
+	// ContextStrategy handles history beyond the context window: truncate, summarize or none
+	ContextStrategy string `gorm:"type:varchar(20);default:'truncate'" json:"context_strategy"`
+	ContextWindow   int    `gorm:"default:0" json:"context_window"` // 0 = from model catalogue
+	// ThinkingBudget enables extended thinking on models that need it switched on (Anthropic); 0 = off
+	ThinkingBudget int `gorm:"default:0" json:"thinking_budget"`
+	// AgentLimits bound the tool loop of replies with this model; sessions may override them
+	AgentLimits `gorm:"embedded"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// AgentLimits bound how long a reply may keep calling tools. Zero fields inherit the
+// next level: session, then model config, then the application config.
+type AgentLimits struct {
+	MaxTurns           int `gorm:"default:0" json:"max_turns"`            // model calls per reply
+	MaxToolCalls       int `gorm:"default:0" json:"max_tool_calls"`       // tool calls per reply
+	MaxDurationSeconds int `gorm:"default:0" json:"max_duration_seconds"` // wall-clock time per reply
+}
+
+// Or fills the zero fields of l from fallback.
+func (l AgentLimits) Or(fallback AgentLimits) AgentLimits {
+	if l.MaxTurns == 0 {
+		l.MaxTurns = fallback.MaxTurns
+	}
+	if l.MaxToolCalls == 0 {
+		l.MaxToolCalls = fallback.MaxToolCalls
+	}
+	if l.MaxDurationSeconds == 0 {
+		l.MaxDurationSeconds = fallback.MaxDurationSeconds
+	}
+	return l
+}
+
+// ModelFallback is a secondary provider/model pair in a model config's fallback chain
+type ModelFallback struct {
+	ProviderID uint   `json:"provider_id"`
+	Model      string `json:"model"`
+}
+
 // Session represents a chat session
 type Session struct {
 	ID        uint        `gorm:"primaryKey" json:"id"`
@@ -29,10 +67,28 @@ type Session struct {
 	ModelID   uint        `json:"model_id"`
 	Model     ModelConfig `gorm:"foreignKey:ModelID" json:"model,omitempty"`
 	UserID    uint        `gorm:"index" json:"user_id"`
-	CreatedAt time.Time   `gorm:"index" json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
+	PersonaID *uint       `json:"persona_id"` // optional SystemPrompt with persona scope
+	// AgentLimits override the model config's limits for this conversation
+	AgentLimits `gorm:"embedded"`
+	// AllowedTools are "ask" tools the user allowed for the rest of this conversation
+	AllowedTools []string `gorm:"type:text;serializer:json" json:"allowed_tools,omitempty"`
+	// TitleSource is "user" for titles the user chose and "auto" for generated ones;
+	// conversations without one are titled after their first exchange
+	TitleSource string    `gorm:"type:varchar(10)" json:"title_source"`
+	Pinned      bool      `gorm:"default:false;index" json:"pinned"`
+	Archived    bool      `gorm:"default:false;index" json:"archived"`
+	Folder      string    `gorm:"type:varchar(100);index" json:"folder"`
+	Tags        []string  `gorm:"type:text;serializer:json" json:"tags"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// Session title sources
+const (
+	TitleSourceUser = "user"
+	TitleSourceAuto = "auto"
+)
+
 // MessageRole defines the role of the message sender
 type MessageRole string
 
@@ -48,7 +104,19 @@ type Message struct {
 	SessionID uint        `gorm:"index;not null" json:"session_id"`
 	Role      MessageRole `gorm:"type:varchar(20);not null" json:"role"`
 	Parts     []Part      `gorm:"foreignKey:MessageID" json:"parts"`
-	CreatedAt time.Time   `gorm:"index" json:"created_at"`
+	Usage     *TokenUsage `gorm:"foreignKey:MessageID" json:"usage,omitempty"`
+	// Interrupted marks a reply the user stopped before it completed
+	Interrupted bool `gorm:"default:false" json:"interrupted,omitempty"`
+	// ParentID links messages into a tree: regenerated replies and edited prompts are
+	// siblings of the message they replace. Nil for the first message of a conversation.
+	ParentID *uint `gorm:"index" json:"parent_id"`
+	// BranchSeq orders activations within the session; the message with the highest is
+	// the leaf of the active branch. Rolling summaries, which are not part of the tree, keep 0.
+	BranchSeq int64 `gorm:"default:0;index" json:"-"`
+	// Siblings lists the IDs of this message and its siblings in creation order; it is
+	// filled by the messages API for switching branches
+	Siblings  []uint    `gorm:"-" json:"siblings,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
 }
 
 // PartType defines the type of message part
@@ -59,6 +127,8 @@ const (
 	PartTypeFile       PartType = "file"
 	PartTypeToolCall   PartType = "tool_calls"
 	PartTypeToolResult PartType = "tool_result"
+	// PartTypeReasoning holds the model's thinking; it is shown to users but never sent back
+	PartTypeReasoning PartType = "reasoning"
 )
 
 // Part represents a part of a message content
@@ -71,10 +141,26 @@ type Part struct {
 	CreatedAt time.Time      `json:"created_at"`
 }
 
+// SummaryPartMeta marks a system text part holding the rolling summary of older turns
+type SummaryPartMeta struct {
+	ContextSummary bool `json:"context_summary"`
+	// UpTo is the last message the summary covers; it applies to branches through it
+	UpTo uint `json:"up_to,omitempty"`
+}
+
 // FilePartMeta defines metadata for file parts
 type FilePartMeta struct {
 	Mime     string `json:"mime"`
 	Filename string `json:"filename"`
+	// Hash is set when the content lives in the file store; Part.Content then holds
+	// the hash instead of inline base64
+	Hash string `json:"hash,omitempty"`
+	Size int64  `json:"size,omitempty"`
+	URL  string `json:"url,omitempty"`
+	// Text is the content extracted from document attachments (PDF, DOCX, text files)
+	Text      string `json:"text,omitempty"`
+	Pages     int    `json:"pages,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
 }
 
 // MCPConfig and Skill moved to separate files
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// PromptScope defines where a system prompt template applies
+type PromptScope string
+
+const (
+	// PromptScopeGlobal is the instance-wide default, managed by admins
+	PromptScopeGlobal PromptScope = "global"
+	// PromptScopeUser is appended for every session of its owner
+	PromptScopeUser PromptScope = "user"
+	// PromptScopePersona is an assistant persona selected per session
+	PromptScopePersona PromptScope = "persona"
+)
+
+// DefaultSystemPrompt seeds the global prompt on first start
+const DefaultSystemPrompt = `You are FnChatBot, an AI assistant running on the user's fnOS NAS.
+Today is {{date}} ({{weekday}}). You are talking to {{username}}.
+
+You can use tools to work with files and run commands on the NAS. File access is limited to these sandbox directories: {{allowed_paths}}.
+Available skills:
+{{skills}}
+
+Use tools when they help; ask before doing anything destructive. Answer in the user's language.`
+
+// SystemPrompt is an editable system prompt template. Content may use variables
+// such as {{date}}, {{username}}, {{allowed_paths}} and {{skills}}.
+type SystemPrompt struct {
+	ID          uint        `gorm:"primaryKey" json:"id"`
+	Name        string      `gorm:"type:varchar(100);not null" json:"name"`
+	Description string      `gorm:"type:text" json:"description"`
+	Scope       PromptScope `gorm:"type:varchar(20);not null;index" json:"scope"`
+	UserID      uint        `gorm:"index" json:"user_id"` // owner; 0 for global prompts and shared personas
+	Content     string      `gorm:"type:text;not null" json:"content"`
+	Enabled     bool        `gorm:"default:true" json:"enabled"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
@@ -75,6 +75,16 @@ type ProviderApiOptions struct {
 	IsNotSupportVerbosity      bool `json:"is_not_support_verbosity"`
 }
 
+// KeySelection 定义多 API Key 的选择策略
+type KeySelection string
+
+const (
+	// KeySelectionRoundRobin 轮询
+	KeySelectionRoundRobin KeySelection = "round_robin"
+	// KeySelectionLeastRateLimited 优先使用最久未被限流的 Key
+	KeySelectionLeastRateLimited KeySelection = "least_rate_limited"
+)
+
 // Provider 定义 AI 服务提供商
 type Provider struct {
 	ID         uint               `gorm:"primaryKey" json:"id"`
@@ -82,13 +92,36 @@ type Provider struct {
 	Name       string             `gorm:"type:varchar(100);not null" json:"name"`
 	Type       ProviderType       `gorm:"type:varchar(50);not null;index" json:"type"`
 	BaseURL    string             `gorm:"type:varchar(500);not null" json:"base_url"`
-	APIKey     string             `gorm:"type:varchar(500)" json:"api_key,omitempty"`
+	APIKey     string             `gorm:"type:varchar(500)" json:"api_key,omitempty"` // 加密存储，响应中脱敏
 	Enabled    bool               `gorm:"default:false;index" json:"enabled"`
 	IsSystem   bool               `gorm:"default:false" json:"is_system"`
 	ApiOptions ProviderApiOptions `gorm:"type:text;serializer:json" json:"api_options"`
 	Models     []Model            `gorm:"foreignKey:ProviderID;references:ID" json:"models,omitempty"`
-	CreatedAt  time.Time          `json:"created_at"`
-	UpdatedAt  time.Time          `json:"updated_at"`
+	// KeySelection 仅在配置了多个 Keys 时生效；
+	// 只要有可用（启用且未冷却）的 Keys，APIKey 本身不参与轮换，仅作兜底
+	KeySelection KeySelection  `gorm:"type:varchar(30);default:'round_robin'" json:"key_selection"`
+	Keys         []ProviderKey `gorm:"foreignKey:ProviderID;references:ID" json:"keys,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// ProviderKey 定义供应商的一个额外 API Key 及其健康状态
+type ProviderKey struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	ProviderID        uint       `gorm:"index;not null" json:"provider_id"`
+	Label             string     `gorm:"type:varchar(100)" json:"label"`
+	Key               string     `gorm:"type:varchar(500);not null" json:"-"`
+	MaskedKey         string     `gorm:"-" json:"masked_key"` // 由 API 层在响应前填充
+	Enabled           bool       `gorm:"default:true" json:"enabled"`
+	UsageCount        int64      `gorm:"default:0" json:"usage_count"`
+	FailureCount      int64      `gorm:"default:0" json:"failure_count"`
+	RateLimitCount    int64      `gorm:"default:0" json:"rate_limit_count"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	LastRateLimitedAt *time.Time `json:"last_rate_limited_at"`
+	CooldownUntil     *time.Time `json:"cooldown_until"`
+	LastError         string     `gorm:"type:text" json:"last_error"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
 // Model 定义 AI 模型信息
@@ -105,8 +138,10 @@ type Model struct {
 	SupportedEndpointTypes []EndpointType    `gorm:"type:text;serializer:json" json:"supported_endpoint_types"`
 	EndpointType           EndpointType      `gorm:"type:varchar(50)" json:"endpoint_type"`
 	MaxTokens              int               `gorm:"default:4096" json:"max_tokens"`
+	ContextLength          int               `gorm:"default:0" json:"context_length"` // 上下文窗口大小,0 表示未知
 	InputPrice             float64           `gorm:"type:decimal(10,6);default:0" json:"input_price"`
 	OutputPrice            float64           `gorm:"type:decimal(10,6);default:0" json:"output_price"`
+	ImagePrice             float64           `gorm:"type:decimal(10,6);default:0" json:"image_price"` // 每张生成图片的价格
 	SupportedTextDelta     bool              `gorm:"default:true" json:"supported_text_delta"`
 	Enabled                bool              `gorm:"default:true;index" json:"enabled"`
 	IsDefault              bool              `gorm:"default:false" json:"is_default"`
@@ -119,6 +154,11 @@ func (Provider) TableName() string {
 	return "providers"
 }
 
+// TableName 指定 ProviderKey 表名
+func (ProviderKey) TableName() string {
+	return "provider_keys"
+}
+
 // TableName 指定 Model 表名
 func (Model) TableName() string {
 	return "models"
@@ -152,6 +192,11 @@ func (m *Model) BeforeUpdate(tx *gorm.DB) error {
 	return nil
 }
 
+// InCooldown 检查 Key 是否处于冷却期
+func (k *ProviderKey) InCooldown(now time.Time) bool {
+	return k.CooldownUntil != nil && k.CooldownUntil.After(now)
+}
+
 // HasCapability 检查模型是否具有指定能力
 func (m *Model) HasCapability(capability ModelCapability) bool {
 	for _, c := range m.Capabilities {
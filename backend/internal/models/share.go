@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// ShareLink gives read-only access to a conversation to anyone with its token, without
+// logging in. Revoking a link deletes it.
+type ShareLink struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	Token     string `gorm:"type:varchar(64);not null;uniqueIndex" json:"token"`
+	SessionID uint   `gorm:"index;not null" json:"session_id"`
+	UserID    uint   `gorm:"index;not null" json:"user_id"`
+	// RedactToolOutputs hides tool results, which may hold data the answer did not show
+	RedactToolOutputs bool `gorm:"default:false" json:"redact_tool_outputs"`
+	// ExpiresAt is when the link stops working; nil links work until revoked
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Expired reports whether the link has expired at now.
+func (l *ShareLink) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Tool policies decide whether a tool call needs the user's confirmation.
+const (
+	ToolPolicyAuto = "auto" // run without asking
+	ToolPolicyAsk  = "ask"  // ask the user over the chat websocket before every call
+	ToolPolicyDeny = "deny" // never run
+)
+
+// ToolPolicy sets how calls to a tool are approved. Tool is the name of a built-in or
+// skill tool, or "server__tool" for an MCP tool; "server__*" covers every tool of an
+// MCP server. A row with UserID 0 is set by an admin for everyone, and a user's own
+// row can only make it stricter.
+type ToolPolicy struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_tool_policy_user_tool" json:"user_id"`
+	Tool      string    `gorm:"type:varchar(200);not null;uniqueIndex:idx_tool_policy_user_tool" json:"tool"`
+	Policy    string    `gorm:"type:varchar(10);not null" json:"policy"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
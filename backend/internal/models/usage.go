@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// TokenUsage records the tokens consumed by one assistant message
+type TokenUsage struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	MessageID        uint      `gorm:"index" json:"message_id"`
+	SessionID        uint      `gorm:"index" json:"session_id"`
+	UserID           uint      `gorm:"index" json:"user_id"`
+	ModelConfigID    uint      `gorm:"index" json:"model_config_id"`
+	ProviderID       uint      `gorm:"index" json:"provider_id"`
+	Model            string    `gorm:"type:varchar(100);index" json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	ReasoningTokens  int       `json:"reasoning_tokens"`
+	CachedTokens     int       `json:"cached_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	Estimated        bool      `gorm:"default:false" json:"estimated"` // counted locally because the provider omitted usage
+	Cost             float64   `json:"cost"`                           // in the provider's price currency
+	CreatedAt        time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName pins the table name for raw aggregation queries
+func (TokenUsage) TableName() string {
+	return "token_usages"
+}
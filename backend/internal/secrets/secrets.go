@@ -0,0 +1,241 @@
+// Package secrets provides envelope encryption for credentials stored at rest
+// (provider API keys in SQLite, MCP api_key/env/headers in mcp.json).
+//
+// Each value gets its own random data key (DEK) which encrypts the plaintext with
+// AES-256-GCM; the DEK is in turn wrapped by the master key (KEK). Stored values
+// look like "enc:v1:<key-id>:<wrapped-dek>:<ciphertext>", so plaintext legacy
+// values remain readable and the master key that sealed a value can be identified
+// during rotation.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const prefix = "enc:v1:"
+
+// ErrNoMasterKey is returned when an encrypted value is read without a master key.
+var ErrNoMasterKey = errors.New("secret is encrypted but no master key is configured")
+
+// Cipher seals and opens values with one master key.
+type Cipher struct {
+	kek   []byte
+	keyID string
+}
+
+// NewCipher derives a cipher from a master key. A base64 value decoding to 32 bytes
+// is used directly; anything else is treated as a passphrase and hashed.
+func NewCipher(masterKey string) (*Cipher, error) {
+	masterKey = strings.TrimSpace(masterKey)
+	if masterKey == "" {
+		return nil, errors.New("master key is empty")
+	}
+	kek, err := base64.StdEncoding.DecodeString(masterKey)
+	if err != nil || len(kek) != 32 {
+		sum := sha256.Sum256([]byte(masterKey))
+		kek = sum[:]
+	}
+	id := sha256.Sum256(kek)
+	return &Cipher{kek: kek, keyID: hex.EncodeToString(id[:4])}, nil
+}
+
+// KeyID identifies the master key without revealing it.
+func (c *Cipher) KeyID() string {
+	return c.keyID
+}
+
+// Encrypt seals plain with a fresh data key. Empty input stays empty.
+func (c *Cipher) Encrypt(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(c.kek, dek)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(dek, []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return prefix + c.keyID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Values without the envelope prefix
+// are returned unchanged so legacy plaintext keeps working.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted secret")
+	}
+	if parts[0] != c.keyID {
+		return "", fmt.Errorf("secret was encrypted with master key %s, current key is %s", parts[0], c.keyID)
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed data key: %w", err)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	dek, err := open(c.kek, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plain, err := open(dek, sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plain), nil
+}
+
+func seal(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether value carries the envelope prefix.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyIDOf returns the master key ID that sealed value, or "" for plaintext.
+func KeyIDOf(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	rest := strings.TrimPrefix(value, prefix)
+	if i := strings.IndexByte(rest, ':'); i > 0 {
+		return rest[:i]
+	}
+	return ""
+}
+
+var (
+	mu            sync.RWMutex
+	defaultCipher *Cipher
+)
+
+// Init configures the process-wide master key. An empty key disables encryption:
+// new values are stored as plaintext and encrypted values cannot be read.
+func Init(masterKey string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.TrimSpace(masterKey) == "" {
+		defaultCipher = nil
+		return nil
+	}
+	c, err := NewCipher(masterKey)
+	if err != nil {
+		return err
+	}
+	defaultCipher = c
+	return nil
+}
+
+// Default returns the process-wide cipher, or nil when no master key is configured.
+func Default() *Cipher {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultCipher
+}
+
+// Enabled reports whether a master key is configured.
+func Enabled() bool {
+	return Default() != nil
+}
+
+// Encrypt seals plain with the default cipher, or returns it unchanged when
+// encryption is disabled or the value is already encrypted.
+func Encrypt(plain string) (string, error) {
+	c := Default()
+	if c == nil || IsEncrypted(plain) {
+		return plain, nil
+	}
+	return c.Encrypt(plain)
+}
+
+// Decrypt opens value with the default cipher. Plaintext passes through.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	c := Default()
+	if c == nil {
+		return "", ErrNoMasterKey
+	}
+	return c.Decrypt(value)
+}
+
+// maskPrefix stands in for all but the last four characters of a masked secret.
+const maskPrefix = "****"
+
+// Mask returns a display-safe form of a stored (possibly encrypted) secret: only its
+// last four characters are shown, and none of a short secret.
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	plain, err := Decrypt(value)
+	if err != nil || len(plain) <= 8 {
+		return maskPrefix
+	}
+	return maskPrefix + plain[len(plain)-4:]
+}
+
+// IsMasked reports whether value is exactly the masked form of stored, i.e. a client
+// echoed back the masked secret rather than providing a new one.
+func IsMasked(value, stored string) bool {
+	return value != "" && stored != "" && value == Mask(stored)
+}
+
+// LooksMasked reports whether value has the form of Mask output, whatever the secret.
+// A client that sends it for a secret other than the masked one must be refused.
+func LooksMasked(value string) bool {
+	return value == maskPrefix || (len(value) == len(maskPrefix)+4 && strings.HasPrefix(value, maskPrefix))
+}
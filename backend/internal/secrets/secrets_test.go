@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipherRoundTrip(t *testing.T) {
+	c, err := NewCipher("correct horse battery staple")
+	assert.NoError(t, err)
+
+	sealed, err := c.Encrypt("sk-live-1234567890")
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+	assert.Equal(t, c.KeyID(), KeyIDOf(sealed))
+	assert.NotContains(t, sealed, "1234567890")
+
+	plain, err := c.Decrypt(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-live-1234567890", plain)
+
+	// Each value uses a fresh data key and nonce.
+	again, _ := c.Encrypt("sk-live-1234567890")
+	assert.NotEqual(t, sealed, again)
+
+	// Legacy plaintext passes through untouched.
+	plain, err = c.Decrypt("legacy-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy-key", plain)
+}
+
+func TestCipherWrongKey(t *testing.T) {
+	a, _ := NewCipher("key-a")
+	b, _ := NewCipher("key-b")
+
+	sealed, err := a.Encrypt("secret")
+	assert.NoError(t, err)
+
+	_, err = b.Decrypt(sealed)
+	assert.Error(t, err)
+}
+
+func TestDefaultCipherAndMask(t *testing.T) {
+	assert.NoError(t, Init(""))
+	assert.False(t, Enabled())
+
+	value, err := Encrypt("sk-abcdefgh1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-abcdefgh1234", value)
+	assert.Equal(t, "****1234", Mask(value))
+	assert.Equal(t, "****", Mask("short"))
+
+	assert.NoError(t, Init("master"))
+	defer func() { _ = Init("") }()
+
+	sealed, err := Encrypt("sk-abcdefgh1234")
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+	assert.Equal(t, "****1234", Mask(sealed))
+	assert.True(t, IsMasked(Mask(sealed), sealed))
+	assert.False(t, IsMasked("key****1234", sealed))
+	assert.False(t, IsMasked("****9999", sealed))
+	assert.True(t, LooksMasked("****9999"))
+	assert.False(t, LooksMasked("abc****defgh"))
+
+	// Already-encrypted values are not double wrapped.
+	same, _ := Encrypt(sealed)
+	assert.Equal(t, sealed, same)
+
+	assert.NoError(t, Init(""))
+	_, err = Decrypt(sealed)
+	assert.ErrorIs(t, err, ErrNoMasterKey)
+}
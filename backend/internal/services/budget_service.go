@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+)
+
+// BudgetPeriodStatus is a user's consumption against one period's limits.
+type BudgetPeriodStatus struct {
+	Period     string    `json:"period"`
+	Since      time.Time `json:"since"`
+	Tokens     int64     `json:"tokens"`
+	Cost       float64   `json:"cost"`
+	TokenLimit int64     `json:"token_limit"`
+	CostLimit  float64   `json:"cost_limit"`
+}
+
+// BudgetStatus is the effective budget of a user and what they have used of it.
+type BudgetStatus struct {
+	UserID   uint               `json:"user_id"`
+	Budget   *models.Budget     `json:"budget"` // nil when no budget applies
+	Daily    BudgetPeriodStatus `json:"daily"`
+	Monthly  BudgetPeriodStatus `json:"monthly"`
+	Exceeded bool               `json:"exceeded"`
+	Warnings []string           `json:"warnings,omitempty"`
+}
+
+// ErrBudgetExceeded is returned by Check once a user has used up a budget.
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// BudgetService resolves and enforces per-user token and cost budgets.
+type BudgetService struct {
+	DB *gorm.DB
+}
+
+// NewBudgetService creates a BudgetService.
+func NewBudgetService(db *gorm.DB) *BudgetService {
+	return &BudgetService{DB: db}
+}
+
+// BudgetRole returns the role whose default budget applies to user.
+func BudgetRole(user *models.User) string {
+	if user != nil && user.IsAdmin {
+		return models.BudgetRoleAdmin
+	}
+	return models.BudgetRoleUser
+}
+
+// EffectiveBudget returns the user's own budget, falling back to their role default.
+// It returns nil when neither is configured.
+func (s *BudgetService) EffectiveBudget(user *models.User) (*models.Budget, error) {
+	var budget models.Budget
+	err := s.DB.Where("user_id = ?", user.ID).First(&budget).Error
+	if err == nil {
+		return &budget, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	err = s.DB.Where("role = ? AND user_id = 0", BudgetRole(user)).First(&budget).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+// Status reports the user's consumption in the current day and month (local time,
+// counted from the latest reset) against their effective budget.
+func (s *BudgetService) Status(user *models.User) (*BudgetStatus, error) {
+	budget, err := s.EffectiveBudget(user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	var reset models.BudgetReset
+	if err := s.DB.Where("user_id = ?", user.ID).Limit(1).Find(&reset).Error; err != nil {
+		return nil, err
+	}
+	if reset.DailyResetAt != nil && reset.DailyResetAt.After(dayStart) {
+		dayStart = *reset.DailyResetAt
+	}
+	if reset.MonthlyResetAt != nil && reset.MonthlyResetAt.After(monthStart) {
+		monthStart = *reset.MonthlyResetAt
+	}
+
+	status := &BudgetStatus{
+		UserID:  user.ID,
+		Budget:  budget,
+		Daily:   BudgetPeriodStatus{Period: BudgetPeriodDaily, Since: dayStart},
+		Monthly: BudgetPeriodStatus{Period: BudgetPeriodMonthly, Since: monthStart},
+	}
+	for _, p := range []*BudgetPeriodStatus{&status.Daily, &status.Monthly} {
+		if err := s.consumption(user.ID, p); err != nil {
+			return nil, err
+		}
+	}
+	if budget == nil {
+		return status, nil
+	}
+
+	status.Daily.TokenLimit, status.Daily.CostLimit = budget.DailyTokens, budget.DailyCost
+	status.Monthly.TokenLimit, status.Monthly.CostLimit = budget.MonthlyTokens, budget.MonthlyCost
+
+	warnAt := budget.WarnAt
+	if warnAt <= 0 || warnAt > 1 {
+		warnAt = 0.8
+	}
+	for _, p := range []BudgetPeriodStatus{status.Daily, status.Monthly} {
+		for _, check := range []struct {
+			unit        string
+			used, limit float64
+		}{
+			{"token", float64(p.Tokens), float64(p.TokenLimit)},
+			{"cost", p.Cost, p.CostLimit},
+		} {
+			if check.limit <= 0 {
+				continue
+			}
+			ratio := check.used / check.limit
+			switch {
+			case ratio >= 1:
+				status.Exceeded = true
+				status.Warnings = append(status.Warnings, fmt.Sprintf("%s %s budget exhausted", p.Period, check.unit))
+			case ratio >= warnAt:
+				status.Warnings = append(status.Warnings, fmt.Sprintf("%.0f%% of %s %s budget used", ratio*100, p.Period, check.unit))
+			}
+		}
+	}
+	return status, nil
+}
+
+// Check returns ErrBudgetExceeded, naming the exhausted limits, when user may not make
+// further model calls. Calls outside chats (embeddings, reranking, knowledge search)
+// use it before calling a model.
+func (s *BudgetService) Check(user *models.User) error {
+	status, err := s.Status(user)
+	if err != nil {
+		return err
+	}
+	if status.Exceeded {
+		return fmt.Errorf("%w: %s", ErrBudgetExceeded, strings.Join(status.Warnings, "; "))
+	}
+	return nil
+}
+
+func (s *BudgetService) consumption(userID uint, p *BudgetPeriodStatus) error {
+	var sums struct {
+		Tokens int64
+		Cost   float64
+	}
+	err := s.DB.Model(&models.TokenUsage{}).
+		Select("COALESCE(SUM(total_tokens), 0) AS tokens, COALESCE(SUM(cost), 0) AS cost").
+		Where("user_id = ? AND created_at >= ?", userID, p.Since).
+		Scan(&sums).Error
+	p.Tokens, p.Cost = sums.Tokens, sums.Cost
+	return err
+}
+
+// Reset restarts the user's daily and/or monthly consumption from now.
+// period is "daily", "monthly" or "" for both.
+func (s *BudgetService) Reset(userID uint, period string) error {
+	now := time.Now()
+	var reset models.BudgetReset
+	if err := s.DB.Where("user_id = ?", userID).Limit(1).Find(&reset).Error; err != nil {
+		return err
+	}
+	reset.UserID = userID
+	switch period {
+	case BudgetPeriodDaily:
+		reset.DailyResetAt = &now
+	case BudgetPeriodMonthly:
+		reset.MonthlyResetAt = &now
+	case "":
+		reset.DailyResetAt = &now
+		reset.MonthlyResetAt = &now
+	default:
+		return fmt.Errorf("unknown budget period %q", period)
+	}
+	return s.DB.Save(&reset).Error
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/models"
+)
+
+func setupBudgetService(t *testing.T) *BudgetService {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.User{}, &models.TokenUsage{}, &models.Budget{}, &models.BudgetReset{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return NewBudgetService(db)
+}
+
+func TestBudgetStatus_RoleDefaultAndOverride(t *testing.T) {
+	svc := setupBudgetService(t)
+	user := &models.User{ID: 5, Username: "alice"}
+
+	status, err := svc.Status(user)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if status.Budget != nil || status.Exceeded {
+		t.Fatalf("expected no budget to apply, got %+v", status)
+	}
+
+	svc.DB.Create(&models.Budget{Role: models.BudgetRoleUser, DailyTokens: 1000, WarnAt: 0.5})
+	svc.DB.Create(&models.TokenUsage{UserID: user.ID, TotalTokens: 600, CreatedAt: time.Now()})
+	// Yesterday's usage does not count against the daily limit.
+	svc.DB.Create(&models.TokenUsage{UserID: user.ID, TotalTokens: 5000, CreatedAt: time.Now().AddDate(0, 0, -1)})
+
+	status, err = svc.Status(user)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if status.Daily.Tokens != 600 || status.Exceeded {
+		t.Fatalf("expected 600 daily tokens and not exceeded, got %+v", status.Daily)
+	}
+	if len(status.Warnings) != 1 {
+		t.Fatalf("expected one threshold warning, got %v", status.Warnings)
+	}
+
+	// A per-user override replaces the role default.
+	svc.DB.Create(&models.Budget{UserID: user.ID, DailyTokens: 500, WarnAt: 0.8})
+	status, err = svc.Status(user)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if !status.Exceeded {
+		t.Fatalf("expected override limit to be exceeded, got %+v", status)
+	}
+	if err := svc.Check(user); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+}
+
+func TestBudgetReset(t *testing.T) {
+	svc := setupBudgetService(t)
+	user := &models.User{ID: 7, Username: "bob"}
+
+	svc.DB.Create(&models.Budget{UserID: user.ID, DailyTokens: 100, MonthlyTokens: 100})
+	svc.DB.Create(&models.TokenUsage{UserID: user.ID, TotalTokens: 150, CreatedAt: time.Now().Add(-time.Minute)})
+
+	status, _ := svc.Status(user)
+	if !status.Exceeded {
+		t.Fatalf("expected budget to be exceeded before reset")
+	}
+
+	if err := svc.Reset(user.ID, BudgetPeriodDaily); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	status, _ = svc.Status(user)
+	if status.Daily.Tokens != 0 || !status.Exceeded {
+		t.Fatalf("expected daily reset only, got daily=%+v monthly=%+v", status.Daily, status.Monthly)
+	}
+
+	if err := svc.Reset(user.ID, ""); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	status, _ = svc.Status(user)
+	if status.Exceeded || status.Monthly.Tokens != 0 {
+		t.Fatalf("expected both periods reset, got %+v", status)
+	}
+
+	if err := svc.Reset(user.ID, "weekly"); err == nil {
+		t.Fatalf("expected unknown period to fail")
+	}
+}
@@ -0,0 +1,251 @@
+// Package documents extracts plain text from chat attachments (PDF, DOCX, Markdown,
+// CSV and other text files) so that models without native document support can
+// still answer questions about them.
+package documents
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	MimePDF  = "application/pdf"
+	MimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+)
+
+var (
+	// ErrTooLarge is returned for attachments above Limits.MaxBytes.
+	ErrTooLarge = errors.New("attachment too large")
+	// ErrTooManyPages is returned for documents above Limits.MaxPages.
+	ErrTooManyPages = errors.New("document has too many pages")
+	// ErrUnsupported is returned for file types text cannot be extracted from.
+	ErrUnsupported = errors.New("unsupported attachment type")
+)
+
+// Limits bounds what is accepted and how much text is kept. Zero disables a limit.
+type Limits struct {
+	MaxBytes int64
+	MaxPages int
+	// MaxChars truncates the extracted text; the document itself is still accepted.
+	MaxChars int
+}
+
+// Document is the result of extracting an attachment.
+type Document struct {
+	Filename  string
+	Mime      string
+	Text      string
+	Pages     int
+	Truncated bool
+}
+
+// extensionMimes maps extensions whose declared type is often missing or generic.
+var extensionMimes = map[string]string{
+	".pdf":      MimePDF,
+	".docx":     MimeDOCX,
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".csv":      "text/csv",
+	".tsv":      "text/tab-separated-values",
+	".txt":      "text/plain",
+	".log":      "text/plain",
+	".json":     "application/json",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+	".xml":      "application/xml",
+	".html":     "text/html",
+	".htm":      "text/html",
+}
+
+// MimeByExtension returns the MIME type of a supported file from its extension alone.
+func MimeByExtension(filename string) (string, bool) {
+	mime, ok := extensionMimes[strings.ToLower(filepath.Ext(filename))]
+	return mime, ok
+}
+
+// DetectMime returns the MIME type of an attachment, preferring the file extension,
+// then a specific declared type, then content sniffing.
+func DetectMime(filename, declared string, data []byte) string {
+	if mime, ok := MimeByExtension(filename); ok {
+		return mime
+	}
+	declared = strings.TrimSpace(strings.Split(declared, ";")[0])
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	return strings.Split(http.DetectContentType(data), ";")[0]
+}
+
+// IsImage reports whether mime is an image type, which is sent to models as-is.
+func IsImage(mime string) bool {
+	return strings.HasPrefix(mime, "image/")
+}
+
+// IsText reports whether mime is a plain text format that needs no extraction.
+func IsText(mime string) bool {
+	if strings.HasPrefix(mime, "text/") {
+		return true
+	}
+	switch mime {
+	case "application/json", "application/yaml", "application/xml", "application/x-yaml":
+		return true
+	}
+	return false
+}
+
+// Supported reports whether Extract can handle mime.
+func Supported(mime string) bool {
+	return mime == MimePDF || mime == MimeDOCX || IsText(mime)
+}
+
+// Extract validates data against limits and returns its text content.
+func Extract(filename, mime string, data []byte, limits Limits) (*Document, error) {
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrTooLarge, filename, len(data), limits.MaxBytes)
+	}
+
+	doc := &Document{Filename: filename, Mime: mime}
+	var err error
+	switch {
+	case mime == MimePDF:
+		doc.Text, doc.Pages, err = extractPDF(data, limits.MaxPages)
+	case mime == MimeDOCX:
+		doc.Text, err = extractDOCX(data, limits.MaxChars)
+	case IsText(mime):
+		if !utf8.Valid(data) {
+			return nil, fmt.Errorf("%w: %s is not valid UTF-8 text", ErrUnsupported, filename)
+		}
+		doc.Text = string(data)
+	default:
+		return nil, fmt.Errorf("%w: %s (%s)", ErrUnsupported, filename, mime)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+
+	doc.Text = strings.TrimSpace(doc.Text)
+	if limits.MaxChars > 0 && utf8.RuneCountInString(doc.Text) > limits.MaxChars {
+		doc.Text = string([]rune(doc.Text)[:limits.MaxChars])
+		doc.Truncated = true
+	}
+	return doc, nil
+}
+
+func extractPDF(data []byte, maxPages int) (text string, pages int, err error) {
+	// The PDF parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", 0, err
+	}
+	pages = reader.NumPage()
+	if maxPages > 0 && pages > maxPages {
+		return "", pages, fmt.Errorf("%w: %d pages, limit is %d", ErrTooManyPages, pages, maxPages)
+	}
+
+	var b strings.Builder
+	for i := 1; i <= pages; i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		content, err := page.GetPlainText(nil)
+		if err != nil {
+			return "", pages, fmt.Errorf("page %d: %w", i, err)
+		}
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "--- Page %d ---\n%s\n", i, strings.TrimSpace(content))
+	}
+	return b.String(), pages, nil
+}
+
+// maxDOCXBodyBytes caps how much of word/document.xml is inflated, so a small
+// compressed attachment cannot expand without bound.
+const maxDOCXBodyBytes = 64 << 20
+
+// extractDOCX reads the paragraphs of word/document.xml, keeping tabs and line breaks.
+// It stops early once more than maxChars runes may have been read (maxChars > 0) or
+// maxDOCXBodyBytes have been inflated; Extract then truncates the text.
+func extractDOCX(data []byte, maxChars int) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	var body *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			body = f
+			break
+		}
+	}
+	if body == nil {
+		return "", errors.New("word/document.xml not found")
+	}
+	rc, err := body.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var b strings.Builder
+	lr := &io.LimitedReader{R: rc, N: maxDOCXBodyBytes}
+	dec := xml.NewDecoder(lr)
+	inText := false
+	for {
+		if maxChars > 0 && b.Len() > maxChars*utf8.UTFMax {
+			break
+		}
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if lr.N <= 0 {
+				break
+			}
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.WriteByte('\t')
+			case "br", "cr":
+				b.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				b.WriteByte('\n')
+			case "tc":
+				b.WriteByte('\t')
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+	return b.String(), nil
+}
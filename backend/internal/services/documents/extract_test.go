@@ -0,0 +1,79 @@
+package documents
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func makeDOCX(t *testing.T, body string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body + `</w:body></w:document>`))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectMime(t *testing.T) {
+	cases := []struct {
+		name, declared, want string
+	}{
+		{"report.PDF", "", MimePDF},
+		{"notes.md", "application/octet-stream", "text/markdown"},
+		{"data", "text/csv; charset=utf-8", "text/csv"},
+		{"unknown", "", "text/plain"},
+	}
+	for _, c := range cases {
+		if got := DetectMime(c.name, c.declared, []byte("hello")); got != c.want {
+			t.Errorf("DetectMime(%q, %q) = %q, want %q", c.name, c.declared, got, c.want)
+		}
+	}
+}
+
+func TestExtract_DOCX(t *testing.T) {
+	data := makeDOCX(t, `<w:p><w:r><w:t>Hello</w:t></w:r><w:r><w:tab/><w:t>world</w:t></w:r></w:p><w:p><w:r><w:t>Second</w:t></w:r></w:p>`)
+	doc, err := Extract("a.docx", MimeDOCX, data, Limits{})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if want := "Hello\tworld\nSecond"; doc.Text != want {
+		t.Errorf("Text = %q, want %q", doc.Text, want)
+	}
+}
+
+func TestExtract_DOCXStopsAtMaxChars(t *testing.T) {
+	body := strings.Repeat(`<w:p><w:r><w:t>0123456789</w:t></w:r></w:p>`, 1000)
+	doc, err := Extract("a.docx", MimeDOCX, makeDOCX(t, body), Limits{MaxChars: 20})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if want := "0123456789\n012345678"; doc.Text != want || !doc.Truncated {
+		t.Errorf("got %q truncated=%v, want %q truncated", doc.Text, doc.Truncated, want)
+	}
+}
+
+func TestExtract_Limits(t *testing.T) {
+	if _, err := Extract("big.txt", "text/plain", make([]byte, 11), Limits{MaxBytes: 10}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if _, err := Extract("a.bin", "application/zip", []byte("x"), Limits{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+
+	doc, err := Extract("a.csv", "text/csv", []byte("名前,値\nx,1\n"), Limits{MaxChars: 4})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if doc.Text != "名前,値" || !doc.Truncated {
+		t.Errorf("got %q truncated=%v, want rune-based truncation", doc.Text, doc.Truncated)
+	}
+}
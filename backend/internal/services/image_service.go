@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/llm"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoImageModel is returned when no enabled provider offers an image generation model.
+var ErrNoImageModel = errors.New("no image generation model is configured")
+
+// ImageService generates images with catalogue models that have the image generation
+// capability.
+type ImageService struct {
+	DB  *gorm.DB
+	LLM *llm.Service
+}
+
+func NewImageService(db *gorm.DB) *ImageService {
+	return &ImageService{DB: db, LLM: llm.NewService(db)}
+}
+
+// IsImageModel reports whether model generates images rather than chat text.
+func IsImageModel(model models.Model) bool {
+	if model.EndpointType == models.EndpointTypeImageGeneration {
+		return true
+	}
+	for _, c := range model.Capabilities {
+		if c == models.CapabilityImageGeneration {
+			return true
+		}
+	}
+	return false
+}
+
+// FindImageModel returns the catalogue entry of providerID/modelID when it is an image
+// generation model.
+func (s *ImageService) FindImageModel(providerID uint, modelID string) (*models.Model, bool) {
+	var model models.Model
+	if err := s.DB.Preload("Provider").Where("provider_id = ? AND model_id = ?", providerID, modelID).First(&model).Error; err != nil {
+		return nil, false
+	}
+	if !IsImageModel(model) || model.Provider == nil {
+		return nil, false
+	}
+	return &model, true
+}
+
+// DefaultModel returns the first image generation model of an enabled provider.
+func (s *ImageService) DefaultModel() (*models.Model, error) {
+	var candidates []models.Model
+	err := s.DB.Preload("Provider").
+		Joins("JOIN providers ON providers.id = models.provider_id").
+		Where("providers.enabled = ?", true).
+		Where("models.endpoint_type = ? OR models.capabilities LIKE ?", models.EndpointTypeImageGeneration, "%"+string(models.CapabilityImageGeneration)+"%").
+		Order("models.id asc").
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range candidates {
+		if IsImageModel(m) && m.Provider != nil {
+			return &m, nil
+		}
+	}
+	return nil, ErrNoImageModel
+}
+
+// Generate creates images for prompt with model. The call is billed to uc.UserID: their
+// budget is checked first and the images are recorded at the catalogue image price.
+func (s *ImageService) Generate(ctx context.Context, uc llm.UsageContext, model models.Model, prompt string, opts llm.ImageOptions) ([]llm.GeneratedImage, error) {
+	if strings.TrimSpace(prompt) == "" {
+		return nil, errors.New("prompt is required")
+	}
+	if model.Provider == nil {
+		return nil, fmt.Errorf("image model %s has no provider", model.ModelID)
+	}
+	if !model.Provider.Enabled {
+		return nil, fmt.Errorf("provider %s is disabled", model.Provider.ProviderID)
+	}
+	var user models.User
+	if err := s.DB.First(&user, uc.UserID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	if err := NewBudgetService(s.DB).Check(&user); err != nil {
+		return nil, err
+	}
+
+	images, err := s.LLM.GenerateImage(ctx, *model.Provider, model.ModelID, prompt, opts)
+	if err != nil {
+		return nil, err
+	}
+	uc.Target = llm.ChatTarget{Provider: *model.Provider, Model: model.ModelID}
+	if _, err := s.LLM.RecordImageUsage(uc, prompt, len(images)); err != nil {
+		log.Printf("Failed to record image generation usage: %v", err)
+	}
+	return images, nil
+}
@@ -0,0 +1,529 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/documents"
+	"fnchatbot/internal/services/llm"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// knowledgeMaxFileBytes skips larger files when indexing
+	knowledgeMaxFileBytes = 20 << 20
+	// knowledgeDefaultTopK is the number of hits returned when none is requested
+	knowledgeDefaultTopK = 5
+)
+
+// ErrKnowledgeIndexing is returned when a knowledge base is already being indexed.
+var ErrKnowledgeIndexing = errors.New("knowledge base is already being indexed")
+
+// knowledgeLocks serializes indexing per knowledge base across service instances.
+var knowledgeLocks sync.Map
+
+// knowledgePendingFull holds the knowledge bases whose full re-index was requested while
+// another run held the lock; that run starts it when it finishes.
+var knowledgePendingFull sync.Map
+
+// EmbedFunc computes one embedding per text with an embedding model.
+type EmbedFunc func(ctx context.Context, model models.Model, texts []string, inputType llm.InputType) ([][]float32, error)
+
+// KnowledgeService indexes sandbox directories into embedded chunks and searches them.
+type KnowledgeService struct {
+	DB      *gorm.DB
+	Sandbox *SandboxService
+	Embed   EmbedFunc
+}
+
+// KnowledgeHit is a chunk matching a search query.
+type KnowledgeHit struct {
+	KnowledgeBaseID uint    `json:"knowledge_base_id"`
+	KnowledgeBase   string  `json:"knowledge_base"`
+	Path            string  `json:"path"`
+	Seq             int     `json:"seq"`
+	Content         string  `json:"content"`
+	Score           float64 `json:"score"`
+}
+
+// IndexStats summarizes an indexing run.
+type IndexStats struct {
+	Indexed int `json:"indexed"`
+	Skipped int `json:"skipped"`
+	Removed int `json:"removed"`
+	Failed  int `json:"failed"`
+}
+
+func NewKnowledgeService(db *gorm.DB) *KnowledgeService {
+	llmService := llm.NewService(db)
+	return &KnowledgeService{
+		DB:      db,
+		Sandbox: NewSandboxService(db),
+		Embed: func(ctx context.Context, model models.Model, texts []string, inputType llm.InputType) ([][]float32, error) {
+			if model.Provider == nil {
+				return nil, fmt.Errorf("embedding model %s has no provider", model.ModelID)
+			}
+			return llmService.Embed(ctx, *model.Provider, model.ModelID, texts, inputType)
+		},
+	}
+}
+
+// EmbeddingModel loads a catalogue model with its provider and checks that it can embed.
+func (s *KnowledgeService) EmbeddingModel(modelID uint) (*models.Model, error) {
+	var model models.Model
+	if err := s.DB.Preload("Provider").First(&model, modelID).Error; err != nil {
+		return nil, fmt.Errorf("model %d not found", modelID)
+	}
+	for _, c := range model.Capabilities {
+		if c == models.CapabilityEmbedding {
+			return &model, nil
+		}
+	}
+	return nil, fmt.Errorf("model %s does not have the embedding capability", model.ModelID)
+}
+
+// ValidatePaths normalizes paths and checks that each is an existing directory inside
+// the sandbox allowed paths. Knowledge bases are restricted to those paths even when
+// the sandbox is disabled for commands.
+func (s *KnowledgeService) ValidatePaths(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("at least one directory is required")
+	}
+	allowed := s.Sandbox.GetAllowedPaths()
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		abs, err := s.Sandbox.normalizePath(p)
+		if err != nil || abs == "" {
+			return nil, fmt.Errorf("invalid path %q", p)
+		}
+		inside := false
+		for _, a := range allowed {
+			if s.Sandbox.isSubPath(a, abs) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return nil, fmt.Errorf("path %s is not inside a sandbox allowed path", abs)
+		}
+		info, err := os.Stat(abs)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("path %s is not a directory", abs)
+		}
+		out = append(out, abs)
+	}
+	return out, nil
+}
+
+// Index brings the chunks of a knowledge base up to date with its directories. Files
+// whose size and modification time are unchanged are skipped unless full is set. A full
+// run requested while another run is in progress is queued behind it; Index still
+// returns ErrKnowledgeIndexing then.
+func (s *KnowledgeService) Index(ctx context.Context, kbID uint, full bool) (*IndexStats, error) {
+	if full {
+		// Marked before trying the lock so a run that holds it sees the request when done
+		knowledgePendingFull.Store(kbID, true)
+	}
+	lock, _ := knowledgeLocks.LoadOrStore(kbID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return nil, ErrKnowledgeIndexing
+	}
+	if _, pending := knowledgePendingFull.LoadAndDelete(kbID); pending {
+		full = true
+	}
+	stats, err := s.indexLocked(ctx, kbID, full)
+	lock.(*sync.Mutex).Unlock()
+
+	if _, pending := knowledgePendingFull.LoadAndDelete(kbID); pending {
+		return s.Index(context.Background(), kbID, true)
+	}
+	return stats, err
+}
+
+func (s *KnowledgeService) indexLocked(ctx context.Context, kbID uint, full bool) (*IndexStats, error) {
+	var kb models.KnowledgeBase
+	if err := s.DB.First(&kb, kbID).Error; err != nil {
+		return nil, err
+	}
+	s.DB.Model(&kb).Updates(map[string]interface{}{"status": models.KnowledgeStatusIndexing, "last_error": ""})
+
+	stats, err := s.index(ctx, kb, full)
+	now := time.Now()
+	updates := map[string]interface{}{"status": models.KnowledgeStatusIdle, "indexed_at": &now}
+	if err != nil {
+		updates = map[string]interface{}{"status": models.KnowledgeStatusError, "last_error": err.Error()}
+	}
+	var fileCount, chunkCount int64
+	s.DB.Model(&models.KnowledgeFile{}).Where("knowledge_base_id = ?", kb.ID).Count(&fileCount)
+	s.DB.Model(&models.KnowledgeChunk{}).Where("knowledge_base_id = ?", kb.ID).Count(&chunkCount)
+	updates["file_count"] = fileCount
+	updates["chunk_count"] = chunkCount
+	s.DB.Model(&kb).Updates(updates)
+	return stats, err
+}
+
+func (s *KnowledgeService) index(ctx context.Context, kb models.KnowledgeBase, full bool) (*IndexStats, error) {
+	model, err := s.EmbeddingModel(kb.ModelID)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := s.ValidatePaths(kb.Paths)
+	if err != nil {
+		return nil, err
+	}
+	// Embeddings are billed to the owner, whose budget is checked before each file
+	var owner models.User
+	if err := s.DB.First(&owner, kb.UserID).Error; err != nil {
+		return nil, fmt.Errorf("owner of knowledge base %d not found", kb.ID)
+	}
+	budget := NewBudgetService(s.DB)
+
+	var known []models.KnowledgeFile
+	if err := s.DB.Where("knowledge_base_id = ?", kb.ID).Find(&known).Error; err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]models.KnowledgeFile, len(known))
+	for _, f := range known {
+		byPath[f.Path] = f
+	}
+
+	stats := &IndexStats{}
+	seen := make(map[string]bool)
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // unreadable entries are skipped
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if strings.HasPrefix(d.Name(), ".") && path != root {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			mime, ok := documents.MimeByExtension(path)
+			if !ok {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.Size() > knowledgeMaxFileBytes {
+				return nil
+			}
+			seen[path] = true
+
+			prev, exists := byPath[path]
+			if exists && !full && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime()) {
+				stats.Skipped++
+				return nil
+			}
+			if err := budget.Check(&owner); err != nil {
+				return err
+			}
+			if err := s.indexFile(ctx, kb, *model, prev, path, mime, info); err != nil {
+				var embedErr *embedError
+				if errors.As(err, &embedErr) {
+					return embedErr.err
+				}
+				stats.Failed++
+				return nil
+			}
+			stats.Indexed++
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	for path, f := range byPath {
+		if seen[path] {
+			continue
+		}
+		if err := s.removeFile(f); err != nil {
+			return stats, err
+		}
+		stats.Removed++
+	}
+	return stats, nil
+}
+
+// embedError marks embedding failures, which abort the run instead of skipping a file.
+type embedError struct{ err error }
+
+func (e *embedError) Error() string { return e.err.Error() }
+
+// indexFile replaces the chunks of one file. Extraction errors are stored on the file
+// record so the file is not retried until it changes.
+func (s *KnowledgeService) indexFile(ctx context.Context, kb models.KnowledgeBase, model models.Model, prev models.KnowledgeFile, path, mime string, info fs.FileInfo) error {
+	file := prev
+	file.KnowledgeBaseID = kb.ID
+	file.Path = path
+	file.Size = info.Size()
+	file.ModTime = info.ModTime()
+	file.IndexedAt = time.Now()
+	file.Error = ""
+
+	var chunks []string
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var doc *documents.Document
+		doc, err = documents.Extract(filepath.Base(path), mime, data, documents.Limits{MaxBytes: knowledgeMaxFileBytes})
+		if err == nil {
+			chunks = ChunkText(doc.Text, kb.ChunkSize, kb.ChunkOverlap)
+		}
+	}
+	if err != nil {
+		file.Error = err.Error()
+	}
+
+	var vectors [][]float32
+	if len(chunks) > 0 {
+		var embedErr error
+		vectors, embedErr = s.Embed(ctx, model, chunks, llm.InputTypeDocument)
+		if embedErr != nil {
+			return &embedError{fmt.Errorf("failed to embed %s: %w", path, embedErr)}
+		}
+		s.recordEmbeddingUsage(kb.UserID, model, chunks)
+	}
+	file.Chunks = len(chunks)
+
+	txErr := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&file).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("file_id = ?", file.ID).Delete(&models.KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		rows := make([]models.KnowledgeChunk, len(chunks))
+		for i, c := range chunks {
+			rows[i] = models.KnowledgeChunk{
+				KnowledgeBaseID: kb.ID,
+				FileID:          file.ID,
+				Seq:             i,
+				Content:         c,
+				Embedding:       encodeVector(vectors[i]),
+			}
+		}
+		return tx.CreateInBatches(rows, 100).Error
+	})
+	if txErr != nil {
+		return txErr
+	}
+	return err
+}
+
+func (s *KnowledgeService) removeFile(f models.KnowledgeFile) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id = ?", f.ID).Delete(&models.KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&f).Error
+	})
+}
+
+// Delete removes a knowledge base with its files and chunks.
+func (s *KnowledgeService) Delete(kbID uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("knowledge_base_id = ?", kbID).Delete(&models.KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("knowledge_base_id = ?", kbID).Delete(&models.KnowledgeFile{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.KnowledgeBase{}, kbID).Error
+	})
+}
+
+// recordEmbeddingUsage books embedded chunks or search queries on the user's usage.
+func (s *KnowledgeService) recordEmbeddingUsage(userID uint, model models.Model, texts []string) {
+	target := llm.ChatTarget{Model: model.ModelID}
+	if model.Provider != nil {
+		target.Provider = *model.Provider
+	}
+	uc := llm.UsageContext{UserID: userID, Target: target}
+	if _, err := llm.NewService(s.DB).RecordInputUsage(uc, texts); err != nil {
+		log.Printf("Failed to record knowledge embedding usage: %v", err)
+	}
+}
+
+// Search returns the chunks most similar to query from the user's knowledge bases,
+// optionally restricted to kbIDs. Each knowledge base is queried with its own
+// embedding model and scores are cosine similarities.
+func (s *KnowledgeService) Search(ctx context.Context, userID uint, query string, kbIDs []uint, topK int) ([]KnowledgeHit, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("query is required")
+	}
+	if topK <= 0 {
+		topK = knowledgeDefaultTopK
+	}
+
+	q := s.DB.Where("user_id = ?", userID)
+	if len(kbIDs) > 0 {
+		q = q.Where("id IN ?", kbIDs)
+	}
+	var kbs []models.KnowledgeBase
+	if err := q.Find(&kbs).Error; err != nil {
+		return nil, err
+	}
+
+	queryVectors := make(map[uint][]float32)
+	var hits []KnowledgeHit
+	for _, kb := range kbs {
+		vec, ok := queryVectors[kb.ModelID]
+		if !ok {
+			model, err := s.EmbeddingModel(kb.ModelID)
+			if err != nil {
+				return nil, err
+			}
+			vectors, err := s.Embed(ctx, *model, []string{query}, llm.InputTypeQuery)
+			if err != nil {
+				return nil, fmt.Errorf("failed to embed query: %w", err)
+			}
+			s.recordEmbeddingUsage(userID, *model, []string{query})
+			vec = vectors[0]
+			queryVectors[kb.ModelID] = vec
+		}
+
+		var rows []struct {
+			Seq       int
+			Content   string
+			Embedding []byte
+			Path      string
+		}
+		err := s.DB.Table("knowledge_chunks").
+			Select("knowledge_chunks.seq, knowledge_chunks.content, knowledge_chunks.embedding, knowledge_files.path").
+			Joins("JOIN knowledge_files ON knowledge_files.id = knowledge_chunks.file_id").
+			Where("knowledge_chunks.knowledge_base_id = ?", kb.ID).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			hits = append(hits, KnowledgeHit{
+				KnowledgeBaseID: kb.ID,
+				KnowledgeBase:   kb.Name,
+				Path:            r.Path,
+				Seq:             r.Seq,
+				Content:         r.Content,
+				Score:           cosineSimilarity(vec, decodeVector(r.Embedding)),
+			})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > topK {
+		hits = hits[:topK]
+	}
+	return hits, nil
+}
+
+// ChunkText splits text into pieces of about size characters, each overlapping the
+// previous one by overlap characters. Cuts prefer paragraph, line and sentence
+// boundaries in the second half of a chunk.
+func ChunkText(text string, size, overlap int) []string {
+	if size <= 0 {
+		size = 1000
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return nil
+	}
+
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := min(start+size, len(runes))
+		if end < len(runes) {
+			end = chunkBoundary(runes, start+size/2, end)
+		}
+		if chunk := strings.TrimSpace(string(runes[start:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end >= len(runes) {
+			break
+		}
+		start = max(end-overlap, start+1)
+	}
+	return chunks
+}
+
+// chunkBoundary returns the best cut position in runes[from:to]: after a blank line,
+// then a newline, then sentence-ending punctuation, then whitespace, else to.
+func chunkBoundary(runes []rune, from, to int) int {
+	best := [4]int{}
+	for i := to - 1; i > from; i-- {
+		r := runes[i]
+		switch {
+		case r == '\n' && runes[i-1] == '\n':
+			return i + 1
+		case r == '\n' && best[1] == 0:
+			best[1] = i + 1
+		case strings.ContainsRune(".!?。！？", r) && best[2] == 0:
+			best[2] = i + 1
+		case unicode.IsSpace(r) && best[3] == 0:
+			best[3] = i + 1
+		}
+	}
+	for _, b := range best[1:] {
+		if b > 0 {
+			return b
+		}
+	}
+	return to
+}
+
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/llm"
+)
+
+func TestChunkText(t *testing.T) {
+	text := strings.Repeat("word ", 50) + "\n\n" + strings.Repeat("next ", 50)
+	chunks := ChunkText(text, 300, 20)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d: %q", len(chunks), chunks)
+	}
+	if !strings.HasPrefix(chunks[0], "word") || strings.Contains(chunks[0], "next") {
+		t.Errorf("first chunk should end at the paragraph break, got %q", chunks[0])
+	}
+	if got := ChunkText("  ", 300, 20); got != nil {
+		t.Errorf("expected no chunks for blank text, got %q", got)
+	}
+}
+
+// keywordEmbed embeds texts as counts of a few keywords, enough to rank results.
+func keywordEmbed(_ context.Context, _ models.Model, texts []string, _ llm.InputType) ([][]float32, error) {
+	keywords := []string{"invoice", "holiday", "recipe"}
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, len(keywords))
+		for j, k := range keywords {
+			v[j] = float32(strings.Count(strings.ToLower(text), k))
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func TestKnowledgeService_IndexAndSearch(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Provider{}, &models.Model{}, &models.KnowledgeBase{}, &models.KnowledgeFile{}, &models.KnowledgeChunk{}, &models.TokenUsage{}, &models.User{}, &models.Budget{}, &models.BudgetReset{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Create(&models.User{ID: 2, Username: "owner"})
+	svc := NewKnowledgeService(db)
+	svc.Embed = keywordEmbed
+
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "bills.md"), []byte("The invoice for March is overdue."), 0o644)
+	os.WriteFile(filepath.Join(root, "trip.txt"), []byte("Holiday plans for the summer."), 0o644)
+	os.WriteFile(filepath.Join(root, "photo.jpg"), []byte("not indexed"), 0o644)
+
+	provider := models.Provider{ProviderID: "local", Name: "Local", Type: models.ProviderTypeOllama}
+	db.Create(&provider)
+	model := models.Model{ProviderID: provider.ID, ModelID: "embed", Name: "Embed", Capabilities: []models.ModelCapability{models.CapabilityEmbedding}}
+	db.Create(&model)
+	kb := models.KnowledgeBase{Name: "Docs", UserID: 2, ModelID: model.ID, Paths: []string{root}, ChunkSize: 1000}
+	db.Create(&kb)
+
+	// Directories outside the sandbox allowed paths are rejected.
+	if _, err := svc.Index(context.Background(), kb.ID, false); err == nil {
+		t.Fatal("expected indexing outside allowed paths to fail")
+	}
+	if err := svc.Sandbox.AddPath(root, ""); err != nil {
+		t.Fatalf("AddPath failed: %v", err)
+	}
+
+	stats, err := svc.Index(context.Background(), kb.ID, false)
+	if err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	if stats.Indexed != 2 {
+		t.Errorf("expected 2 indexed files, got %+v", stats)
+	}
+	// Indexing is billed to the owner.
+	var usage []models.TokenUsage
+	db.Where("user_id = ?", 2).Find(&usage)
+	if len(usage) != 2 {
+		t.Errorf("expected one usage record per indexed file, got %+v", usage)
+	}
+
+	hits, err := svc.Search(context.Background(), 2, "where is my invoice?", nil, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 1 || filepath.Base(hits[0].Path) != "bills.md" {
+		t.Fatalf("expected bills.md as top hit, got %+v", hits)
+	}
+	// The query embedding is billed to the user.
+	usage = nil
+	db.Where("user_id = ?", 2).Order("id desc").Find(&usage)
+	if len(usage) != 3 || usage[0].Model != "embed" || usage[0].TotalTokens == 0 {
+		t.Errorf("expected a usage record for the query, got %+v", usage)
+	}
+
+	// Unchanged files are skipped and deleted files are dropped from the index.
+	os.Remove(filepath.Join(root, "trip.txt"))
+	stats, err = svc.Index(context.Background(), kb.ID, false)
+	if err != nil {
+		t.Fatalf("re-index failed: %v", err)
+	}
+	if stats.Skipped != 1 || stats.Removed != 1 || stats.Indexed != 0 {
+		t.Errorf("unexpected re-index stats %+v", stats)
+	}
+	db.First(&kb, kb.ID)
+	if kb.Status != models.KnowledgeStatusIdle || kb.FileCount != 1 || kb.ChunkCount != 1 {
+		t.Errorf("unexpected knowledge base state %+v", kb)
+	}
+
+	// An exhausted budget stops indexing.
+	db.Create(&models.Budget{UserID: 2, DailyTokens: 1})
+	os.WriteFile(filepath.Join(root, "trip.txt"), []byte("Holiday plans."), 0o644)
+	if _, err := svc.Index(context.Background(), kb.ID, false); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got %v", err)
+	}
+
+	// Other users cannot search this knowledge base.
+	if hits, _ := svc.Search(context.Background(), 3, "invoice", nil, 5); len(hits) != 0 {
+		t.Errorf("expected no hits for another user, got %+v", hits)
+	}
+}
+
+func TestKnowledgeService_QueuesFullIndex(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Provider{}, &models.Model{}, &models.KnowledgeBase{}, &models.KnowledgeFile{}, &models.KnowledgeChunk{}, &models.TokenUsage{}, &models.User{}, &models.Budget{}, &models.BudgetReset{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Create(&models.User{ID: 2, Username: "owner"})
+	svc := NewKnowledgeService(db)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	calls := 0
+	svc.Embed = func(ctx context.Context, model models.Model, texts []string, inputType llm.InputType) ([][]float32, error) {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-release
+		}
+		return keywordEmbed(ctx, model, texts, inputType)
+	}
+
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "bills.md"), []byte("The invoice for March is overdue."), 0o644)
+	if err := svc.Sandbox.AddPath(root, ""); err != nil {
+		t.Fatalf("AddPath failed: %v", err)
+	}
+	provider := models.Provider{ProviderID: "local", Name: "Local", Type: models.ProviderTypeOllama}
+	db.Create(&provider)
+	model := models.Model{ProviderID: provider.ID, ModelID: "embed", Name: "Embed", Capabilities: []models.ModelCapability{models.CapabilityEmbedding}}
+	db.Create(&model)
+	kb := models.KnowledgeBase{Name: "Docs", UserID: 2, ModelID: model.ID, Paths: []string{root}, ChunkSize: 1000}
+	db.Create(&kb)
+
+	done := make(chan *IndexStats)
+	go func() {
+		stats, err := svc.Index(context.Background(), kb.ID, false)
+		if err != nil {
+			t.Errorf("Index failed: %v", err)
+		}
+		done <- stats
+	}()
+	<-started
+
+	// A full run requested meanwhile is queued and re-embeds the unchanged file.
+	if _, err := svc.Index(context.Background(), kb.ID, true); err != ErrKnowledgeIndexing {
+		t.Fatalf("expected ErrKnowledgeIndexing, got %v", err)
+	}
+	close(release)
+	stats := <-done
+	if stats == nil || stats.Indexed != 1 || calls != 2 {
+		t.Errorf("expected the queued full run to re-embed the file, got %+v after %d embed call(s)", stats, calls)
+	}
+}
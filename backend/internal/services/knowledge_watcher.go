@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"github.com/fsnotify/fsnotify"
+	"gorm.io/gorm"
+)
+
+const (
+	// knowledgeDebounce groups bursts of file events into one re-index
+	knowledgeDebounce = 5 * time.Second
+	// knowledgeRescanInterval re-indexes everything periodically in case events were lost
+	knowledgeRescanInterval = time.Hour
+)
+
+// DefaultKnowledgeWatcher is set by main; knowledge handlers call Sync on it when non-nil.
+var DefaultKnowledgeWatcher *KnowledgeWatcher
+
+// KnowledgeWatcher re-indexes knowledge bases when files in their directories change.
+type KnowledgeWatcher struct {
+	svc     *KnowledgeService
+	watcher *fsnotify.Watcher
+
+	mu     sync.Mutex
+	dirs   map[string][]uint // watched directory -> knowledge bases containing it
+	roots  map[uint][]string
+	timers map[uint]*time.Timer
+	done   chan struct{}
+}
+
+// StartKnowledgeWatcher watches all knowledge base directories and runs an initial
+// incremental index to catch changes made while the server was down.
+func StartKnowledgeWatcher(db *gorm.DB) (*KnowledgeWatcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &KnowledgeWatcher{
+		svc:     NewKnowledgeService(db),
+		watcher: fw,
+		dirs:    make(map[string][]uint),
+		roots:   make(map[uint][]string),
+		timers:  make(map[uint]*time.Timer),
+		done:    make(chan struct{}),
+	}
+	w.Sync()
+	go w.loop()
+	go w.reindexAll()
+	return w, nil
+}
+
+// Sync rebuilds the watch list from the knowledge bases in the database.
+func (w *KnowledgeWatcher) Sync() {
+	var kbs []models.KnowledgeBase
+	if err := w.svc.DB.Find(&kbs).Error; err != nil {
+		log.Printf("Knowledge watcher: failed to load knowledge bases: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dir := range w.dirs {
+		_ = w.watcher.Remove(dir)
+	}
+	w.dirs = make(map[string][]uint)
+	w.roots = make(map[uint][]string)
+	for _, kb := range kbs {
+		w.roots[kb.ID] = kb.Paths
+		for _, root := range kb.Paths {
+			w.addTreeLocked(root, kb.ID)
+		}
+	}
+}
+
+// addTreeLocked watches dir and its non-hidden subdirectories for kbID.
+func (w *KnowledgeWatcher) addTreeLocked(dir string, kbID uint) {
+	_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			return filepath.SkipDir
+		}
+		if _, ok := w.dirs[path]; !ok {
+			if err := w.watcher.Add(path); err != nil {
+				log.Printf("Knowledge watcher: cannot watch %s: %v", path, err)
+				return filepath.SkipDir
+			}
+		}
+		for _, id := range w.dirs[path] {
+			if id == kbID {
+				return nil
+			}
+		}
+		w.dirs[path] = append(w.dirs[path], kbID)
+		return nil
+	})
+}
+
+func (w *KnowledgeWatcher) loop() {
+	ticker := time.NewTicker(knowledgeRescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Knowledge watcher: %v", err)
+		case <-ticker.C:
+			go w.reindexAll()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *KnowledgeWatcher) handle(event fsnotify.Event) {
+	if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := w.dirs[filepath.Dir(event.Name)]
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			for _, id := range ids {
+				w.addTreeLocked(event.Name, id)
+			}
+		}
+	}
+	for _, id := range ids {
+		w.scheduleLocked(id)
+	}
+}
+
+// scheduleLocked (re)starts the debounce timer of a knowledge base.
+func (w *KnowledgeWatcher) scheduleLocked(kbID uint) {
+	if t, ok := w.timers[kbID]; ok {
+		t.Stop()
+	}
+	w.timers[kbID] = time.AfterFunc(knowledgeDebounce, func() {
+		w.mu.Lock()
+		delete(w.timers, kbID)
+		w.mu.Unlock()
+		w.reindex(kbID)
+	})
+}
+
+func (w *KnowledgeWatcher) reindex(kbID uint) {
+	stats, err := w.svc.Index(context.Background(), kbID, false)
+	if errors.Is(err, ErrKnowledgeIndexing) {
+		// A run is in progress and may have missed this change; try again later
+		w.mu.Lock()
+		w.scheduleLocked(kbID)
+		w.mu.Unlock()
+		return
+	}
+	if err != nil {
+		log.Printf("Knowledge base %d: indexing failed: %v", kbID, err)
+		return
+	}
+	if stats.Indexed+stats.Removed+stats.Failed > 0 {
+		log.Printf("Knowledge base %d: indexed %d, removed %d, failed %d file(s)", kbID, stats.Indexed, stats.Removed, stats.Failed)
+	}
+}
+
+func (w *KnowledgeWatcher) reindexAll() {
+	w.mu.Lock()
+	ids := make([]uint, 0, len(w.roots))
+	for id := range w.roots {
+		ids = append(ids, id)
+	}
+	w.mu.Unlock()
+	for _, id := range ids {
+		w.reindex(id)
+	}
+}
+
+// Close stops watching.
+func (w *KnowledgeWatcher) Close() {
+	close(w.done)
+	w.mu.Lock()
+	for _, t := range w.timers {
+		t.Stop()
+	}
+	w.mu.Unlock()
+	_ = w.watcher.Close()
+}
@@ -0,0 +1,285 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"fnchatbot/internal/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ContextStrategy decides what happens to history that no longer fits the model window.
+type ContextStrategy string
+
+const (
+	// ContextStrategyTruncate drops the oldest turns.
+	ContextStrategyTruncate ContextStrategy = "truncate"
+	// ContextStrategySummarize replaces the oldest turns with a rolling summary.
+	ContextStrategySummarize ContextStrategy = "summarize"
+	// ContextStrategyNone sends the full history unchanged.
+	ContextStrategyNone ContextStrategy = "none"
+)
+
+// ValidContextStrategy reports whether s is a known strategy ("" selects the default).
+func ValidContextStrategy(s string) bool {
+	switch ContextStrategy(s) {
+	case "", ContextStrategyTruncate, ContextStrategySummarize, ContextStrategyNone:
+		return true
+	}
+	return false
+}
+
+// messageOverhead approximates the per-message role/formatting tokens chat APIs add.
+const messageOverhead = 4
+
+// knownContextWindows maps model ID prefixes to their context size in tokens. Longer
+// prefixes are listed before shorter ones they share a stem with.
+var knownContextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-5", 400000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"gpt-oss", 131072},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+	{"gemini", 1048576},
+	{"deepseek", 128000},
+	{"grok-4", 256000},
+	{"grok-3", 131072},
+	{"mistral-large", 128000},
+	{"mistral-small", 128000},
+	{"open-mistral-nemo", 128000},
+	{"codestral", 256000},
+	{"ministral", 128000},
+	{"pixtral", 128000},
+	{"glm-4.6", 200000},
+	{"glm-4.5", 128000},
+	{"kimi-k2", 256000},
+	{"moonshot-v1-auto", 128000},
+	{"llama3-", 8192},
+	{"sonar", 127000},
+}
+
+// KnownContextWindow returns the context size of a well-known model, or 0 if the model
+// is not in the table.
+func KnownContextWindow(model string) int {
+	id := strings.ToLower(model)
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		id = id[i+1:]
+	}
+	for _, k := range knownContextWindows {
+		if strings.HasPrefix(id, k.prefix) {
+			return k.tokens
+		}
+	}
+	return 0
+}
+
+// ContextWindow returns the context size, in tokens, for a model config talking to target:
+// the config's explicit window, else the catalogue's context_length, else the table of
+// known models. It returns 0 when the window is unknown and history should not be cut.
+func (s *Service) ContextWindow(cfg models.ModelConfig, target ChatTarget) int {
+	if cfg.ContextWindow > 0 {
+		return cfg.ContextWindow
+	}
+	var model models.Model
+	if err := s.DB.Where("provider_id = ? AND model_id = ?", target.Provider.ID, target.Model).First(&model).Error; err == nil && model.ContextLength > 0 {
+		return model.ContextLength
+	}
+	return KnownContextWindow(target.Model)
+}
+
+// InputBudget is the share of the window available for the prompt once the config's
+// completion allowance is reserved. At least half the window is always kept for input.
+func InputBudget(window, maxOutput int) int {
+	budget := window - maxOutput
+	if budget < window/2 {
+		budget = window / 2
+	}
+	return budget
+}
+
+// CountMessageTokens estimates the prompt tokens of messages.
+func CountMessageTokens(model string, messages []llms.MessageContent) int {
+	total := 0
+	for _, m := range messages {
+		total += countMessage(model, m)
+	}
+	return total
+}
+
+func countMessage(model string, m llms.MessageContent) int {
+	n := messageOverhead
+	for _, part := range m.Parts {
+		switch p := part.(type) {
+		case llms.TextContent:
+			n += countTokens(model, p.Text)
+		case llms.ImageURLContent, llms.BinaryContent:
+			n += imageTokens
+		case llms.ToolCall:
+			if p.FunctionCall != nil {
+				n += countTokens(model, p.FunctionCall.Name) + countTokens(model, p.FunctionCall.Arguments)
+			}
+		case llms.ToolCallResponse:
+			n += countTokens(model, p.Content)
+		}
+	}
+	return n
+}
+
+// FitResult is the outcome of FitContext.
+type FitResult struct {
+	Messages []llms.MessageContent
+	// Dropped are the oldest history messages removed ahead of the latest user turn, in
+	// order. They form a prefix of the non-system history and can be summarized.
+	Dropped []llms.MessageContent
+	// Trimmed counts tool exchanges removed from inside the latest turn as a last resort.
+	Trimmed int
+	Tokens  int
+}
+
+// FitContext drops the oldest turns until messages fit within budget tokens.
+//
+// Leading system messages and the latest user message are always kept. History is
+// removed in units so an assistant tool call is never separated from its tool results,
+// and the kept history always starts at a user message. If the latest turn alone is
+// still too large, its oldest tool exchanges are trimmed, keeping the most recent one.
+func FitContext(model string, messages []llms.MessageContent, budget int) FitResult {
+	sizes := make([]int, len(messages))
+	total := 0
+	for i, m := range messages {
+		sizes[i] = countMessage(model, m)
+		total += sizes[i]
+	}
+	if total <= budget {
+		return FitResult{Messages: messages, Tokens: total}
+	}
+
+	start := 0
+	for start < len(messages) && messages[start].Role == llms.ChatMessageTypeSystem {
+		start++
+	}
+	lastHuman := -1
+	for i := len(messages) - 1; i >= start; i-- {
+		if messages[i].Role == llms.ChatMessageTypeHuman {
+			lastHuman = i
+			break
+		}
+	}
+	if lastHuman < 0 {
+		return FitResult{Messages: messages, Tokens: total}
+	}
+
+	// Drop whole units ahead of the latest user turn, then keep dropping until the kept
+	// history begins with a user message (Anthropic and Gemini reject anything else).
+	cut := start
+	for cut < lastHuman && (total > budget || messages[cut].Role != llms.ChatMessageTypeHuman) {
+		end := unitEnd(messages, cut)
+		if end > lastHuman {
+			end = lastHuman
+		}
+		for i := cut; i < end; i++ {
+			total -= sizes[i]
+		}
+		cut = end
+	}
+
+	result := FitResult{
+		Dropped: append([]llms.MessageContent(nil), messages[start:cut]...),
+	}
+	kept := append([]llms.MessageContent(nil), messages[:start]...)
+	kept = append(kept, messages[cut:lastHuman+1]...)
+
+	// Last resort: trim the oldest tool exchanges inside the latest turn.
+	tail := messages[lastHuman+1:]
+	tailSizes := sizes[lastHuman+1:]
+	for total > budget && len(tail) > 0 {
+		end := unitEnd(tail, 0)
+		if end >= len(tail) {
+			break
+		}
+		for i := 0; i < end; i++ {
+			total -= tailSizes[i]
+		}
+		tail, tailSizes = tail[end:], tailSizes[end:]
+		result.Trimmed++
+	}
+	result.Messages = append(kept, tail...)
+	result.Tokens = total
+	return result
+}
+
+// unitEnd returns the index just past the unit starting at i: an assistant message
+// with tool calls together with the tool results that answer it, or a single message.
+func unitEnd(messages []llms.MessageContent, i int) int {
+	end := i + 1
+	if messages[i].Role == llms.ChatMessageTypeAI && hasToolCall(messages[i]) {
+		for end < len(messages) && messages[end].Role == llms.ChatMessageTypeTool {
+			end++
+		}
+	}
+	return end
+}
+
+func hasToolCall(m llms.MessageContent) bool {
+	for _, p := range m.Parts {
+		if _, ok := p.(llms.ToolCall); ok {
+			return true
+		}
+	}
+	return false
+}
+
+const summaryPrompt = `You maintain a running summary of an earlier part of a conversation that no longer fits in the model's context.
+Merge the previous summary (if any) with the new messages below into one concise summary.
+Keep facts, decisions, user preferences, file paths, tool results and open tasks. Write in the conversation's language. Reply with the summary only.`
+
+// Summarize condenses dropped history, folding in the previous rolling summary.
+func (s *Service) Summarize(ctx context.Context, target ChatTarget, previous string, dropped []llms.MessageContent) (string, *llms.ContentResponse, error) {
+	var b strings.Builder
+	if previous != "" {
+		b.WriteString("Previous summary:\n")
+		b.WriteString(previous)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("New messages:\n")
+	for _, m := range dropped {
+		for _, part := range m.Parts {
+			switch p := part.(type) {
+			case llms.TextContent:
+				fmt.Fprintf(&b, "[%s] %s\n", m.Role, p.Text)
+			case llms.ToolCall:
+				if p.FunctionCall != nil {
+					fmt.Fprintf(&b, "[tool call] %s(%s)\n", p.FunctionCall.Name, p.FunctionCall.Arguments)
+				}
+			case llms.ToolCallResponse:
+				fmt.Fprintf(&b, "[tool result] %s\n", p.Content)
+			case llms.ImageURLContent, llms.BinaryContent:
+				fmt.Fprintf(&b, "[%s] <image>\n", m.Role)
+			}
+		}
+	}
+
+	resp, _, err := s.StreamChatWithFallback(ctx, []ChatTarget{target}, DefaultRetryPolicy, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, summaryPrompt),
+		llms.TextParts(llms.ChatMessageTypeHuman, b.String()),
+	}, nil, StreamHandlers{}, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Content) == "" {
+		return "", resp, errors.New("empty summary")
+	}
+	return strings.TrimSpace(resp.Choices[0].Content), resp, nil
+}
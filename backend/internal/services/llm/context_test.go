@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+// useWordCounter makes every word one token so budgets are easy to reason about.
+func useWordCounter(t *testing.T) {
+	prev := countTokens
+	countTokens = func(_, text string) int {
+		n := 0
+		inWord := false
+		for _, r := range text {
+			if r == ' ' {
+				inWord = false
+			} else if !inWord {
+				inWord = true
+				n++
+			}
+		}
+		return n
+	}
+	t.Cleanup(func() { countTokens = prev })
+}
+
+func toolCallMsg(id string) llms.MessageContent {
+	return llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{
+		llms.ToolCall{ID: id, Type: "function", FunctionCall: &llms.FunctionCall{Name: "Read", Arguments: "{}"}},
+	}}
+}
+
+func toolResultMsg(id, content string) llms.MessageContent {
+	return llms.MessageContent{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{
+		llms.ToolCallResponse{ToolCallID: id, Content: content},
+	}}
+}
+
+func TestFitContext_UnderBudget(t *testing.T) {
+	useWordCounter(t)
+	msgs := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, "hello there"),
+		llms.TextParts(llms.ChatMessageTypeAI, "hi"),
+	}
+	fit := FitContext("m", msgs, 1000)
+	assert.Equal(t, msgs, fit.Messages)
+	assert.Empty(t, fit.Dropped)
+}
+
+func TestFitContext_DropsOldestKeepingToolPairs(t *testing.T) {
+	useWordCounter(t)
+	long := "one two three four five six seven eight nine ten"
+	msgs := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, "summary"),
+		llms.TextParts(llms.ChatMessageTypeHuman, long),
+		toolCallMsg("a"),
+		toolResultMsg("a", long),
+		llms.TextParts(llms.ChatMessageTypeAI, long),
+		llms.TextParts(llms.ChatMessageTypeHuman, "second question"),
+		llms.TextParts(llms.ChatMessageTypeAI, "short"),
+		llms.TextParts(llms.ChatMessageTypeHuman, "latest"),
+	}
+
+	fit := FitContext("m", msgs, 40)
+	// System message stays pinned; the whole first turn, including the tool pair, goes.
+	assert.Equal(t, llms.ChatMessageTypeSystem, fit.Messages[0].Role)
+	assert.Equal(t, llms.ChatMessageTypeHuman, fit.Messages[1].Role)
+	assert.Equal(t, msgs[5:], fit.Messages[1:])
+	assert.Equal(t, msgs[1:5], fit.Dropped)
+	assert.LessOrEqual(t, fit.Tokens, 40)
+}
+
+func TestFitContext_TrimsToolExchangesInLatestTurn(t *testing.T) {
+	useWordCounter(t)
+	long := "one two three four five six seven eight nine ten"
+	msgs := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, "do it"),
+		toolCallMsg("a"),
+		toolResultMsg("a", long),
+		toolCallMsg("b"),
+		toolResultMsg("b", long),
+	}
+
+	fit := FitContext("m", msgs, 30)
+	assert.Empty(t, fit.Dropped)
+	assert.Equal(t, 1, fit.Trimmed)
+	assert.Equal(t, []llms.MessageContent{msgs[0], msgs[3], msgs[4]}, fit.Messages)
+}
+
+func TestInputBudget(t *testing.T) {
+	assert.Equal(t, 6000, InputBudget(8000, 2000))
+	assert.Equal(t, 4000, InputBudget(8000, 7000))
+}
+
+func TestContextWindow(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Provider{}, &models.Model{}))
+
+	provider := models.Provider{ProviderID: "p", Name: "P", Type: models.ProviderTypeOpenAI, BaseURL: "http://x"}
+	assert.NoError(t, db.Create(&provider).Error)
+	// MaxTokens is the completion cap, not the window, and must not be used as one.
+	assert.NoError(t, db.Create(&models.Model{ProviderID: provider.ID, ModelID: "custom", Name: "Custom", MaxTokens: 4096}).Error)
+	assert.NoError(t, db.Create(&models.Model{ProviderID: provider.ID, ModelID: "sized", Name: "Sized", ContextLength: 32000}).Error)
+
+	svc := NewService(db)
+	window := func(cfg models.ModelConfig, model string) int {
+		return svc.ContextWindow(cfg, ChatTarget{Provider: provider, Model: model})
+	}
+	assert.Equal(t, 9000, window(models.ModelConfig{ContextWindow: 9000}, "sized"))
+	assert.Equal(t, 32000, window(models.ModelConfig{}, "sized"))
+	assert.Equal(t, 128000, window(models.ModelConfig{}, "gpt-4o-mini"))
+	assert.Equal(t, 200000, window(models.ModelConfig{}, "anthropic/claude-sonnet-4-5"))
+	assert.Zero(t, window(models.ModelConfig{}, "custom"))
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"strings"
+
+	"fnchatbot/internal/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// nativeDocumentMimes lists the document types each provider client can send as-is.
+// langchaingo turns BinaryContent into image blocks for the other providers, so they
+// only get the extracted text.
+var nativeDocumentMimes = map[models.ProviderType][]string{
+	models.ProviderTypeGemini: {"application/pdf"},
+}
+
+// SupportsNativeDocument reports whether the provider reads documents of type mime.
+func SupportsNativeDocument(providerType models.ProviderType, mime string) bool {
+	for _, m := range nativeDocumentMimes[providerType] {
+		if m == mime {
+			return true
+		}
+	}
+	return false
+}
+
+// adaptDocuments picks one representation for each document in messages. History
+// emits a document as BinaryContent directly followed by its extracted text: the
+// binary part is kept for providers with native support and the text is dropped,
+// otherwise the binary part is dropped.
+func adaptDocuments(providerType models.ProviderType, messages []llms.MessageContent) []llms.MessageContent {
+	var out []llms.MessageContent
+	for i, msg := range messages {
+		var parts []llms.ContentPart
+		changed := false
+		for j := 0; j < len(msg.Parts); j++ {
+			bin, ok := msg.Parts[j].(llms.BinaryContent)
+			if !ok || strings.HasPrefix(bin.MIMEType, "image/") {
+				parts = append(parts, msg.Parts[j])
+				continue
+			}
+			changed = true
+			if SupportsNativeDocument(providerType, bin.MIMEType) {
+				parts = append(parts, bin)
+				if j+1 < len(msg.Parts) {
+					if _, isText := msg.Parts[j+1].(llms.TextContent); isText {
+						j++
+					}
+				}
+			}
+		}
+		if !changed {
+			if out != nil {
+				out = append(out, msg)
+			}
+			continue
+		}
+		if out == nil {
+			out = append(make([]llms.MessageContent, 0, len(messages)), messages[:i]...)
+		}
+		out = append(out, llms.MessageContent{Role: msg.Role, Parts: parts})
+	}
+	if out == nil {
+		return messages
+	}
+	return out
+}
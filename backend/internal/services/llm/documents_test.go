@@ -0,0 +1,30 @@
+package llm
+
+import (
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestAdaptDocuments(t *testing.T) {
+	pdf := llms.BinaryPart("application/pdf", []byte("%PDF"))
+	messages := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, "sys"),
+		{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{
+			llms.TextPart("summarize"), pdf, llms.TextPart("[Attachment: a.pdf]..."),
+		}},
+	}
+
+	native := adaptDocuments(models.ProviderTypeGemini, messages)
+	assert.Equal(t, []llms.ContentPart{llms.TextPart("summarize"), pdf}, native[1].Parts)
+
+	text := adaptDocuments(models.ProviderTypeOpenAI, messages)
+	assert.Equal(t, []llms.ContentPart{llms.TextPart("summarize"), llms.TextPart("[Attachment: a.pdf]...")}, text[1].Parts)
+	assert.Equal(t, messages[0], text[0])
+
+	// The input is left untouched for fallback targets.
+	assert.Len(t, messages[1].Parts, 3)
+}
@@ -0,0 +1,253 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"fnchatbot/internal/models"
+)
+
+// APIFormat is the wire format used for embedding and rerank requests.
+type APIFormat string
+
+const (
+	FormatOpenAI APIFormat = "openai"
+	FormatOllama APIFormat = "ollama"
+	FormatGemini APIFormat = "gemini"
+	FormatJina   APIFormat = "jina"
+	FormatVoyage APIFormat = "voyage"
+)
+
+// InputType tells retrieval-tuned embedding models whether texts are search queries
+// or documents. Providers without the distinction ignore it.
+type InputType string
+
+const (
+	InputTypeNone     InputType = ""
+	InputTypeQuery    InputType = "query"
+	InputTypeDocument InputType = "document"
+)
+
+const (
+	// embedBatchSize is the number of texts sent per embedding request
+	embedBatchSize = 64
+	// embedCacheSize is the number of vectors kept in the process-wide cache
+	embedCacheSize = 10000
+)
+
+// FormatFor picks the API format of a provider. Jina and Voyage are configured as
+// OpenAI-compatible providers and are recognized by ID or host.
+func FormatFor(provider models.Provider) APIFormat {
+	switch provider.Type {
+	case models.ProviderTypeOllama:
+		return FormatOllama
+	case models.ProviderTypeGemini:
+		return FormatGemini
+	}
+	host := ""
+	if u, err := url.Parse(provider.BaseURL); err == nil {
+		host = u.Host
+	}
+	switch {
+	case provider.ProviderID == "jina" || strings.HasSuffix(host, "jina.ai"):
+		return FormatJina
+	case provider.ProviderID == "voyageai" || strings.HasSuffix(host, "voyageai.com"):
+		return FormatVoyage
+	}
+	return FormatOpenAI
+}
+
+// embeddingCache is an LRU of vectors keyed by provider, model, input type and text.
+type embeddingCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	vector []float32
+}
+
+var embedCache = newEmbeddingCache(embedCacheSize)
+
+func newEmbeddingCache(size int) *embeddingCache {
+	return &embeddingCache{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func embedCacheKey(provider models.Provider, model string, inputType InputType, text string) string {
+	sum := sha256.Sum256([]byte(provider.ProviderID + "\x00" + model + "\x00" + string(inputType) + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *embeddingCache) get(key string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).vector, true
+}
+
+func (c *embeddingCache) put(key string, vector []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, vector: vector})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Embed returns one embedding vector per text. Texts are sent in batches and vectors
+// are cached in memory, so repeated texts (e.g. unchanged chunks) cost nothing.
+func (s *Service) Embed(ctx context.Context, provider models.Provider, modelName string, texts []string, inputType InputType) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	keys := make([]string, len(texts))
+	var missing []int
+	for i, text := range texts {
+		keys[i] = embedCacheKey(provider, modelName, inputType, text)
+		if v, ok := embedCache.get(keys[i]); ok {
+			vectors[i] = v
+			continue
+		}
+		missing = append(missing, i)
+	}
+
+	for start := 0; start < len(missing); start += embedBatchSize {
+		batch := missing[start:min(start+embedBatchSize, len(missing))]
+		inputs := make([]string, len(batch))
+		for j, i := range batch {
+			inputs[j] = texts[i]
+		}
+		out, err := s.embedBatch(ctx, provider, modelName, inputs, inputType)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) != len(inputs) {
+			return nil, fmt.Errorf("embedding model %s returned %d vectors for %d inputs", modelName, len(out), len(inputs))
+		}
+		for j, i := range batch {
+			vectors[i] = out[j]
+			embedCache.put(keys[i], out[j])
+		}
+	}
+	return vectors, nil
+}
+
+func (s *Service) embedBatch(ctx context.Context, provider models.Provider, modelName string, texts []string, inputType InputType) ([][]float32, error) {
+	switch FormatFor(provider) {
+	case FormatOllama:
+		var resp struct {
+			Embeddings [][]float32 `json:"embeddings"`
+		}
+		err := s.doProviderJSON(ctx, provider, func(ctx context.Context, p models.Provider) (*http.Request, error) {
+			base := strings.TrimRight(p.BaseURL, "/")
+			if base == "" {
+				base = "http://localhost:11434"
+			}
+			return newJSONRequest(ctx, base+"/api/embed", map[string]any{"model": modelName, "input": texts})
+		}, &resp)
+		return resp.Embeddings, err
+
+	case FormatGemini:
+		model := modelName
+		if !strings.HasPrefix(model, "models/") {
+			model = "models/" + model
+		}
+		taskType := ""
+		switch inputType {
+		case InputTypeQuery:
+			taskType = "RETRIEVAL_QUERY"
+		case InputTypeDocument:
+			taskType = "RETRIEVAL_DOCUMENT"
+		}
+		requests := make([]map[string]any, len(texts))
+		for i, text := range texts {
+			r := map[string]any{"model": model, "content": map[string]any{"parts": []map[string]string{{"text": text}}}}
+			if taskType != "" {
+				r["taskType"] = taskType
+			}
+			requests[i] = r
+		}
+		var resp struct {
+			Embeddings []struct {
+				Values []float32 `json:"values"`
+			} `json:"embeddings"`
+		}
+		err := s.doProviderJSON(ctx, provider, func(ctx context.Context, p models.Provider) (*http.Request, error) {
+			base := strings.TrimRight(p.BaseURL, "/")
+			if base == "" {
+				base = "https://generativelanguage.googleapis.com"
+			}
+			req, err := newJSONRequest(ctx, base+"/v1beta/"+model+":batchEmbedContents", map[string]any{"requests": requests})
+			if err == nil {
+				req.Header.Set("x-goog-api-key", p.APIKey)
+			}
+			return req, err
+		}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		out := make([][]float32, len(resp.Embeddings))
+		for i, e := range resp.Embeddings {
+			out[i] = e.Values
+		}
+		return out, nil
+
+	default:
+		// OpenAI, Jina and Voyage share the /embeddings request and response shape
+		payload := map[string]any{"model": modelName, "input": texts}
+		switch FormatFor(provider) {
+		case FormatJina:
+			switch inputType {
+			case InputTypeQuery:
+				payload["task"] = "retrieval.query"
+			case InputTypeDocument:
+				payload["task"] = "retrieval.passage"
+			}
+		case FormatVoyage:
+			if inputType != InputTypeNone {
+				payload["input_type"] = string(inputType)
+			}
+		}
+		var resp struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		err := s.doProviderJSON(ctx, provider, func(ctx context.Context, p models.Provider) (*http.Request, error) {
+			req, err := newJSONRequest(ctx, apiURL(p.BaseURL, "/embeddings"), payload)
+			if err == nil {
+				setBearer(req, p.APIKey)
+			}
+			return req, err
+		}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(resp.Data, func(i, j int) bool { return resp.Data[i].Index < resp.Data[j].Index })
+		out := make([][]float32, len(resp.Data))
+		for i, d := range resp.Data {
+			out[i] = d.Embedding
+		}
+		return out, nil
+	}
+}
@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIURL(t *testing.T) {
+	assert.Equal(t, "https://api.jina.ai/v1/rerank", apiURL("https://api.jina.ai", "/rerank"))
+	assert.Equal(t, "https://openrouter.ai/api/v1/embeddings", apiURL("https://openrouter.ai/api/v1/", "/embeddings"))
+	assert.Equal(t, "https://open.bigmodel.cn/api/paas/v4/embeddings", apiURL("https://open.bigmodel.cn/api/paas/v4/", "/embeddings"))
+}
+
+func TestEmbed_BatchesAndCaches(t *testing.T) {
+	var requests [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		var body struct {
+			Input     []string `json:"input"`
+			InputType string   `json:"input_type"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, "document", body.InputType)
+		requests = append(requests, body.Input)
+
+		// Answer out of order to check that vectors follow the index field
+		data := make([]map[string]any, len(body.Input))
+		for i := range body.Input {
+			j := len(body.Input) - 1 - i
+			data[i] = map[string]any{"index": j, "embedding": []float32{float32(len(body.Input[j]))}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	defer srv.Close()
+
+	svc := &Service{}
+	provider := models.Provider{ProviderID: "voyageai", Type: models.ProviderTypeOpenAI, BaseURL: srv.URL, APIKey: "sk-test"}
+	texts := make([]string, embedBatchSize+1)
+	for i := range texts {
+		texts[i] = string(make([]byte, i+1))
+	}
+
+	vectors, err := svc.Embed(context.Background(), provider, "voyage-3", texts, InputTypeDocument)
+	assert.NoError(t, err)
+	assert.Len(t, requests, 2)
+	for i, v := range vectors {
+		assert.Equal(t, []float32{float32(i + 1)}, v)
+	}
+
+	// Cached texts are not sent again.
+	_, err = svc.Embed(context.Background(), provider, "voyage-3", texts[:3], InputTypeDocument)
+	assert.NoError(t, err)
+	assert.Len(t, requests, 2)
+}
+
+func TestRerank_Voyage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/rerank", r.URL.Path)
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, float64(2), body["top_k"])
+		w.Write([]byte(`{"data":[{"index":2,"relevance_score":0.4},{"index":0,"relevance_score":0.9}]}`))
+	}))
+	defer srv.Close()
+
+	provider := models.Provider{ProviderID: "voyageai", Type: models.ProviderTypeOpenAI, BaseURL: srv.URL}
+	results, err := (&Service{}).Rerank(context.Background(), provider, "rerank-2", "q", []string{"a", "b", "c"}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []RerankResult{{Index: 0, Score: 0.9, Document: "a"}, {Index: 2, Score: 0.4, Document: "c"}}, results)
+}
@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/secrets"
+)
+
+// versionSuffix matches base URLs that already end in an API version such as /v1 or /v4.
+var versionSuffix = regexp.MustCompile(`/v\d+[a-z0-9]*$`)
+
+// apiURL joins an OpenAI-style base URL with path, adding /v1 unless the base URL
+// already names a version.
+func apiURL(baseURL, path string) string {
+	base := strings.TrimRight(baseURL, "/")
+	if !versionSuffix.MatchString(base) {
+		base += "/v1"
+	}
+	return base + path
+}
+
+// requestBuilder builds the HTTP request for one attempt; provider carries the
+// decrypted API key of the pooled key chosen for that attempt.
+type requestBuilder func(ctx context.Context, provider models.Provider) (*http.Request, error)
+
+// doProviderJSON sends a non-streaming JSON request, rotating pooled keys and retrying
+// transient failures like StreamChatWithFallback, and decodes the response into out.
+func (s *Service) doProviderJSON(ctx context.Context, provider models.Provider, build requestBuilder, out any) error {
+	policy := DefaultRetryPolicy
+	for attempt := 0; ; attempt++ {
+		selected, keyID := s.selectProviderKey(provider)
+		apiKey, err := secrets.Decrypt(selected.APIKey)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt api key for provider %s: %w", provider.ProviderID, err)
+		}
+		selected.APIKey = apiKey
+
+		code, retryAfter, err := s.sendJSON(ctx, selected, build, out)
+		s.reportKeyResult(keyID, code, retryAfter, err)
+		if err == nil {
+			return nil
+		}
+
+		keyRotated := keyID != 0 && (code == http.StatusUnauthorized || code == http.StatusTooManyRequests)
+		if ctx.Err() != nil || (!keyRotated && !isTransientError(err)) || attempt >= policy.MaxRetries {
+			return err
+		}
+		if keyRotated {
+			continue
+		}
+		delay := backoffDelay(policy, attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if policy.MaxRetryAfter > 0 && retryAfter > policy.MaxRetryAfter {
+			return err
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Service) sendJSON(ctx context.Context, provider models.Provider, build requestBuilder, out any) (int, time.Duration, error) {
+	req, err := build(ctx, provider)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return resp.StatusCode, 0, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+		return resp.StatusCode, retryAfter, &ProviderError{StatusCode: resp.StatusCode, RetryAfter: retryAfter, Err: fmt.Errorf("%s", msg)}
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return resp.StatusCode, 0, fmt.Errorf("invalid response from provider %s: %w", provider.ProviderID, err)
+	}
+	return resp.StatusCode, 0, nil
+}
+
+// newJSONRequest creates a POST request with a JSON body.
+func newJSONRequest(ctx context.Context, url string, payload any) (*http.Request, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+}
+
+// setBearer adds the provider API key as a bearer token when one is configured.
+func setBearer(req *http.Request, apiKey string) {
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"fnchatbot/internal/models"
+)
+
+// maxImageDownload bounds images fetched from URLs returned by the provider.
+const maxImageDownload = 32 << 20
+
+// ImageOptions are optional parameters of an image generation request.
+type ImageOptions struct {
+	Size    string // e.g. 1024x1024; empty uses the provider default
+	Quality string
+	N       int
+}
+
+// GeneratedImage is one image returned by an image model.
+type GeneratedImage struct {
+	Data          []byte
+	Mime          string
+	RevisedPrompt string
+}
+
+// GenerateImage creates images from prompt through the OpenAI images API, which most
+// compatible providers also implement. Images returned as URLs are downloaded so they
+// can be stored with the conversation.
+func (s *Service) GenerateImage(ctx context.Context, provider models.Provider, modelName, prompt string, opts ImageOptions) ([]GeneratedImage, error) {
+	if format := FormatFor(provider); format == FormatOllama || format == FormatGemini || provider.Type == models.ProviderTypeAnthropic {
+		return nil, fmt.Errorf("provider %s does not offer the images API", provider.ProviderID)
+	}
+
+	payload := map[string]any{"model": modelName, "prompt": prompt}
+	if opts.N > 0 {
+		payload["n"] = opts.N
+	}
+	if opts.Size != "" {
+		payload["size"] = opts.Size
+	}
+	if opts.Quality != "" {
+		payload["quality"] = opts.Quality
+	}
+	// gpt-image models always answer with base64 and reject response_format
+	if strings.HasPrefix(modelName, "dall-e") {
+		payload["response_format"] = "b64_json"
+	}
+
+	var resp struct {
+		Data []struct {
+			B64JSON       string `json:"b64_json"`
+			URL           string `json:"url"`
+			RevisedPrompt string `json:"revised_prompt"`
+		} `json:"data"`
+	}
+	err := s.doProviderJSON(ctx, provider, func(ctx context.Context, p models.Provider) (*http.Request, error) {
+		req, err := newJSONRequest(ctx, apiURL(p.BaseURL, "/images/generations"), payload)
+		if err == nil {
+			setBearer(req, p.APIKey)
+		}
+		return req, err
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("image model %s returned no images", modelName)
+	}
+
+	images := make([]GeneratedImage, 0, len(resp.Data))
+	for _, d := range resp.Data {
+		var data []byte
+		switch {
+		case d.B64JSON != "":
+			data, err = base64.StdEncoding.DecodeString(d.B64JSON)
+		case d.URL != "":
+			data, err = downloadImage(ctx, d.URL)
+		default:
+			err = fmt.Errorf("image model %s returned an empty image", modelName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, GeneratedImage{
+			Data:          data,
+			Mime:          http.DetectContentType(data),
+			RevisedPrompt: d.RevisedPrompt,
+		})
+	}
+	return images, nil
+}
+
+func downloadImage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download generated image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download generated image: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageDownload {
+		return nil, fmt.Errorf("generated image exceeds %d bytes", maxImageDownload)
+	}
+	return data, nil
+}
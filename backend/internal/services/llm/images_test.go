@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestGenerateImage(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/images/generations":
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, "b64_json", body["response_format"])
+			assert.Equal(t, "1024x1024", body["size"])
+			json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{
+				{"b64_json": base64.StdEncoding.EncodeToString(pngHeader), "revised_prompt": "a red cat"},
+				{"url": srvURL + "/files/cat.png"},
+			}})
+		case "/files/cat.png":
+			w.Write(pngHeader)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	provider := models.Provider{ProviderID: "openai", Type: models.ProviderTypeOpenAI, BaseURL: srv.URL}
+	images, err := (&Service{}).GenerateImage(context.Background(), provider, "dall-e-3", "a cat", ImageOptions{Size: "1024x1024"})
+	assert.NoError(t, err)
+	assert.Len(t, images, 2)
+	assert.Equal(t, "image/png", images[0].Mime)
+	assert.Equal(t, "a red cat", images[0].RevisedPrompt)
+	assert.Equal(t, pngHeader, images[1].Data)
+}
+
+func TestGenerateImage_UnsupportedProvider(t *testing.T) {
+	provider := models.Provider{ProviderID: "ollama", Type: models.ProviderTypeOllama}
+	_, err := (&Service{}).GenerateImage(context.Background(), provider, "llava", "a cat", ImageOptions{})
+	assert.Error(t, err)
+}
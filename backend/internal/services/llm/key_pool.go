@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Cooldown applied to a key after a 429 without Retry-After.
+	rateLimitCooldown = 60 * time.Second
+	// Cooldown applied to a key after a 401; likely revoked, so keep it out longer.
+	authFailureCooldown = 15 * time.Minute
+)
+
+// keyCursor holds the round-robin position per provider across Service instances.
+var keyCursor = struct {
+	mu   sync.Mutex
+	next map[uint]int
+}{next: make(map[uint]int)}
+
+// selectProviderKey picks an API key for provider from its key pool. It returns the
+// provider with APIKey replaced and the chosen key ID (0 when the pool is empty or
+// every key is cooling down, in which case the provider's own APIKey is kept).
+//
+// The provider's own APIKey is not part of the rotation: as long as one pooled key is
+// enabled and out of cooldown it is never used. Add it to the pool to rotate it too.
+func (s *Service) selectProviderKey(provider models.Provider) (models.Provider, uint) {
+	if s.DB == nil || provider.ID == 0 {
+		return provider, 0
+	}
+
+	var keys []models.ProviderKey
+	query := s.DB.Where("provider_id = ? AND enabled = ?", provider.ID, true).
+		Where("cooldown_until IS NULL OR cooldown_until < ?", time.Now())
+	if provider.KeySelection == models.KeySelectionLeastRateLimited {
+		query = query.Order("last_rate_limited_at IS NOT NULL, last_rate_limited_at asc, usage_count asc")
+	} else {
+		query = query.Order("id asc")
+	}
+	if err := query.Find(&keys).Error; err != nil {
+		log.Printf("Failed to load keys for provider %s: %v", provider.ProviderID, err)
+		return provider, 0
+	}
+	if len(keys) == 0 {
+		return provider, 0
+	}
+
+	key := keys[0]
+	if provider.KeySelection != models.KeySelectionLeastRateLimited {
+		keyCursor.mu.Lock()
+		idx := keyCursor.next[provider.ID] % len(keys)
+		keyCursor.next[provider.ID] = idx + 1
+		keyCursor.mu.Unlock()
+		key = keys[idx]
+	}
+
+	provider.APIKey = key.Key
+	return provider, key.ID
+}
+
+// reportKeyResult updates usage counters and cooldown for a pooled key after a call.
+func (s *Service) reportKeyResult(keyID uint, statusCode int, retryAfter time.Duration, callErr error) {
+	if s.DB == nil || keyID == 0 {
+		return
+	}
+	now := time.Now()
+	updates := map[string]interface{}{
+		"usage_count":  gorm.Expr("usage_count + ?", 1),
+		"last_used_at": now,
+	}
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		cooldown := retryAfter
+		if cooldown <= 0 {
+			cooldown = rateLimitCooldown
+		}
+		updates["rate_limit_count"] = gorm.Expr("rate_limit_count + ?", 1)
+		updates["last_rate_limited_at"] = now
+		updates["cooldown_until"] = now.Add(cooldown)
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		updates["failure_count"] = gorm.Expr("failure_count + ?", 1)
+		updates["cooldown_until"] = now.Add(authFailureCooldown)
+	case callErr != nil:
+		updates["failure_count"] = gorm.Expr("failure_count + ?", 1)
+	}
+	if callErr != nil {
+		updates["last_error"] = callErr.Error()
+	}
+	if err := s.DB.Model(&models.ProviderKey{}).Where("id = ?", keyID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update provider key %d: %v", keyID, err)
+	}
+}
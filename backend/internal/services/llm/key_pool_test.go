@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSelectProviderKey_RotationAndCooldown(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Provider{}, &models.ProviderKey{}))
+
+	provider := models.Provider{ProviderID: "p", Name: "P", Type: models.ProviderTypeOpenAI, BaseURL: "http://x", APIKey: "base-key"}
+	assert.NoError(t, db.Create(&provider).Error)
+
+	svc := NewService(db)
+
+	// Empty pool keeps the provider's own key.
+	p, keyID := svc.selectProviderKey(provider)
+	assert.Equal(t, uint(0), keyID)
+	assert.Equal(t, "base-key", p.APIKey)
+
+	keys := []models.ProviderKey{
+		{ProviderID: provider.ID, Key: "sk-test-one-1234", Enabled: true},
+		{ProviderID: provider.ID, Key: "sk-test-two-5678", Enabled: true},
+	}
+	assert.NoError(t, db.Create(&keys).Error)
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		p, _ := svc.selectProviderKey(provider)
+		seen[p.APIKey]++
+	}
+	assert.Equal(t, 2, seen["sk-test-one-1234"])
+	assert.Equal(t, 2, seen["sk-test-two-5678"])
+
+	// A 429 puts the key into cooldown, leaving only the other key selectable.
+	svc.reportKeyResult(keys[0].ID, http.StatusTooManyRequests, 0, errors.New("rate limited"))
+	for i := 0; i < 3; i++ {
+		p, keyID := svc.selectProviderKey(provider)
+		assert.Equal(t, "sk-test-two-5678", p.APIKey)
+		assert.Equal(t, keys[1].ID, keyID)
+	}
+
+	var stored models.ProviderKey
+	assert.NoError(t, db.First(&stored, keys[0].ID).Error)
+	assert.Equal(t, int64(1), stored.UsageCount)
+	assert.Equal(t, int64(1), stored.RateLimitCount)
+	assert.NotNil(t, stored.CooldownUntil)
+	assert.Empty(t, stored.MaskedKey, "masking is left to the API layer")
+}
@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"strings"
+
+	"fnchatbot/internal/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// thinkSplitter separates inline <think>...</think> blocks, which DeepSeek R1 style
+// models served by Ollama and many OpenAI-compatible hosts emit in the answer text,
+// from the streamed content. Tags split across chunks are held back until complete.
+type thinkSplitter struct {
+	inThink bool
+	pending string
+}
+
+// write returns the answer and reasoning text contained in chunk.
+func (t *thinkSplitter) write(chunk string) (content, reasoning string) {
+	buf := t.pending + chunk
+	t.pending = ""
+	var out, thought strings.Builder
+	for buf != "" {
+		tag := thinkOpen
+		if t.inThink {
+			tag = thinkClose
+		}
+		if i := strings.Index(buf, tag); i >= 0 {
+			t.emit(&out, &thought, buf[:i])
+			buf = buf[i+len(tag):]
+			t.inThink = !t.inThink
+			continue
+		}
+		// Keep a trailing prefix of the tag for the next chunk
+		keep := 0
+		for n := len(tag) - 1; n > 0; n-- {
+			if strings.HasSuffix(buf, tag[:n]) {
+				keep = n
+				break
+			}
+		}
+		t.emit(&out, &thought, buf[:len(buf)-keep])
+		t.pending = buf[len(buf)-keep:]
+		break
+	}
+	return out.String(), thought.String()
+}
+
+// flush returns text held back at the end of the stream.
+func (t *thinkSplitter) flush() (content, reasoning string) {
+	rest := t.pending
+	t.pending = ""
+	if t.inThink {
+		return "", rest
+	}
+	return rest, ""
+}
+
+func (t *thinkSplitter) emit(out, thought *strings.Builder, s string) {
+	if t.inThink {
+		thought.WriteString(s)
+	} else {
+		out.WriteString(s)
+	}
+}
+
+// splitThinkTags moves <think> blocks of a complete answer into the reasoning text.
+func splitThinkTags(text string) (content, reasoning string) {
+	if !strings.Contains(text, thinkOpen) {
+		return text, ""
+	}
+	var t thinkSplitter
+	content, reasoning = t.write(text)
+	c, r := t.flush()
+	return strings.TrimLeft(content+c, "\n"), strings.TrimSpace(reasoning + r)
+}
+
+// thinkingOptions enables Anthropic extended thinking when the model config sets a
+// budget. Thinking is skipped when continuing after tool results, because the API then
+// expects the signed thinking block of the previous turn, which is not kept.
+func thinkingOptions(provider models.Provider, budget int, messages []llms.MessageContent) []llms.CallOption {
+	if provider.Type != models.ProviderTypeAnthropic || budget <= 0 {
+		return nil
+	}
+	if len(messages) > 0 && messages[len(messages)-1].Role == llms.ChatMessageTypeTool {
+		return nil
+	}
+	return []llms.CallOption{
+		llms.WithThinking(&llms.ThinkingConfig{BudgetTokens: budget, StreamThinking: true}),
+		// max_tokens must leave room for the answer after the thinking budget
+		llms.WithMaxTokens(budget + 8192),
+	}
+}
+
+// normalizeReasoning gives every response the same shape: the reasoning of the answer
+// in ReasoningContent of the first choice, and no choices that only carry thinking.
+func normalizeReasoning(resp *llms.ContentResponse) {
+	if resp == nil {
+		return
+	}
+	var thinking []string
+	choices := resp.Choices[:0]
+	for _, c := range resp.Choices {
+		// Anthropic returns thinking blocks as separate, empty choices
+		if c.Content == "" && len(c.ToolCalls) == 0 && c.FuncCall == nil {
+			if t, ok := c.GenerationInfo["ThinkingContent"].(string); ok && t != "" {
+				thinking = append(thinking, t)
+				continue
+			}
+		}
+		if c.ReasoningContent == "" {
+			c.Content, c.ReasoningContent = splitThinkTags(c.Content)
+		}
+		choices = append(choices, c)
+	}
+	resp.Choices = choices
+	if len(thinking) > 0 && len(choices) > 0 && choices[0].ReasoningContent == "" {
+		choices[0].ReasoningContent = strings.Join(thinking, "\n\n")
+	}
+}
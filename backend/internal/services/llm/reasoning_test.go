@@ -0,0 +1,82 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestThinkSplitter(t *testing.T) {
+	var s thinkSplitter
+	var content, reasoning string
+	for _, chunk := range []string{"<thi", "nk>let me ", "think</th", "ink>\n\nThe answer", " is <b>42</b>"} {
+		c, r := s.write(chunk)
+		content += c
+		reasoning += r
+	}
+	c, r := s.flush()
+	content += c
+	reasoning += r
+
+	assert.Equal(t, "let me think", reasoning)
+	assert.Equal(t, "\n\nThe answer is <b>42</b>", content)
+}
+
+func TestStreamOptions_Reasoning(t *testing.T) {
+	var content, reasoning string
+	opts, flush := streamOptions(StreamHandlers{
+		Content: func(_ context.Context, chunk []byte) error {
+			content += string(chunk)
+			return nil
+		},
+		Reasoning: func(_ context.Context, chunk []byte) error {
+			reasoning += string(chunk)
+			return nil
+		},
+	})
+	var call llms.CallOptions
+	for _, o := range opts {
+		o(&call)
+	}
+
+	ctx := context.Background()
+	// DeepSeek style: reasoning arrives separately with an empty content chunk
+	assert.NoError(t, call.StreamingReasoningFunc(ctx, []byte("hmm"), nil))
+	assert.NoError(t, call.StreamingFunc(ctx, nil))
+	assert.NoError(t, call.StreamingFunc(ctx, []byte("ok <")))
+	assert.NoError(t, flush(ctx))
+
+	assert.Equal(t, "hmm", reasoning)
+	assert.Equal(t, "ok <", content)
+}
+
+func TestNormalizeReasoning(t *testing.T) {
+	resp := &llms.ContentResponse{Choices: []*llms.ContentChoice{
+		{GenerationInfo: map[string]any{"ThinkingContent": "step 1"}},
+		{Content: "done"},
+	}}
+	normalizeReasoning(resp)
+	assert.Len(t, resp.Choices, 1)
+	assert.Equal(t, "done", resp.Choices[0].Content)
+	assert.Equal(t, "step 1", resp.Choices[0].ReasoningContent)
+
+	resp = &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "<think>why</think>\nbecause"}}}
+	normalizeReasoning(resp)
+	assert.Equal(t, "because", resp.Choices[0].Content)
+	assert.Equal(t, "why", resp.Choices[0].ReasoningContent)
+}
+
+func TestThinkingOptions(t *testing.T) {
+	anthropic := models.Provider{Type: models.ProviderTypeAnthropic}
+	human := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}
+	afterTool := append(human, llms.MessageContent{Role: llms.ChatMessageTypeTool})
+
+	assert.Len(t, thinkingOptions(anthropic, 4096, human), 2)
+	assert.Empty(t, thinkingOptions(anthropic, 0, human))
+	assert.Empty(t, thinkingOptions(anthropic, 4096, afterTool))
+	assert.Empty(t, thinkingOptions(models.Provider{Type: models.ProviderTypeOpenAI}, 4096, human))
+}
@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"fnchatbot/internal/models"
+)
+
+// RerankResult is the relevance of one input document to the query.
+type RerankResult struct {
+	Index    int     `json:"index"`
+	Score    float64 `json:"relevance_score"`
+	Document string  `json:"document,omitempty"`
+}
+
+// Rerank scores documents against query with a rerank model and returns them by
+// descending relevance, limited to topN when topN > 0. Jina, Voyage and the
+// Cohere-style /rerank endpoint offered by OpenAI-compatible gateways are supported.
+func (s *Service) Rerank(ctx context.Context, provider models.Provider, modelName, query string, documents []string, topN int) ([]RerankResult, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+
+	format := FormatFor(provider)
+	payload := map[string]any{"model": modelName, "query": query, "documents": documents}
+	switch format {
+	case FormatOllama, FormatGemini:
+		return nil, fmt.Errorf("provider %s does not offer a rerank API", provider.ProviderID)
+	case FormatVoyage:
+		if topN > 0 {
+			payload["top_k"] = topN
+		}
+	default:
+		if topN > 0 {
+			payload["top_n"] = topN
+		}
+	}
+
+	// Voyage answers with "data", Jina and Cohere-style APIs with "results"
+	var resp struct {
+		Results []RerankResult `json:"results"`
+		Data    []RerankResult `json:"data"`
+	}
+	err := s.doProviderJSON(ctx, provider, func(ctx context.Context, p models.Provider) (*http.Request, error) {
+		req, err := newJSONRequest(ctx, apiURL(p.BaseURL, "/rerank"), payload)
+		if err == nil {
+			setBearer(req, p.APIKey)
+		}
+		return req, err
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	results := resp.Results
+	if len(results) == 0 {
+		results = resp.Data
+	}
+	for i := range results {
+		if results[i].Index < 0 || results[i].Index >= len(documents) {
+			return nil, fmt.Errorf("rerank model %s returned invalid index %d", modelName, results[i].Index)
+		}
+		results[i].Document = documents[results[i].Index]
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if topN > 0 && len(results) > topN {
+		results = results[:topN]
+	}
+	return results, nil
+}
@@ -0,0 +1,250 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// RetryPolicy controls how transient provider errors are retried before falling back.
+type RetryPolicy struct {
+	MaxRetries    int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxRetryAfter time.Duration // Retry-After values above this skip straight to the next target
+}
+
+// DefaultRetryPolicy is used when a model config does not override retries.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:    2,
+	BaseDelay:     500 * time.Millisecond,
+	MaxDelay:      8 * time.Second,
+	MaxRetryAfter: 30 * time.Second,
+}
+
+// ChatTarget is one provider/model pair in a fallback chain.
+type ChatTarget struct {
+	Provider models.Provider
+	Model    string
+	// ThinkingBudget enables Anthropic extended thinking with this many tokens; 0 = off
+	ThinkingBudget int
+}
+
+func (t ChatTarget) String() string {
+	return fmt.Sprintf("%s/%s", t.Provider.ProviderID, t.Model)
+}
+
+// FallbackFunc is called when the chain moves from one target to the next.
+type FallbackFunc func(from, to ChatTarget, cause error)
+
+// ProviderError wraps a failed call with the HTTP status observed on the wire.
+type ProviderError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.StatusCode > 0 {
+		return fmt.Sprintf("provider returned status %d: %v", e.StatusCode, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// callStatus records the last HTTP response status seen for one StreamChat call.
+type callStatus struct {
+	mu         sync.Mutex
+	statusCode int
+	retryAfter time.Duration
+}
+
+type callStatusKey struct{}
+
+func (c *callStatus) record(resp *http.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statusCode = resp.StatusCode
+	c.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+}
+
+func (c *callStatus) snapshot() (int, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.statusCode, c.retryAfter
+}
+
+// statusTransport captures status codes and Retry-After headers for requests whose
+// context carries a *callStatus, since langchaingo clients do not expose them.
+type statusTransport struct {
+	base http.RoundTripper
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		if st, ok := req.Context().Value(callStatusKey{}).(*callStatus); ok {
+			st.record(resp)
+		}
+	}
+	return resp, err
+}
+
+var providerHTTPClient = &http.Client{Transport: &statusTransport{base: http.DefaultTransport}}
+
+// parseRetryAfter accepts both delta-seconds and HTTP-date forms.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// backoffDelay returns an exponential delay with full jitter for the given attempt (0-based).
+func backoffDelay(policy RetryPolicy, attempt int) time.Duration {
+	ceiling := policy.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > policy.MaxDelay {
+		ceiling = policy.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling))) + 1
+}
+
+// isTransientError reports whether err is worth retrying (rate limits, 5xx, timeouts).
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var perr *ProviderError
+	if errors.As(err, &perr) && perr.StatusCode > 0 {
+		return perr.StatusCode == http.StatusTooManyRequests || perr.StatusCode >= 500
+	}
+	if llms.IsRateLimitError(err) || llms.IsProviderUnavailableError(err) || llms.IsTimeoutError(err) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range []string{"429", "rate limit", "too many requests", "500", "502", "503", "504", "overloaded", "unavailable"} {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// StreamChatWithFallback tries each target in order, retrying transient errors with
+// exponential backoff (honoring Retry-After) before moving to the next target.
+// Once any chunk has been streamed to the caller, errors are returned as-is so the
+// client never sees a duplicated partial answer.
+func (s *Service) StreamChatWithFallback(ctx context.Context, targets []ChatTarget, policy RetryPolicy, messages []llms.MessageContent, tools []llms.Tool, handlers StreamHandlers, onFallback FallbackFunc) (*llms.ContentResponse, ChatTarget, error) {
+	if len(targets) == 0 {
+		return nil, ChatTarget{}, fmt.Errorf("no chat target configured")
+	}
+
+	streamed := false
+	wrapped := StreamHandlers{
+		Content: func(ctx context.Context, chunk []byte) error {
+			streamed = true
+			if handlers.Content == nil {
+				return nil
+			}
+			return handlers.Content(ctx, chunk)
+		},
+		ToolCall: func(ctx context.Context, delta ToolCallDelta) error {
+			streamed = true
+			if handlers.ToolCall == nil {
+				return nil
+			}
+			return handlers.ToolCall(ctx, delta)
+		},
+	}
+	if handlers.Reasoning != nil {
+		wrapped.Reasoning = func(ctx context.Context, chunk []byte) error {
+			streamed = true
+			return handlers.Reasoning(ctx, chunk)
+		}
+	}
+
+	var lastErr error
+	for i, target := range targets {
+		if i > 0 && onFallback != nil {
+			onFallback(targets[i-1], target, lastErr)
+		}
+		for attempt := 0; ; attempt++ {
+			status := &callStatus{}
+			provider, keyID := s.selectProviderKey(target.Provider)
+			resp, err := s.StreamChat(context.WithValue(ctx, callStatusKey{}, status), provider, target.Model, messages, tools, wrapped, thinkingOptions(provider, target.ThinkingBudget, messages)...)
+			code, retryAfter := status.snapshot()
+			s.reportKeyResult(keyID, code, retryAfter, err)
+			if err == nil {
+				return resp, target, nil
+			}
+			if code >= http.StatusBadRequest {
+				err = &ProviderError{StatusCode: code, RetryAfter: retryAfter, Err: err}
+			}
+			lastErr = err
+
+			// A rejected or throttled pooled key is now cooling down, so the next
+			// attempt can immediately use another key from the pool.
+			keyRotated := keyID != 0 && (code == http.StatusUnauthorized || code == http.StatusTooManyRequests)
+			if streamed || ctx.Err() != nil || (!keyRotated && !isTransientError(err)) {
+				return nil, target, err
+			}
+			if attempt >= policy.MaxRetries || (!keyRotated && policy.MaxRetryAfter > 0 && retryAfter > policy.MaxRetryAfter) {
+				break
+			}
+			if keyRotated {
+				continue
+			}
+
+			delay := backoffDelay(policy, attempt)
+			if retryAfter > delay {
+				delay = retryAfter
+			}
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, target, err
+			}
+		}
+	}
+	return nil, targets[len(targets)-1], lastErr
+}
@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 7*time.Second, parseRetryAfter("7", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-3", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+
+	date := now.Add(90 * time.Second).Format(http.TimeFormat)
+	assert.Equal(t, 90*time.Second, parseRetryAfter(date, now))
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoffDelay(policy, attempt)
+		assert.Greater(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, time.Second)
+	}
+	assert.LessOrEqual(t, backoffDelay(policy, 0), 100*time.Millisecond)
+}
+
+func TestIsTransientError(t *testing.T) {
+	assert.True(t, isTransientError(&ProviderError{StatusCode: 429, Err: errors.New("slow down")}))
+	assert.True(t, isTransientError(&ProviderError{StatusCode: 503, Err: errors.New("down")}))
+	assert.False(t, isTransientError(&ProviderError{StatusCode: 400, Err: errors.New("bad")}))
+	assert.False(t, isTransientError(&ProviderError{StatusCode: 401, Err: errors.New("API returned 429 tokens")}))
+	assert.True(t, isTransientError(errors.New("API returned unexpected status code: 502")))
+	assert.False(t, isTransientError(context.Canceled))
+	assert.False(t, isTransientError(nil))
+}
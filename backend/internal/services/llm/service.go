@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"fnchatbot/internal/models"
+	"fnchatbot/internal/secrets"
 	"fnchatbot/internal/services/memory"
 
 	"github.com/tmc/langchaingo/llms"
@@ -26,20 +27,29 @@ func NewService(db *gorm.DB) *Service {
 // StreamChat generates a streaming response with tool support
 // It does NOT handle history automatically to allow caller (WebSocket) to manage the loop and UI updates.
 // The caller is responsible for passing the full conversation history.
-func (s *Service) StreamChat(ctx context.Context, provider models.Provider, modelName string, messages []llms.MessageContent, tools []llms.Tool, streamCallback func(ctx context.Context, chunk []byte) error) (*llms.ContentResponse, error) {
+// Reasoning tokens go to the Reasoning handler rather than Content, and the response
+// carries them in ReasoningContent of the first choice.
+func (s *Service) StreamChat(ctx context.Context, provider models.Provider, modelName string, messages []llms.MessageContent, tools []llms.Tool, handlers StreamHandlers, extra ...llms.CallOption) (*llms.ContentResponse, error) {
 	llm, err := s.createLLM(ctx, provider, modelName)
 	if err != nil {
 		return nil, err
 	}
 
-	opts := []llms.CallOption{
-		llms.WithStreamingFunc(streamCallback),
-	}
+	opts, flush := streamOptions(handlers)
 	if len(tools) > 0 {
 		opts = append(opts, llms.WithTools(tools))
 	}
+	opts = append(opts, extra...)
 
-	return llm.GenerateContent(ctx, messages, opts...)
+	resp, err := llm.GenerateContent(ctx, adaptDocuments(provider.Type, messages), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := flush(ctx); err != nil {
+		return nil, err
+	}
+	normalizeReasoning(resp)
+	return resp, nil
 }
 
 // GetHistory returns the chat history for a session
@@ -60,22 +70,37 @@ func (s *Service) SaveAIMessage(ctx context.Context, sessionID uint, content str
 	return history.AddAIMessage(ctx, content)
 }
 
+// SaveMessage saves any chat message (e.g. an AI message with tool calls) and returns the stored row
+func (s *Service) SaveMessage(ctx context.Context, sessionID uint, message llms.ChatMessage) (*models.Message, error) {
+	history := memory.NewSQLiteHistory(s.DB, sessionID)
+	return history.SaveMessage(ctx, message)
+}
+
 func (s *Service) createLLM(ctx context.Context, provider models.Provider, modelName string) (llms.Model, error) {
+	// API keys are stored encrypted; decrypt only for the outgoing client
+	apiKey, err := secrets.Decrypt(provider.APIKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt api key for provider %s: %w", provider.ProviderID, err)
+	}
+	provider.APIKey = apiKey
+
 	switch provider.Type {
 	case models.ProviderTypeOpenAI, models.ProviderTypeOpenAIResponse:
 		opts := []openai.Option{
 			openai.WithToken(provider.APIKey),
 			openai.WithModel(modelName),
+			openai.WithHTTPClient(providerHTTPClient),
 		}
 		if provider.BaseURL != "" {
 			opts = append(opts, openai.WithBaseURL(provider.BaseURL))
 		}
 		return openai.New(opts...)
 	case models.ProviderTypeAnthropic:
-		return anthropic.New(anthropic.WithToken(provider.APIKey), anthropic.WithModel(modelName))
+		return anthropic.New(anthropic.WithToken(provider.APIKey), anthropic.WithModel(modelName), anthropic.WithHTTPClient(providerHTTPClient))
 	case models.ProviderTypeOllama:
 		opts := []ollama.Option{
 			ollama.WithModel(modelName),
+			ollama.WithHTTPClient(providerHTTPClient),
 		}
 		if provider.BaseURL != "" {
 			opts = append(opts, ollama.WithServerURL(provider.BaseURL))
@@ -90,6 +115,7 @@ func (s *Service) createLLM(ctx context.Context, provider models.Provider, model
 				openai.WithToken(provider.APIKey),
 				openai.WithModel(modelName),
 				openai.WithBaseURL(provider.BaseURL),
+				openai.WithHTTPClient(providerHTTPClient),
 			}
 			return openai.New(opts...)
 		}
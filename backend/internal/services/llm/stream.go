@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// StreamHandlers receive the output of a streaming call as it arrives. Any handler may
+// be nil.
+type StreamHandlers struct {
+	// Content receives answer text
+	Content func(ctx context.Context, chunk []byte) error
+	// Reasoning receives thinking tokens, whether sent separately by the provider or
+	// inline as <think> blocks
+	Reasoning func(ctx context.Context, chunk []byte) error
+	// ToolCall receives tool calls as the model writes them, for providers that stream
+	// them (OpenAI-compatible)
+	ToolCall func(ctx context.Context, delta ToolCallDelta) error
+}
+
+// ToolCallDelta is a piece of a tool call being streamed. The first delta of a call
+// carries its name; later ones append to its arguments.
+type ToolCallDelta struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// streamedToolCall is the shape of the tool call chunks the OpenAI client passes to the
+// streaming func.
+type streamedToolCall struct {
+	ID       string `json:"id"`
+	Function *struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// parseToolCallChunk recognises a streamed chunk that holds tool call deltas rather
+// than answer text.
+func parseToolCallChunk(chunk []byte) ([]streamedToolCall, bool) {
+	if !bytes.HasPrefix(chunk, []byte(`[{`)) {
+		return nil, false
+	}
+	var calls []streamedToolCall
+	if err := json.Unmarshal(chunk, &calls); err != nil || len(calls) == 0 {
+		return nil, false
+	}
+	for _, c := range calls {
+		if c.Function == nil {
+			return nil, false
+		}
+	}
+	return calls, true
+}
+
+// streamOptions builds the streaming call options for h. Answer text is split from
+// inline <think> blocks and tool call chunks; the returned function flushes text held
+// back at the end of the call.
+func streamOptions(h StreamHandlers) ([]llms.CallOption, func(ctx context.Context) error) {
+	splitter := &thinkSplitter{}
+	var currentCall string
+	send := func(ctx context.Context, content, reasoning string) error {
+		if reasoning != "" && h.Reasoning != nil {
+			if err := h.Reasoning(ctx, []byte(reasoning)); err != nil {
+				return err
+			}
+		}
+		if content != "" && h.Content != nil {
+			return h.Content(ctx, []byte(content))
+		}
+		return nil
+	}
+
+	opts := []llms.CallOption{
+		llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			if calls, ok := parseToolCallChunk(chunk); ok {
+				for _, c := range calls {
+					// Only the first delta of a call carries its ID
+					if c.ID != "" {
+						currentCall = c.ID
+					}
+					if h.ToolCall == nil || currentCall == "" {
+						continue
+					}
+					if err := h.ToolCall(ctx, ToolCallDelta{ID: currentCall, Name: c.Function.Name, Arguments: c.Function.Arguments}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			content, reasoning := splitter.write(string(chunk))
+			return send(ctx, content, reasoning)
+		}),
+	}
+	if h.Reasoning != nil {
+		// The content chunk is also delivered to the streaming func above
+		opts = append(opts, llms.WithStreamingReasoningFunc(func(ctx context.Context, reasoningChunk, _ []byte) error {
+			if len(reasoningChunk) == 0 {
+				return nil
+			}
+			return h.Reasoning(ctx, reasoningChunk)
+		}))
+	}
+	flush := func(ctx context.Context) error {
+		content, reasoning := splitter.flush()
+		return send(ctx, content, reasoning)
+	}
+	return opts, flush
+}
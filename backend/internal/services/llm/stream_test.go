@@ -0,0 +1,47 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestStreamOptions_ToolCalls(t *testing.T) {
+	var content string
+	var deltas []ToolCallDelta
+	opts, _ := streamOptions(StreamHandlers{
+		Content: func(_ context.Context, chunk []byte) error {
+			content += string(chunk)
+			return nil
+		},
+		ToolCall: func(_ context.Context, d ToolCallDelta) error {
+			deltas = append(deltas, d)
+			return nil
+		},
+	})
+	var call llms.CallOptions
+	for _, o := range opts {
+		o(&call)
+	}
+
+	// Chunks as produced by the OpenAI client for streamed tool calls
+	ctx := context.Background()
+	for _, chunk := range []string{
+		"Let me check.",
+		`[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]`,
+		`[{"type":"","function":{"name":"","arguments":"{\"city\":"}}]`,
+		`[{"type":"","function":{"name":"","arguments":"\"Paris\"}"}}]`,
+		"[{not json",
+	} {
+		assert.NoError(t, call.StreamingFunc(ctx, []byte(chunk)))
+	}
+
+	assert.Equal(t, "Let me check.[{not json", content)
+	assert.Equal(t, []ToolCallDelta{
+		{ID: "call_1", Name: "get_weather"},
+		{ID: "call_1", Arguments: `{"city":`},
+		{ID: "call_1", Arguments: `"Paris"}`},
+	}, deltas)
+}
@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// MaxTitleRunes bounds generated conversation titles.
+const MaxTitleRunes = 60
+
+// titleInputRunes bounds each message shown to the title model.
+const titleInputRunes = 2000
+
+const titlePrompt = `Write a short title for the conversation below: at most 8 words, in the conversation's language, without quotes or a trailing period. Reply with the title only.`
+
+// GenerateTitle names a conversation after its first exchange.
+func (s *Service) GenerateTitle(ctx context.Context, targets []ChatTarget, question, answer string) (string, *llms.ContentResponse, ChatTarget, error) {
+	var b strings.Builder
+	b.WriteString("User: ")
+	b.WriteString(truncateRunes(question, titleInputRunes))
+	b.WriteString("\n\nAssistant: ")
+	b.WriteString(truncateRunes(answer, titleInputRunes))
+
+	resp, target, err := s.StreamChatWithFallback(ctx, targets, DefaultRetryPolicy, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, titlePrompt),
+		llms.TextParts(llms.ChatMessageTypeHuman, b.String()),
+	}, nil, StreamHandlers{}, nil)
+	if err != nil {
+		return "", nil, target, err
+	}
+	if len(resp.Choices) == 0 {
+		return "", resp, target, errors.New("empty title")
+	}
+	title := CleanTitle(resp.Choices[0].Content)
+	if title == "" {
+		return "", resp, target, errors.New("empty title")
+	}
+	return title, resp, target, nil
+}
+
+// CleanTitle keeps the first line of a model's title, without quotes, markdown
+// emphasis or a trailing period, cut to MaxTitleRunes.
+func CleanTitle(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimPrefix(s, "Title:")
+	// Quotes may enclose the period or follow it
+	s = strings.Trim(strings.TrimRight(strings.Trim(s, titleTrim), ".。"), titleTrim)
+	return strings.TrimSpace(truncateRunes(s, MaxTitleRunes))
+}
+
+const titleTrim = " \t#*_`\"'“”‘’「」《》"
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
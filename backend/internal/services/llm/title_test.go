@@ -0,0 +1,16 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanTitle(t *testing.T) {
+	assert.Equal(t, "Docker sandbox setup", CleanTitle("\"Docker sandbox setup.\"\n"))
+	assert.Equal(t, "Docker sandbox setup", CleanTitle("Title: **Docker sandbox setup**\nbecause the user asked"))
+	assert.Equal(t, "配置沙箱", CleanTitle("「配置沙箱」。"))
+	assert.Equal(t, MaxTitleRunes, len([]rune(CleanTitle(strings.Repeat("标题", MaxTitleRunes)))))
+	assert.Equal(t, "", CleanTitle("  \"\" "))
+}
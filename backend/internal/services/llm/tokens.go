@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// imageTokens is a flat per-image estimate; providers bill images by tile, which the
+// message content alone cannot reproduce.
+const imageTokens = 765
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[string]*tiktoken.Tiktoken{}
+	// tokenizerUnavailable is set once the BPE files cannot be loaded (e.g. offline
+	// NAS installs) so later counts skip straight to the approximation.
+	tokenizerUnavailable bool
+)
+
+// countTokens counts text tokens for model. It is a variable so tests can avoid
+// loading BPE files.
+var countTokens = tiktokenCount
+
+// tiktokenCount uses the model's tiktoken encoding, cl100k_base for models tiktoken
+// does not know (most non-OpenAI models), and a character-based approximation when
+// no encoding can be loaded.
+func tiktokenCount(model, text string) int {
+	if text == "" {
+		return 0
+	}
+	enc := encodingFor(model)
+	if enc == nil {
+		return approxTokens(text)
+	}
+	return len(enc.Encode(text, nil, nil))
+}
+
+func encodingFor(model string) *tiktoken.Tiktoken {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	if enc, ok := encodings[model]; ok {
+		return enc
+	}
+	if tokenizerUnavailable {
+		return nil
+	}
+
+	name := tiktoken.MODEL_CL100K_BASE
+	if m, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		name = m
+	}
+	enc, ok := encodings[name]
+	if !ok {
+		var err error
+		if enc, err = tiktoken.GetEncoding(name); err != nil {
+			tokenizerUnavailable = true
+			return nil
+		}
+		encodings[name] = enc
+	}
+	encodings[model] = enc
+	return enc
+}
+
+// approxTokens estimates ~4 ASCII characters per token and one token per other rune,
+// which keeps CJK text from being badly undercounted.
+func approxTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return ascii/4 + other + 1
+}
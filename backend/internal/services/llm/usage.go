@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Usage is the token accounting for one model call.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	ReasoningTokens  int
+	CachedTokens     int
+	TotalTokens      int
+	Estimated        bool
+}
+
+// UsageContext identifies who and what a recorded usage belongs to.
+type UsageContext struct {
+	MessageID     uint
+	SessionID     uint
+	UserID        uint
+	ModelConfigID uint
+	Target        ChatTarget
+}
+
+// pricePerTokens is the token count InputPrice/OutputPrice are quoted for.
+const pricePerTokens = 1_000_000
+
+// ExtractUsage reads token counts from a response's generation info. Providers use
+// different key names (OpenAI/Ollama/Gemini: PromptTokens/CompletionTokens,
+// Anthropic: InputTokens/OutputTokens), so all known spellings are checked.
+// ok is false when the provider reported nothing.
+func ExtractUsage(resp *llms.ContentResponse) (Usage, bool) {
+	var u Usage
+	if resp == nil {
+		return u, false
+	}
+	for _, choice := range resp.Choices {
+		info := choice.GenerationInfo
+		if len(info) == 0 {
+			continue
+		}
+		u.PromptTokens = firstInt(info, "PromptTokens", "InputTokens")
+		u.CompletionTokens = firstInt(info, "CompletionTokens", "OutputTokens")
+		u.ReasoningTokens = firstInt(info, "ReasoningTokens", "ThinkingTokens")
+		u.CachedTokens = firstInt(info, "PromptCachedTokens", "CacheReadInputTokens", "CachedTokens")
+		u.TotalTokens = firstInt(info, "TotalTokens")
+		if u.PromptTokens == 0 && u.CompletionTokens == 0 {
+			continue
+		}
+		if u.TotalTokens == 0 {
+			u.TotalTokens = u.PromptTokens + u.CompletionTokens
+		}
+		return u, true
+	}
+	return Usage{}, false
+}
+
+func firstInt(info map[string]any, keys ...string) int {
+	for _, k := range keys {
+		switch v := info[k].(type) {
+		case int:
+			if v != 0 {
+				return v
+			}
+		case int32:
+			if v != 0 {
+				return int(v)
+			}
+		case int64:
+			if v != 0 {
+				return int(v)
+			}
+		case float64:
+			if v != 0 {
+				return int(v)
+			}
+		}
+	}
+	return 0
+}
+
+// EstimateUsage counts tokens locally with tiktoken for providers that omit usage
+// (commonly when streaming through OpenAI-compatible gateways).
+func EstimateUsage(model string, messages []llms.MessageContent, resp *llms.ContentResponse) Usage {
+	u := Usage{Estimated: true}
+	for _, m := range messages {
+		for _, part := range m.Parts {
+			switch p := part.(type) {
+			case llms.TextContent:
+				u.PromptTokens += countTokens(model, p.Text)
+			case llms.ToolCall:
+				if p.FunctionCall != nil {
+					u.PromptTokens += countTokens(model, p.FunctionCall.Name+p.FunctionCall.Arguments)
+				}
+			case llms.ToolCallResponse:
+				u.PromptTokens += countTokens(model, p.Content)
+			}
+		}
+	}
+	if resp != nil {
+		for _, choice := range resp.Choices {
+			u.CompletionTokens += countTokens(model, choice.Content)
+			for _, tc := range choice.ToolCalls {
+				if tc.FunctionCall != nil {
+					u.CompletionTokens += countTokens(model, tc.FunctionCall.Name+tc.FunctionCall.Arguments)
+				}
+			}
+		}
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+// Cost prices usage with a model's per-million-token input/output prices.
+func Cost(u Usage, m models.Model) float64 {
+	return (float64(u.PromptTokens)*m.InputPrice + float64(u.CompletionTokens)*m.OutputPrice) / pricePerTokens
+}
+
+// RecordUsage persists the usage of one model call, falling back to a local estimate
+// when the response carries no token counts. Cost is taken from the provider's model
+// catalogue entry; unknown models are recorded at zero cost.
+func (s *Service) RecordUsage(uc UsageContext, messages []llms.MessageContent, resp *llms.ContentResponse) (*models.TokenUsage, error) {
+	u, ok := ExtractUsage(resp)
+	if !ok {
+		u = EstimateUsage(uc.Target.Model, messages, resp)
+	}
+
+	record := models.TokenUsage{
+		MessageID:        uc.MessageID,
+		SessionID:        uc.SessionID,
+		UserID:           uc.UserID,
+		ModelConfigID:    uc.ModelConfigID,
+		ProviderID:       uc.Target.Provider.ID,
+		Model:            uc.Target.Model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		ReasoningTokens:  u.ReasoningTokens,
+		CachedTokens:     u.CachedTokens,
+		TotalTokens:      u.TotalTokens,
+		Estimated:        u.Estimated,
+		CreatedAt:        time.Now(),
+	}
+
+	var model models.Model
+	if err := s.DB.Where("provider_id = ? AND model_id = ?", uc.Target.Provider.ID, uc.Target.Model).First(&model).Error; err == nil {
+		record.Cost = Cost(u, model)
+	}
+
+	if err := s.DB.Create(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// RecordInputUsage records a call billed by its input only, such as embeddings and
+// reranking. Those APIs report no usage in a common form, so tokens are estimated
+// from texts.
+func (s *Service) RecordInputUsage(uc UsageContext, texts []string) (*models.TokenUsage, error) {
+	parts := make([]llms.ContentPart, len(texts))
+	for i, t := range texts {
+		parts[i] = llms.TextPart(t)
+	}
+	return s.RecordUsage(uc, []llms.MessageContent{{Role: llms.ChatMessageTypeHuman, Parts: parts}}, nil)
+}
+
+// RecordImageUsage records an image generation call. The prompt tokens are estimated
+// and priced like other input; each generated image adds the catalogue image price.
+func (s *Service) RecordImageUsage(uc UsageContext, prompt string, images int) (*models.TokenUsage, error) {
+	u := Usage{PromptTokens: countTokens(uc.Target.Model, prompt), Estimated: true}
+	u.TotalTokens = u.PromptTokens
+
+	record := models.TokenUsage{
+		MessageID:     uc.MessageID,
+		SessionID:     uc.SessionID,
+		UserID:        uc.UserID,
+		ModelConfigID: uc.ModelConfigID,
+		ProviderID:    uc.Target.Provider.ID,
+		Model:         uc.Target.Model,
+		PromptTokens:  u.PromptTokens,
+		TotalTokens:   u.TotalTokens,
+		Estimated:     true,
+		CreatedAt:     time.Now(),
+	}
+
+	var model models.Model
+	if err := s.DB.Where("provider_id = ? AND model_id = ?", uc.Target.Provider.ID, uc.Target.Model).First(&model).Error; err == nil {
+		record.Cost = Cost(u, model) + float64(images)*model.ImagePrice
+	}
+
+	if err := s.DB.Create(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+func TestExtractUsage(t *testing.T) {
+	openaiResp := &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+		GenerationInfo: map[string]any{
+			"PromptTokens":       120,
+			"CompletionTokens":   30,
+			"TotalTokens":        150,
+			"ReasoningTokens":    10,
+			"PromptCachedTokens": 64,
+		},
+	}}}
+	u, ok := ExtractUsage(openaiResp)
+	assert.True(t, ok)
+	assert.Equal(t, Usage{PromptTokens: 120, CompletionTokens: 30, ReasoningTokens: 10, CachedTokens: 64, TotalTokens: 150}, u)
+
+	anthropicResp := &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+		GenerationInfo: map[string]any{
+			"InputTokens":          200,
+			"OutputTokens":         50,
+			"CacheReadInputTokens": 100,
+		},
+	}}}
+	u, ok = ExtractUsage(anthropicResp)
+	assert.True(t, ok)
+	assert.Equal(t, 200, u.PromptTokens)
+	assert.Equal(t, 50, u.CompletionTokens)
+	assert.Equal(t, 100, u.CachedTokens)
+	assert.Equal(t, 250, u.TotalTokens)
+
+	_, ok = ExtractUsage(&llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "hi"}}})
+	assert.False(t, ok)
+}
+
+func TestRecordUsage_Cost(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Provider{}, &models.Model{}, &models.TokenUsage{}))
+
+	provider := models.Provider{ProviderID: "p", Name: "P", Type: models.ProviderTypeOpenAI, BaseURL: "http://x"}
+	assert.NoError(t, db.Create(&provider).Error)
+	assert.NoError(t, db.Create(&models.Model{ProviderID: provider.ID, ModelID: "gpt-x", Name: "GPT X", InputPrice: 2, OutputPrice: 8}).Error)
+
+	svc := NewService(db)
+	resp := &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+		GenerationInfo: map[string]any{"PromptTokens": 1000, "CompletionTokens": 500},
+	}}}
+	record, err := svc.RecordUsage(UsageContext{
+		MessageID: 7,
+		SessionID: 3,
+		UserID:    1,
+		Target:    ChatTarget{Provider: provider, Model: "gpt-x"},
+	}, nil, resp)
+	assert.NoError(t, err)
+	assert.False(t, record.Estimated)
+	assert.Equal(t, 1500, record.TotalTokens)
+	assert.InDelta(t, (1000*2.0+500*8.0)/1_000_000, record.Cost, 1e-12)
+
+	// Models missing from the catalogue are recorded without cost.
+	record, err = svc.RecordUsage(UsageContext{Target: ChatTarget{Provider: provider, Model: "unknown"}}, nil, resp)
+	assert.NoError(t, err)
+	assert.Zero(t, record.Cost)
+}
+
+func TestRecordImageUsage_Cost(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Provider{}, &models.Model{}, &models.TokenUsage{}))
+
+	provider := models.Provider{ProviderID: "p", Name: "P", Type: models.ProviderTypeOpenAI, BaseURL: "http://x"}
+	assert.NoError(t, db.Create(&provider).Error)
+	assert.NoError(t, db.Create(&models.Model{ProviderID: provider.ID, ModelID: "img", Name: "Img", ImagePrice: 0.04}).Error)
+
+	record, err := NewService(db).RecordImageUsage(UsageContext{
+		UserID: 1,
+		Target: ChatTarget{Provider: provider, Model: "img"},
+	}, "a red fox in the snow", 2)
+	assert.NoError(t, err)
+	assert.True(t, record.Estimated)
+	assert.Positive(t, record.PromptTokens)
+	assert.InDelta(t, 0.08, record.Cost, 1e-9)
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"fnchatbot/internal/models"
+	"fnchatbot/internal/secrets"
 
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -61,7 +63,7 @@ func (s *MCPService) LoadFile() (*models.MCPFile, error) {
 	return &f, nil
 }
 
-// SaveFile writes the config to mcp.json.
+// SaveFile writes the config to mcp.json. The file holds (encrypted) secrets, so it is owner-only.
 func (s *MCPService) SaveFile(f *models.MCPFile) error {
 	if f == nil || f.Servers == nil {
 		f = &models.MCPFile{Servers: make(map[string]models.MCPServerConfig)}
@@ -70,7 +72,7 @@ func (s *MCPService) SaveFile(f *models.MCPFile) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.filePath, data, 0644)
+	return os.WriteFile(s.filePath, data, 0600)
 }
 
 func (s *MCPService) timeoutMs(cfg models.MCPServerConfig) int {
@@ -98,8 +100,13 @@ func (s *MCPService) connectServer(ctx context.Context, name string, cfg models.
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	cfg, err := DecryptMCPConfig(cfg)
+	if err != nil {
+		s.setStatus(name, models.MCPStatusFailed, err.Error())
+		return
+	}
+
 	var c *client.Client
-	var err error
 	switch cfg.Type {
 	case models.MCPTypeLocal:
 		if len(cfg.Command) == 0 {
@@ -316,6 +323,7 @@ func (s *MCPService) GetServerConfig(name string) (*models.MCPServerConfig, erro
 }
 
 // SetServer adds or updates a server in the config file and optionally runs a check if enabled.
+// Secrets are encrypted before writing; masked values echoed back by clients keep the stored secret.
 func (s *MCPService) SetServer(name string, cfg models.MCPServerConfig) error {
 	f, err := s.LoadFile()
 	if err != nil {
@@ -324,6 +332,10 @@ func (s *MCPService) SetServer(name string, cfg models.MCPServerConfig) error {
 	if f.Servers == nil {
 		f.Servers = make(map[string]models.MCPServerConfig)
 	}
+	cfg, err = encryptMCPConfig(cfg, f.Servers[name])
+	if err != nil {
+		return err
+	}
 	f.Servers[name] = cfg
 	if err := s.SaveFile(f); err != nil {
 		return err
@@ -362,3 +374,67 @@ func (s *MCPService) DeleteServer(name string) error {
 	s.mu.Unlock()
 	return nil
 }
+
+// DecryptMCPConfig returns a copy of cfg with api_key, env and header values decrypted.
+func DecryptMCPConfig(cfg models.MCPServerConfig) (models.MCPServerConfig, error) {
+	return transformMCPSecrets(cfg, func(value, _ string) (string, error) {
+		return secrets.Decrypt(value)
+	}, models.MCPServerConfig{})
+}
+
+// MaskMCPConfig returns a copy of cfg safe to send to clients.
+func MaskMCPConfig(cfg models.MCPServerConfig) models.MCPServerConfig {
+	out, _ := transformMCPSecrets(cfg, func(value, _ string) (string, error) {
+		return secrets.Mask(value), nil
+	}, models.MCPServerConfig{})
+	return out
+}
+
+// ErrMaskedSecret is returned when a client sends a masked value that does not match the
+// stored secret, or when no secret is stored.
+var ErrMaskedSecret = errors.New("masked value given but no matching secret is stored")
+
+// encryptMCPConfig encrypts secrets in cfg, reusing values from existing when the client sent a mask.
+func encryptMCPConfig(cfg, existing models.MCPServerConfig) (models.MCPServerConfig, error) {
+	return transformMCPSecrets(cfg, func(value, previous string) (string, error) {
+		if secrets.IsMasked(value, previous) {
+			return previous, nil
+		}
+		if secrets.LooksMasked(value) {
+			return "", ErrMaskedSecret
+		}
+		return secrets.Encrypt(value)
+	}, existing)
+}
+
+// transformMCPSecrets applies fn to every secret-bearing field; previous holds the matching
+// value from prev (if any) so callers can preserve unchanged secrets.
+func transformMCPSecrets(cfg models.MCPServerConfig, fn func(value, previous string) (string, error), prev models.MCPServerConfig) (models.MCPServerConfig, error) {
+	var err error
+	if cfg.ApiKey != "" {
+		if cfg.ApiKey, err = fn(cfg.ApiKey, prev.ApiKey); err != nil {
+			return cfg, fmt.Errorf("api_key: %w", err)
+		}
+	}
+	transformMap := func(field string, in, previous map[string]string) (map[string]string, error) {
+		if in == nil {
+			return nil, nil
+		}
+		out := make(map[string]string, len(in))
+		for k, v := range in {
+			nv, err := fn(v, previous[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", field, k, err)
+			}
+			out[k] = nv
+		}
+		return out, nil
+	}
+	if cfg.Env, err = transformMap("env", cfg.Env, prev.Env); err != nil {
+		return cfg, err
+	}
+	if cfg.Headers, err = transformMap("header", cfg.Headers, prev.Headers); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,262 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"fnchatbot/internal/models"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Tree is the message tree of a session. Regenerated replies and edited prompts start
+// new branches next to the messages they replace; the active branch ends at the most
+// recently activated message. Rolling summaries are not part of the tree.
+type Tree struct {
+	parents  map[uint]uint
+	seqs     map[uint]int64
+	children map[uint][]uint // parent ID -> children in creation order; 0 holds the roots
+	leaf     uint
+}
+
+func newTree(msgs []models.Message) *Tree {
+	t := &Tree{
+		parents:  make(map[uint]uint),
+		seqs:     make(map[uint]int64),
+		children: make(map[uint][]uint),
+	}
+	var best int64
+	for _, m := range msgs {
+		if m.BranchSeq == 0 {
+			continue
+		}
+		var parent uint
+		if m.ParentID != nil {
+			parent = *m.ParentID
+		}
+		t.parents[m.ID] = parent
+		t.seqs[m.ID] = m.BranchSeq
+		t.children[parent] = append(t.children[parent], m.ID)
+		if m.BranchSeq > best || (m.BranchSeq == best && m.ID > t.leaf) {
+			best, t.leaf = m.BranchSeq, m.ID
+		}
+	}
+	for _, ids := range t.children {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return t
+}
+
+// Leaf returns the last message of the active branch, or 0 for an empty conversation.
+func (t *Tree) Leaf() uint {
+	return t.leaf
+}
+
+// Has reports whether id is a message of the tree.
+func (t *Tree) Has(id uint) bool {
+	_, ok := t.parents[id]
+	return ok
+}
+
+// Parent returns the parent of id, or 0 for a root message.
+func (t *Tree) Parent(id uint) uint {
+	return t.parents[id]
+}
+
+// Siblings returns id and its siblings in creation order.
+func (t *Tree) Siblings(id uint) []uint {
+	if !t.Has(id) {
+		return nil
+	}
+	return t.children[t.parents[id]]
+}
+
+// LatestLeaf returns the most recently active message in the subtree of id, which is
+// where the branch through id was left.
+func (t *Tree) LatestLeaf(id uint) uint {
+	best := id
+	stack := []uint{id}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if t.seqs[n] > t.seqs[best] {
+			best = n
+		}
+		stack = append(stack, t.children[n]...)
+	}
+	return best
+}
+
+// Path returns the message IDs from the root down to id.
+func (t *Tree) Path(id uint) []uint {
+	var path []uint
+	for n := id; n != 0 && t.Has(n) && len(path) <= len(t.parents); n = t.parents[n] {
+		path = append(path, n)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Tree loads the message tree of the session.
+func (h *SQLiteHistory) Tree(ctx context.Context) (*Tree, error) {
+	if err := h.upgrade(); err != nil {
+		return nil, err
+	}
+	var msgs []models.Message
+	if err := h.DB.Select("id", "parent_id", "branch_seq").Where("session_id = ? AND branch_seq > 0", h.SessionID).Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	return newTree(msgs), nil
+}
+
+// TreeMessages returns every message of the tree with its parts, oldest first, along
+// with the tree. Rolling summaries are left out.
+func (h *SQLiteHistory) TreeMessages(ctx context.Context) ([]models.Message, *Tree, error) {
+	all, err := h.allMessages()
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs := make([]models.Message, 0, len(all))
+	for _, m := range all {
+		if m.BranchSeq > 0 {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, newTree(msgs), nil
+}
+
+// Activate switches the session to the branch through messageID, continuing where that
+// branch was last left.
+func (h *SQLiteHistory) Activate(ctx context.Context, messageID uint) error {
+	tree, err := h.Tree(ctx)
+	if err != nil {
+		return err
+	}
+	if !tree.Has(messageID) {
+		return fmt.Errorf("message %d is not part of this conversation", messageID)
+	}
+	return h.setLeaf(tree.LatestLeaf(messageID))
+}
+
+func (h *SQLiteHistory) setLeaf(id uint) error {
+	return h.activate(h.DB, id)
+}
+
+// activate gives message id the next branch sequence number of the session. The number
+// is computed by the UPDATE itself, so concurrent activations never share one.
+func (h *SQLiteHistory) activate(tx *gorm.DB, id uint) error {
+	next := gorm.Expr("(SELECT COALESCE(MAX(branch_seq), 0) + 1 FROM messages WHERE session_id = ?)", h.SessionID)
+	return tx.Model(&models.Message{}).Where("id = ?", id).Update("branch_seq", next).Error
+}
+
+// leaf returns the last message of the active branch, or 0.
+func (h *SQLiteHistory) leaf() (uint, error) {
+	var msgs []models.Message
+	err := h.DB.Select("id").Where("session_id = ? AND branch_seq > 0", h.SessionID).Order("branch_seq desc, id desc").Limit(1).Find(&msgs).Error
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+	return msgs[0].ID, nil
+}
+
+// upgrade links the messages of a conversation saved before conversations were trees
+// into a single branch, and records which message each rolling summary ends at.
+func (h *SQLiteHistory) upgrade() error {
+	var legacy int64
+	if err := h.DB.Model(&models.Message{}).Where("session_id = ? AND branch_seq = 0 AND role <> ?", h.SessionID, models.RoleSystem).Count(&legacy).Error; err != nil {
+		return err
+	}
+	if legacy == 0 {
+		return nil
+	}
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		var msgs []models.Message
+		if err := tx.Where("session_id = ?", h.SessionID).Preload("Parts").Order("created_at asc, id asc").Find(&msgs).Error; err != nil {
+			return err
+		}
+		var seq int64
+		var prev *uint
+		for _, m := range msgs {
+			// Summaries were stored right after the last message they cover
+			if isSummary(m) {
+				if prev == nil || summaryMeta(m).UpTo != 0 {
+					continue
+				}
+				meta, _ := json.Marshal(models.SummaryPartMeta{ContextSummary: true, UpTo: *prev})
+				if err := tx.Model(&models.Part{}).Where("id = ?", m.Parts[0].ID).Update("meta", datatypes.JSON(meta)).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			seq++
+			if err := tx.Model(&models.Message{}).Where("id = ?", m.ID).Updates(map[string]interface{}{"parent_id": prev, "branch_seq": seq}).Error; err != nil {
+				return err
+			}
+			id := m.ID
+			prev = &id
+		}
+		return nil
+	})
+}
+
+func summaryMeta(msg models.Message) models.SummaryPartMeta {
+	var meta models.SummaryPartMeta
+	_ = json.Unmarshal(msg.Parts[0].Meta, &meta)
+	return meta
+}
+
+// activePath returns the messages of the branch ending at h.Head (the active branch
+// when Head is 0), preceded by the rolling summary that covers the start of the branch,
+// if any. Only the messages on the branch are loaded.
+func (h *SQLiteHistory) activePath(ctx context.Context) (summary *models.Message, path []models.Message, err error) {
+	tree, err := h.Tree(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	head := h.Head
+	if head == 0 {
+		head = tree.Leaf()
+	}
+	ids := tree.Path(head)
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+	var msgs []models.Message
+	if err := h.DB.Where("id IN ?", ids).Preload("Parts").Find(&msgs).Error; err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[uint]models.Message, len(msgs))
+	for _, m := range msgs {
+		byID[m.ID] = m
+	}
+	pos := make(map[uint]int, len(ids))
+	for i, id := range ids {
+		pos[id] = i
+	}
+
+	// The summary reaching furthest into the branch stands in for everything before it
+	var summaries []models.Message
+	if err := h.DB.Where("session_id = ? AND role = ? AND branch_seq = 0", h.SessionID, models.RoleSystem).
+		Preload("Parts").Order("created_at asc, id asc").Find(&summaries).Error; err != nil {
+		return nil, nil, err
+	}
+	start := 0
+	for _, m := range summaries {
+		if !isSummary(m) {
+			continue
+		}
+		if p, ok := pos[summaryMeta(m).UpTo]; ok && (summary == nil || p+1 >= start) {
+			m := m
+			summary, start = &m, p+1
+		}
+	}
+	for _, id := range ids[start:] {
+		path = append(path, byID[id])
+	}
+	return summary, path, nil
+}
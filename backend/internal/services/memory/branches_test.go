@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func contents(msgs []llms.ChatMessage) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.GetContent()
+	}
+	return out
+}
+
+func TestBranches_RegenerateEditAndSwitch(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+	ctx := context.Background()
+	h := NewSQLiteHistory(db, 1)
+	h.Files = nil
+
+	q1, _ := h.SaveMessage(ctx, llms.HumanChatMessage{Content: "q1"})
+	a1, _ := h.SaveMessage(ctx, llms.AIChatMessage{Content: "a1"})
+	q2, _ := h.SaveMessage(ctx, llms.HumanChatMessage{Content: "q2"})
+	_, _ = h.SaveMessage(ctx, llms.AIChatMessage{Content: "a2"})
+	assert.Equal(t, a1.ID, *q2.ParentID)
+
+	// Regenerate the answer to q2
+	_, err = h.SaveMessageUnder(ctx, q2.ID, llms.AIChatMessage{Content: "a2 retry"})
+	require.NoError(t, err)
+	msgs, err := h.Messages(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q1", "a1", "q2", "a2 retry"}, contents(msgs))
+
+	// Edit q2: the new prompt is a sibling of q2
+	q2b, err := h.SaveMessageUnder(ctx, a1.ID, llms.HumanChatMessage{Content: "q2 edited"})
+	require.NoError(t, err)
+	_, _ = h.SaveMessage(ctx, llms.AIChatMessage{Content: "a2 edited"})
+	msgs, _ = h.Messages(ctx)
+	assert.Equal(t, []string{"q1", "a1", "q2 edited", "a2 edited"}, contents(msgs))
+
+	tree, err := h.Tree(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{q2.ID, q2b.ID}, tree.Siblings(q2b.ID))
+	assert.Equal(t, []uint{q1.ID}, tree.Siblings(q1.ID))
+
+	// Switching back to q2 continues with its latest answer
+	require.NoError(t, h.Activate(ctx, q2.ID))
+	msgs, _ = h.Messages(ctx)
+	assert.Equal(t, []string{"q1", "a1", "q2", "a2 retry"}, contents(msgs))
+
+	assert.Error(t, h.Activate(ctx, 999))
+}
+
+func TestBranches_HeadKeepsOldReplyUntilSaved(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+	ctx := context.Background()
+	h := NewSQLiteHistory(db, 1)
+	h.Files = nil
+
+	q1, _ := h.SaveMessage(ctx, llms.HumanChatMessage{Content: "q1"})
+	a1, _ := h.SaveMessage(ctx, llms.AIChatMessage{Content: "a1"})
+
+	// A regenerated reply reads up to its prompt; the old reply stays active meanwhile
+	regen := NewSQLiteHistory(db, 1)
+	regen.Files = nil
+	regen.Head = q1.ID
+	msgs, err := regen.Messages(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q1"}, contents(msgs))
+	msgs, _ = h.Messages(ctx)
+	assert.Equal(t, []string{"q1", "a1"}, contents(msgs))
+
+	a1b, err := regen.SaveMessage(ctx, llms.AIChatMessage{Content: "a1 retry"})
+	require.NoError(t, err)
+	assert.Equal(t, a1b.ID, regen.Head)
+	msgs, _ = h.Messages(ctx)
+	assert.Equal(t, []string{"q1", "a1 retry"}, contents(msgs))
+
+	tree, err := h.Tree(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{a1.ID, a1b.ID}, tree.Siblings(a1b.ID))
+}
+
+func TestBranches_UpgradeLinearHistory(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+	ctx := context.Background()
+
+	// Rows as stored before conversations were trees
+	base := time.Now().Add(-time.Hour)
+	for i, text := range []string{"q1", "a1", "q2"} {
+		role := models.RoleUser
+		if i == 1 {
+			role = models.RoleAssistant
+		}
+		msg := models.Message{SessionID: 1, Role: role, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		require.NoError(t, db.Create(&msg).Error)
+		require.NoError(t, db.Create(&models.Part{MessageID: msg.ID, Type: models.PartTypeText, Content: text}).Error)
+	}
+	meta, _ := json.Marshal(models.SummaryPartMeta{ContextSummary: true})
+	summary := models.Message{SessionID: 1, Role: models.RoleSystem, CreatedAt: base.Add(time.Minute)}
+	require.NoError(t, db.Create(&summary).Error)
+	require.NoError(t, db.Create(&models.Part{MessageID: summary.ID, Type: models.PartTypeText, Content: "summary", Meta: datatypes.JSON(meta)}).Error)
+
+	h := NewSQLiteHistory(db, 1)
+	msgs, err := h.Messages(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"summary", "q2"}, contents(msgs))
+
+	saved, err := h.SaveMessage(ctx, llms.AIChatMessage{Content: "a2"})
+	require.NoError(t, err)
+	tree, _ := h.Tree(ctx)
+	assert.Len(t, tree.Path(saved.ID), 4)
+}
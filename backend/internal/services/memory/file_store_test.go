@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/storage"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+func TestImageStorage_FileStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+
+	store, err := storage.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	history := NewSQLiteHistory(db, 1)
+	history.Files = store
+
+	raw := []byte("not really a png")
+	imageURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(raw)
+	assert.NoError(t, history.AddMessage(ctx, MultiModalMessage{
+		Type:    llms.ChatMessageTypeHuman,
+		Content: "look",
+		Parts:   []llms.ContentPart{llms.TextPart("look"), llms.ImageURLPart(imageURL)},
+	}))
+
+	// The database holds the hash, not the bytes.
+	var part models.Part
+	assert.NoError(t, db.Where("type = ?", models.PartTypeFile).First(&part).Error)
+	assert.Equal(t, storage.HashOf(raw), part.Content)
+	var meta models.FilePartMeta
+	assert.NoError(t, json.Unmarshal(part.Meta, &meta))
+	assert.Equal(t, part.Content, meta.Hash)
+	assert.Equal(t, int64(len(raw)), meta.Size)
+	assert.Equal(t, "/api/files/"+meta.Hash, meta.URL)
+
+	// Reading history restores the data URL for the model.
+	messages, err := history.Messages(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	mm, ok := messages[0].(MultiModalMessage)
+	assert.True(t, ok)
+	assert.Len(t, mm.Parts, 2)
+	assert.Equal(t, imageURL, mm.Parts[1].(llms.ImageURLContent).URL)
+}
+
+func TestDocumentAttachment(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+
+	store, err := storage.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	history := NewSQLiteHistory(db, 1)
+	history.Files = store
+
+	raw := []byte("%PDF-1.4 fake")
+	assert.NoError(t, history.AddMessage(ctx, MultiModalMessage{
+		Type:    llms.ChatMessageTypeHuman,
+		Content: "what is this?",
+		Attachments: []Attachment{
+			{Filename: "a.pdf", Mime: "application/pdf", Data: raw, Text: "hello", Pages: 1},
+			{Filename: "b.csv", Mime: "text/csv", Data: []byte("x,y"), Text: "x,y"},
+		},
+	}))
+
+	messages, err := history.Messages(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	mm := messages[0].(MultiModalMessage)
+	assert.Len(t, mm.Parts, 4)
+	assert.Equal(t, llms.TextPart("what is this?"), mm.Parts[0])
+	assert.Equal(t, llms.BinaryPart("application/pdf", raw), mm.Parts[1])
+	assert.Equal(t, "[Attachment: a.pdf, 1 pages]\nhello\n[End of attachment: a.pdf]", mm.Parts[2].(llms.TextContent).Text)
+	assert.Contains(t, mm.Parts[3].(llms.TextContent).Text, "x,y")
+}
+
+func TestGeneratedImagesOnToolResult(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+
+	store, err := storage.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	history := NewSQLiteHistory(db, 1)
+	history.Files = store
+
+	saved, err := history.SaveMessage(ctx, MultiModalMessage{
+		Type:        llms.ChatMessageTypeTool,
+		Content:     "Generated 1 image(s)",
+		ToolCallID:  "call_1",
+		Attachments: []Attachment{{Filename: "image-1.png", Mime: "image/png", Data: []byte("png")}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, saved.Parts, 2)
+
+	// The stored image is only for display; the model sees the plain tool result.
+	messages, err := history.Messages(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, llms.ToolChatMessage{ID: "call_1", Content: "Generated 1 image(s)"}, messages[0])
+}
@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+func TestReasoningPart(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+
+	ctx := context.Background()
+	history := NewSQLiteHistory(db, 1)
+	saved, err := history.SaveMessage(ctx, llms.AIChatMessage{Content: "42", ReasoningContent: "6 times 7"})
+	assert.NoError(t, err)
+	assert.Len(t, saved.Parts, 2)
+	assert.Equal(t, models.PartTypeReasoning, saved.Parts[0].Type)
+	assert.Equal(t, "6 times 7", saved.Parts[0].Content)
+
+	// Reasoning is kept for display but not sent back to the model
+	messages, err := history.Messages(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []llms.ChatMessage{llms.AIChatMessage{Content: "42"}}, messages)
+}
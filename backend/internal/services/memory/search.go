@@ -0,0 +1,273 @@
+package memory
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"fnchatbot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// searchTable is the FTS5 index of the text parts of user and assistant messages. Its
+// rowid is the part ID. The trigram tokenizer matches substrings, which also works for
+// languages written without spaces, but only for terms of at least three characters;
+// shorter terms fall back to LIKE over the same table.
+const searchTable = "message_search"
+
+const minMatchRunes = 3
+
+// searchIndexed is set once InitSearchIndex has prepared the index; histories created
+// afterwards keep it up to date.
+var searchIndexed atomic.Bool
+
+// InitSearchIndex creates the full-text index if needed, filling it from the existing
+// messages, and turns on indexing for new messages.
+func InitSearchIndex(db *gorm.DB) error {
+	if err := ensureSearchIndex(db); err != nil {
+		return err
+	}
+	searchIndexed.Store(true)
+	return nil
+}
+
+func ensureSearchIndex(db *gorm.DB) error {
+	var exists int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", searchTable).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE VIRTUAL TABLE " + searchTable + " USING fts5(content, message_id UNINDEXED, tokenize = 'trigram')").Error; err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+		return tx.Exec("INSERT INTO "+searchTable+" (rowid, content, message_id) "+
+			"SELECT p.id, p.content, p.message_id FROM parts p JOIN messages m ON m.id = p.message_id "+
+			"WHERE p.type = ? AND m.role IN ?", models.PartTypeText, searchableRoles).Error
+	})
+}
+
+// searchableRoles are the roles whose text is indexed; system messages hold prompts and
+// rolling summaries.
+var searchableRoles = []models.MessageRole{models.RoleUser, models.RoleAssistant}
+
+// indexParts adds the text parts of a saved message to the search index.
+func (h *SQLiteHistory) indexParts(msg models.Message) error {
+	if !h.Index || (msg.Role != models.RoleUser && msg.Role != models.RoleAssistant) {
+		return nil
+	}
+	for _, p := range msg.Parts {
+		if p.Type != models.PartTypeText || p.Content == "" {
+			continue
+		}
+		if err := h.DB.Exec("INSERT INTO "+searchTable+" (rowid, content, message_id) VALUES (?, ?, ?)", p.ID, p.Content, msg.ID).Error; err != nil {
+			return fmt.Errorf("failed to index message: %w", err)
+		}
+	}
+	return nil
+}
+
+// unindexSession removes the messages of the session from the search index.
+func (h *SQLiteHistory) unindexSession() error {
+	if !h.Index {
+		return nil
+	}
+	return h.DB.Exec("DELETE FROM "+searchTable+" WHERE message_id IN (SELECT id FROM messages WHERE session_id = ?)", h.SessionID).Error
+}
+
+// SearchQuery selects messages by keywords. All terms of Text must occur in a message.
+type SearchQuery struct {
+	Text string
+	// UserID restricts the search to one user's conversations; 0 searches every user
+	UserID   uint
+	Role     models.MessageRole
+	ModelID  uint // model config of the conversation
+	From     time.Time
+	To       time.Time
+	Page     int // 1-based
+	PageSize int
+}
+
+// SearchHit is a message matching a search, with an excerpt around the first match.
+// Matches in Snippet are wrapped in <mark> tags; the rest of the text is HTML-escaped.
+type SearchHit struct {
+	MessageID    uint               `json:"message_id"`
+	SessionID    uint               `json:"session_id"`
+	SessionTitle string             `json:"session_title"`
+	UserID       uint               `json:"user_id"`
+	ModelID      uint               `json:"model_id"`
+	Role         models.MessageRole `json:"role"`
+	Snippet      string             `json:"snippet"`
+	CreatedAt    time.Time          `json:"created_at"`
+}
+
+// SearchResult is one page of hits.
+type SearchResult struct {
+	Hits     []SearchHit `json:"results"`
+	Total    int64       `json:"total"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+}
+
+// Search finds messages of every branch of the selected conversations, best matches
+// first. Messages of deleted conversations are not returned.
+func Search(db *gorm.DB, q SearchQuery) (*SearchResult, error) {
+	terms := strings.Fields(q.Text)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search query is empty")
+	}
+
+	var match []string
+	where := []string{"1 = 1"}
+	var args []interface{}
+	for _, t := range terms {
+		if utf8.RuneCountInString(t) >= minMatchRunes {
+			match = append(match, `"`+strings.ReplaceAll(t, `"`, `""`)+`"`)
+			continue
+		}
+		where = append(where, `s.content LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+EscapeLike(t)+"%")
+	}
+	if len(match) > 0 {
+		where = append(where, searchTable+" MATCH ?")
+		args = append(args, strings.Join(match, " "))
+	}
+	if q.UserID != 0 {
+		where = append(where, "ss.user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if q.Role != "" {
+		where = append(where, "m.role = ?")
+		args = append(args, q.Role)
+	}
+	if q.ModelID != 0 {
+		where = append(where, "ss.model_id = ?")
+		args = append(args, q.ModelID)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "m.created_at >= ?")
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		where = append(where, "m.created_at < ?")
+		args = append(args, q.To)
+	}
+
+	from := " FROM " + searchTable + " s JOIN messages m ON m.id = s.message_id JOIN sessions ss ON ss.id = m.session_id WHERE " + strings.Join(where, " AND ")
+	res := &SearchResult{Page: q.Page, PageSize: q.PageSize, Hits: []SearchHit{}}
+	if err := db.Raw("SELECT COUNT(DISTINCT s.message_id)"+from, args...).Scan(&res.Total).Error; err != nil {
+		return nil, err
+	}
+
+	// A message with several text parts is returned once, for its best part
+	order := "MAX(m.created_at) DESC"
+	if len(match) > 0 {
+		order = "MIN(s.rank), MAX(m.created_at) DESC"
+	}
+	var rows []struct {
+		SearchHit
+		Content string
+	}
+	err := db.Raw("SELECT s.message_id, m.session_id, ss.title AS session_title, ss.user_id, ss.model_id, m.role, m.created_at, s.content"+
+		from+" GROUP BY s.message_id ORDER BY "+order+" LIMIT ? OFFSET ?",
+		append(args, q.PageSize, (q.Page-1)*q.PageSize)...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		hit := r.SearchHit
+		hit.Snippet = Snippet(r.Content, terms, snippetRunes)
+		res.Hits = append(res.Hits, hit)
+	}
+	return res, nil
+}
+
+// EscapeLike escapes the LIKE wildcards in s for a pattern used with ESCAPE '\'.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// snippetRunes is the length of search excerpts.
+const snippetRunes = 160
+
+// Snippet returns about size characters of text around the first occurrence of any of
+// terms, HTML-escaped, with every occurrence wrapped in <mark> tags. Terms are matched
+// case-insensitively.
+func Snippet(text string, terms []string, size int) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// Lowercasing changed the length; fall back to an exact match
+		lower = runes
+	}
+	var needles [][]rune
+	for _, t := range terms {
+		if t != "" {
+			needles = append(needles, []rune(strings.ToLower(t)))
+		}
+	}
+
+	// marked[i] is true for runes inside a match
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, n := range needles {
+		for i := 0; i+len(n) <= len(lower); i++ {
+			if !hasRunes(lower[i:], n) {
+				continue
+			}
+			for j := i; j < i+len(n); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if len(runes) > size {
+		if first > size/4 {
+			start = first - size/4
+		}
+		end = start + size
+		if end > len(runes) {
+			end, start = len(runes), len(runes)-size
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			b.WriteString("<mark>")
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			b.WriteString("</mark>")
+		}
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func hasRunes(s, prefix []rune) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i, r := range prefix {
+		if s[i] != r {
+			return false
+		}
+	}
+	return true
+}
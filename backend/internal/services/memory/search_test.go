@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+func hitIDs(res *SearchResult) []uint {
+	ids := make([]uint, len(res.Hits))
+	for i, h := range res.Hits {
+		ids[i] = h.MessageID
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Session{}, &models.Message{}, &models.Part{}))
+	require.NoError(t, db.Create(&models.Session{ID: 1, Title: "mine", UserID: 1, ModelID: 7}).Error)
+	require.NoError(t, db.Create(&models.Session{ID: 2, Title: "theirs", UserID: 2, ModelID: 8}).Error)
+	ctx := context.Background()
+
+	// Saved before the index existed: picked up when it is created
+	old := NewSQLiteHistory(db, 1)
+	old.Files = nil
+	legacy, err := old.SaveMessage(ctx, llms.HumanChatMessage{Content: "How do I configure the Docker sandbox?"})
+	require.NoError(t, err)
+
+	require.NoError(t, ensureSearchIndex(db))
+	h := NewSQLiteHistory(db, 1)
+	h.Files, h.Index = nil, true
+	answer, err := h.SaveMessage(ctx, llms.AIChatMessage{Content: "Open settings and enable the docker sandbox <here>."})
+	require.NoError(t, err)
+	cjk, err := h.SaveMessage(ctx, llms.HumanChatMessage{Content: "你好世界，今天天气怎么样"})
+	require.NoError(t, err)
+	_, err = h.SaveMessage(ctx, llms.SystemChatMessage{Content: "docker sandbox summary"})
+	require.NoError(t, err)
+
+	other := NewSQLiteHistory(db, 2)
+	other.Files, other.Index = nil, true
+	theirs, err := other.SaveMessage(ctx, llms.HumanChatMessage{Content: "docker sandbox for someone else"})
+	require.NoError(t, err)
+
+	search := func(q SearchQuery) *SearchResult {
+		t.Helper()
+		if q.Page == 0 {
+			q.Page, q.PageSize = 1, 10
+		}
+		res, err := Search(db, q)
+		require.NoError(t, err)
+		return res
+	}
+
+	res := search(SearchQuery{Text: "docker SANDBOX", UserID: 1})
+	assert.ElementsMatch(t, []uint{legacy.ID, answer.ID}, hitIDs(res))
+	assert.EqualValues(t, 2, res.Total)
+
+	res = search(SearchQuery{Text: "docker", UserID: 1, Role: models.RoleAssistant})
+	require.Equal(t, []uint{answer.ID}, hitIDs(res))
+	assert.Equal(t, "mine", res.Hits[0].SessionTitle)
+	assert.Equal(t, "Open settings and enable the <mark>docker</mark> sandbox &lt;here&gt;.", res.Hits[0].Snippet)
+
+	// Admins search across users, optionally by model
+	assert.ElementsMatch(t, []uint{legacy.ID, answer.ID, theirs.ID}, hitIDs(search(SearchQuery{Text: "sandbox"})))
+	assert.Equal(t, []uint{theirs.ID}, hitIDs(search(SearchQuery{Text: "sandbox", ModelID: 8})))
+
+	// Terms shorter than a trigram still match
+	res = search(SearchQuery{Text: "世界", UserID: 1})
+	require.Equal(t, []uint{cjk.ID}, hitIDs(res))
+	assert.Equal(t, "你好<mark>世界</mark>，今天天气怎么样", res.Hits[0].Snippet)
+	assert.Empty(t, hitIDs(search(SearchQuery{Text: "世界", UserID: 2})))
+
+	// Pagination
+	page := search(SearchQuery{Text: "sandbox", Page: 2, PageSize: 2})
+	assert.Len(t, page.Hits, 1)
+	assert.EqualValues(t, 3, page.Total)
+
+	// Clearing a conversation removes it from the index
+	require.NoError(t, h.Clear(ctx))
+	assert.Empty(t, hitIDs(search(SearchQuery{Text: "sandbox", UserID: 1})))
+}
+
+func TestSnippet(t *testing.T) {
+	text := "aaaa bbbb cccc needle dddd eeee"
+	assert.Equal(t, "…ccc <mark>needle</mark> dddd e…", Snippet(text, []string{"NEEDLE"}, 17))
+	assert.Equal(t, "aaaa bbbb", Snippet("aaaa bbbb", []string{"zzz"}, 20))
+}
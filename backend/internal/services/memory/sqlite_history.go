@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"fnchatbot/internal/models"
+	"fnchatbot/internal/storage"
 
 	"github.com/tmc/langchaingo/llms"
 	"gorm.io/datatypes"
@@ -18,6 +19,14 @@ import (
 type SQLiteHistory struct {
 	DB        *gorm.DB
 	SessionID uint
+	// Files stores attachment bytes outside the database; nil keeps them inline as base64
+	Files *storage.FileStore
+	// Index keeps the full-text search index up to date; see InitSearchIndex
+	Index bool
+	// Head, when set, is read and written as the end of the branch instead of the
+	// active leaf, and follows each saved message. Regenerating a reply reads up to its
+	// prompt this way while the old reply stays active until the new one is saved.
+	Head uint
 }
 
 // NewSQLiteHistory creates a new SQLiteHistory
@@ -25,11 +34,41 @@ func NewSQLiteHistory(db *gorm.DB, sessionID uint) *SQLiteHistory {
 	return &SQLiteHistory{
 		DB:        db,
 		SessionID: sessionID,
+		Files:     storage.Default(),
+		Index:     searchIndexed.Load(),
 	}
 }
 
 // AddMessage adds a message to the history
 func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage) error {
+	_, err := h.SaveMessage(ctx, message)
+	return err
+}
+
+// SaveMessage stores a message with its parts at the end of the active branch and
+// returns the stored row, so callers can attach per-message records such as token usage.
+func (h *SQLiteHistory) SaveMessage(ctx context.Context, message llms.ChatMessage) (*models.Message, error) {
+	if err := h.upgrade(); err != nil {
+		return nil, err
+	}
+	leaf := h.Head
+	if leaf == 0 {
+		var err error
+		if leaf, err = h.leaf(); err != nil {
+			return nil, err
+		}
+	}
+	saved, err := h.SaveMessageUnder(ctx, leaf, message)
+	if err == nil && h.Head != 0 {
+		h.Head = saved.ID
+	}
+	return saved, err
+}
+
+// SaveMessageUnder stores a message as a child of parentID (0 for a new first message)
+// and makes it the end of the active branch. Saving an edited prompt next to the
+// original starts a new branch.
+func (h *SQLiteHistory) SaveMessageUnder(ctx context.Context, parentID uint, message llms.ChatMessage) (*models.Message, error) {
 	var role models.MessageRole
 	switch message.GetType() {
 	case llms.ChatMessageTypeAI:
@@ -44,13 +83,27 @@ func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage
 		role = "tool"
 	}
 
+	// Created with a placeholder sequence and activated in the same transaction, so
+	// the message is never visible as a pre-tree message
 	msg := models.Message{
 		SessionID: h.SessionID,
 		Role:      role,
+		BranchSeq: 1,
 	}
-
-	if err := h.DB.Create(&msg).Error; err != nil {
-		return err
+	if parentID != 0 {
+		msg.ParentID = &parentID
+	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&msg).Error; err != nil {
+			return err
+		}
+		if err := h.activate(tx, msg.ID); err != nil {
+			return err
+		}
+		return tx.Model(&models.Message{}).Select("branch_seq").Where("id = ?", msg.ID).Scan(&msg.BranchSeq).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var parts []models.Part
@@ -70,6 +123,11 @@ func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage
 		})
 	}
 
+	// Reasoning comes first so history renders the thinking above the answer
+	if aiMsg, ok := message.(llms.AIChatMessage); ok && aiMsg.ReasoningContent != "" {
+		addPart(models.PartTypeReasoning, aiMsg.ReasoningContent, nil)
+	}
+
 	// Iterate message parts if available
 	var messageParts []llms.ContentPart
 	switch m := message.(type) {
@@ -88,7 +146,6 @@ func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage
 			case llms.ImageURLContent:
 				content := part.URL
 				mime := "image/jpeg"
-				filename := "image.jpg"
 
 				// Check for data URL
 				if strings.HasPrefix(content, "data:") {
@@ -103,17 +160,27 @@ func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage
 					}
 				}
 
-				addPart(models.PartTypeFile, content, models.FilePartMeta{
+				meta := models.FilePartMeta{
 					Mime:     mime,
-					Filename: filename,
-				})
+					Filename: "image.jpg",
+				}
+				if data, err := base64.StdEncoding.DecodeString(content); err == nil && !strings.HasPrefix(part.URL, "http") {
+					if content, err = h.storeFile(data, &meta); err != nil {
+						return nil, err
+					}
+				}
+				addPart(models.PartTypeFile, content, meta)
 				hasContent = true
 			case llms.BinaryContent:
-				encoded := base64.StdEncoding.EncodeToString(part.Data)
-				addPart(models.PartTypeFile, encoded, models.FilePartMeta{
+				meta := models.FilePartMeta{
 					Mime:     part.MIMEType,
 					Filename: "file.bin",
-				})
+				}
+				content, err := h.storeFile(part.Data, &meta)
+				if err != nil {
+					return nil, err
+				}
+				addPart(models.PartTypeFile, content, meta)
 				hasContent = true
 			}
 		}
@@ -126,10 +193,17 @@ func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage
 			var meta interface{}
 
 			// Handle ToolCallID for Tool messages
-			if toolMsg, ok := message.(llms.ToolChatMessage); ok && toolMsg.ID != "" {
+			toolCallID := ""
+			switch m := message.(type) {
+			case llms.ToolChatMessage:
+				toolCallID = m.ID
+			case MultiModalMessage:
+				toolCallID = m.ToolCallID
+			}
+			if toolCallID != "" {
 				partType = models.PartTypeToolResult
 				meta = map[string]interface{}{
-					"tool_call_id": toolMsg.ID,
+					"tool_call_id": toolCallID,
 				}
 			}
 
@@ -137,6 +211,24 @@ func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage
 		}
 	}
 
+	// Documents follow the message text
+	if m, ok := message.(MultiModalMessage); ok {
+		for _, att := range m.Attachments {
+			meta := models.FilePartMeta{
+				Mime:      att.Mime,
+				Filename:  att.Filename,
+				Text:      att.Text,
+				Pages:     att.Pages,
+				Truncated: att.Truncated,
+			}
+			content, err := h.storeFile(att.Data, &meta)
+			if err != nil {
+				return nil, err
+			}
+			addPart(models.PartTypeFile, content, meta)
+		}
+	}
+
 	// Handle ToolCalls for AI messages
 	if aiMsg, ok := message.(llms.AIChatMessage); ok && len(aiMsg.ToolCalls) > 0 {
 		toolCallsJSON, err := json.Marshal(aiMsg.ToolCalls)
@@ -146,9 +238,15 @@ func (h *SQLiteHistory) AddMessage(ctx context.Context, message llms.ChatMessage
 	}
 
 	if len(parts) > 0 {
-		return h.DB.Create(&parts).Error
+		if err := h.DB.Create(&parts).Error; err != nil {
+			return nil, err
+		}
 	}
-	return nil
+	msg.Parts = parts
+	if err := h.indexParts(msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
 }
 
 // AddUserMessage adds a user message to the history
@@ -163,18 +261,30 @@ func (h *SQLiteHistory) AddAIMessage(ctx context.Context, message string) error
 
 // Clear clears the history
 func (h *SQLiteHistory) Clear(ctx context.Context) error {
+	if err := h.unindexSession(); err != nil {
+		return err
+	}
 	return h.DB.Where("session_id = ?", h.SessionID).Delete(&models.Message{}).Error
 }
 
-// Messages retrieves all messages from the history
+// Messages retrieves the messages of the active branch
 func (h *SQLiteHistory) Messages(ctx context.Context) ([]llms.ChatMessage, error) {
-	var dbMessages []models.Message
-	if err := h.DB.Where("session_id = ?", h.SessionID).Preload("Parts").Order("created_at asc").Find(&dbMessages).Error; err != nil {
+	summary, dbMessages, err := h.activePath(ctx)
+	if err != nil {
 		return nil, err
 	}
+	if summary != nil {
+		dbMessages = append([]models.Message{*summary}, dbMessages...)
+	}
 
 	var chatMessages []llms.ChatMessage
 	for _, msg := range dbMessages {
+		// A rolling summary stands in for everything before it
+		if isSummary(msg) {
+			chatMessages = []llms.ChatMessage{llms.SystemChatMessage{Content: msg.Parts[0].Content}}
+			continue
+		}
+
 		var parts []llms.ContentPart
 		var toolCalls []llms.ToolCall
 		var toolCallID string
@@ -186,14 +296,36 @@ func (h *SQLiteHistory) Messages(ctx context.Context) ([]llms.ChatMessage, error
 				parts = append(parts, llms.TextPart(part.Content))
 				contentStr += part.Content
 			case models.PartTypeFile:
+				// Generated images on assistant and tool messages are for display only
+				if msg.Role == models.RoleAssistant || msg.Role == "tool" {
+					continue
+				}
 				var meta models.FilePartMeta
 				_ = json.Unmarshal(part.Meta, &meta)
+				if isDocument(meta) {
+					parts = append(parts, h.documentParts(part, meta)...)
+					continue
+				}
 
-				url := part.Content
+				content := part.Content
+				if meta.Hash != "" {
+					data, err := h.loadFile(meta.Hash)
+					if err != nil {
+						parts = append(parts, llms.TextPart(fmt.Sprintf("[attachment %s unavailable]", meta.Filename)))
+						continue
+					}
+					content = base64.StdEncoding.EncodeToString(data)
+				}
+
+				url := content
 				if meta.Mime != "" {
-					url = fmt.Sprintf("data:%s;base64,%s", meta.Mime, part.Content)
+					url = fmt.Sprintf("data:%s;base64,%s", meta.Mime, content)
 				}
 				parts = append(parts, llms.ImageURLPart(url))
+			case models.PartTypeReasoning:
+				// Providers either reject earlier reasoning (DeepSeek) or drop it from
+				// previous turns themselves (OpenAI, Anthropic), so it stays out of context
+				continue
 			case models.PartTypeToolCall:
 				_ = json.Unmarshal([]byte(part.Content), &toolCalls)
 			case models.PartTypeToolResult:
@@ -262,11 +394,155 @@ func (h *SQLiteHistory) Messages(ctx context.Context) ([]llms.ChatMessage, error
 	return chatMessages, nil
 }
 
+// storeFile writes data to the file store and fills meta with its hash. Without a store
+// the data is returned as inline base64, matching the original schema.
+func (h *SQLiteHistory) storeFile(data []byte, meta *models.FilePartMeta) (string, error) {
+	meta.Size = int64(len(data))
+	if h.Files == nil {
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+	hash, err := h.Files.Put(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to store attachment: %w", err)
+	}
+	meta.Hash = hash
+	meta.URL = "/api/files/" + hash
+	return hash, nil
+}
+
+// isDocument reports whether a file part is a document rather than an image. Parts
+// saved before documents were supported have an image mime or none at all.
+func isDocument(meta models.FilePartMeta) bool {
+	return meta.Text != "" || (meta.Mime != "" && !strings.HasPrefix(meta.Mime, "image/"))
+}
+
+// documentParts returns the extracted text of a document. PDFs are additionally
+// preceded by their original bytes as BinaryContent, which llm.Service keeps for
+// providers that read PDFs natively and drops for the others; the text part must
+// directly follow the binary part.
+func (h *SQLiteHistory) documentParts(part models.Part, meta models.FilePartMeta) []llms.ContentPart {
+	var parts []llms.ContentPart
+	if meta.Mime == "application/pdf" {
+		var data []byte
+		var err error
+		if meta.Hash != "" {
+			data, err = h.loadFile(meta.Hash)
+		} else {
+			data, err = base64.StdEncoding.DecodeString(part.Content)
+		}
+		if err == nil {
+			parts = append(parts, llms.BinaryPart(meta.Mime, data))
+		}
+	}
+	return append(parts, llms.TextPart(DocumentText(meta)))
+}
+
+// DocumentText renders a document attachment for models that only read text.
+func DocumentText(meta models.FilePartMeta) string {
+	header := "[Attachment: " + meta.Filename
+	if meta.Pages > 0 {
+		header += fmt.Sprintf(", %d pages", meta.Pages)
+	}
+	header += "]"
+	if meta.Text == "" {
+		return header + "\n(no text could be extracted)"
+	}
+	text := header + "\n" + meta.Text
+	if meta.Truncated {
+		text += "\n[... truncated]"
+	}
+	return text + "\n[End of attachment: " + meta.Filename + "]"
+}
+
+func (h *SQLiteHistory) loadFile(hash string) ([]byte, error) {
+	if h.Files == nil {
+		return nil, storage.ErrNotFound
+	}
+	return h.Files.Get(hash)
+}
+
+// isSummary reports whether msg is a rolling context summary written by AddSummary.
+func isSummary(msg models.Message) bool {
+	if msg.Role != models.RoleSystem || len(msg.Parts) == 0 || len(msg.Parts[0].Meta) == 0 {
+		return false
+	}
+	var meta models.SummaryPartMeta
+	return json.Unmarshal(msg.Parts[0].Meta, &meta) == nil && meta.ContextSummary
+}
+
+// Summary returns the rolling summary of the active branch, or "" if there is none.
+func (h *SQLiteHistory) Summary(ctx context.Context) (string, error) {
+	summary, _, err := h.activePath(ctx)
+	if err != nil || summary == nil {
+		return "", err
+	}
+	return summary.Parts[0].Content, nil
+}
+
+// AddSummary stores summary as a system message replacing the first covered messages
+// of the active branch that follow the previous summary. The summary records the last
+// covered message, so Messages returns it in place of them on every branch through it.
+func (h *SQLiteHistory) AddSummary(ctx context.Context, summary string, covered int) error {
+	if covered <= 0 {
+		return nil
+	}
+	_, path, err := h.activePath(ctx)
+	if err != nil {
+		return err
+	}
+	if covered > len(path) {
+		return fmt.Errorf("summary covers %d messages but only %d follow the previous summary", covered, len(path))
+	}
+	last := path[covered-1]
+
+	meta, _ := json.Marshal(models.SummaryPartMeta{ContextSummary: true, UpTo: last.ID})
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		msg := models.Message{
+			SessionID: h.SessionID,
+			Role:      models.RoleSystem,
+			CreatedAt: last.CreatedAt,
+		}
+		if err := tx.Create(&msg).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Part{
+			MessageID: msg.ID,
+			Type:      models.PartTypeText,
+			Content:   summary,
+			Meta:      datatypes.JSON(meta),
+		}).Error
+	})
+}
+
+func (h *SQLiteHistory) allMessages() ([]models.Message, error) {
+	if err := h.upgrade(); err != nil {
+		return nil, err
+	}
+	var all []models.Message
+	err := h.DB.Where("session_id = ?", h.SessionID).Preload("Parts").Order("created_at asc, id asc").Find(&all).Error
+	return all, err
+}
+
 // MultiModalMessage represents a message with multiple parts (text, image, etc.)
 type MultiModalMessage struct {
 	Type    llms.ChatMessageType
 	Content string
 	Parts   []llms.ContentPart
+	// Attachments are documents or generated images saved as file parts; they are only
+	// read by SaveMessage
+	Attachments []Attachment
+	// ToolCallID stores the text as the result of this tool call
+	ToolCallID string
+}
+
+// Attachment is a file attached to a message together with its extracted text, if any.
+type Attachment struct {
+	Filename  string
+	Mime      string
+	Data      []byte
+	Text      string
+	Pages     int
+	Truncated bool
 }
 
 func (m MultiModalMessage) GetType() llms.ChatMessageType {
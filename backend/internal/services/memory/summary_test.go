@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"fnchatbot/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+func TestRollingSummary(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Message{}, &models.Part{}))
+
+	ctx := context.Background()
+	history := NewSQLiteHistory(db, 1)
+	for _, text := range []string{"q1", "a1", "q2", "a2", "q3"} {
+		if text[0] == 'q' {
+			assert.NoError(t, history.AddUserMessage(ctx, text))
+		} else {
+			assert.NoError(t, history.AddAIMessage(ctx, text))
+		}
+	}
+
+	// Summarize the first turn.
+	assert.NoError(t, history.AddSummary(ctx, "summary of q1/a1", 2))
+	msgs, err := history.Messages(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, llms.ChatMessageTypeSystem, msgs[0].GetType())
+	assert.Equal(t, "summary of q1/a1", msgs[0].GetContent())
+	assert.Equal(t, "q2", msgs[1].GetContent())
+
+	summary, err := history.Summary(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "summary of q1/a1", summary)
+
+	// A newer summary counts from the previous one and replaces it.
+	assert.NoError(t, history.AddSummary(ctx, "summary up to a2", 2))
+	msgs, err = history.Messages(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, "summary up to a2", msgs[0].GetContent())
+	assert.Equal(t, "q3", msgs[1].GetContent())
+
+	assert.Error(t, history.AddSummary(ctx, "too much", 5))
+}
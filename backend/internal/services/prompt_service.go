@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var promptVarPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z_]+)\s*\}\}`)
+
+// PromptVars holds the values substituted into system prompt templates.
+type PromptVars map[string]string
+
+// PromptVariableNames lists the variables templates may use, for editors and validation.
+var PromptVariableNames = []string{"date", "time", "weekday", "username", "allowed_paths", "skills", "model", "session_title"}
+
+// RenderPrompt replaces {{name}} placeholders with vars. Unknown names are left as-is
+// so a typo is visible in the output rather than silently dropped.
+func RenderPrompt(template string, vars PromptVars) string {
+	return promptVarPattern.ReplaceAllStringFunc(template, func(match string) string {
+		name := promptVarPattern.FindStringSubmatch(match)[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		return match
+	})
+}
+
+// PromptService resolves and renders the system prompt of a chat session.
+type PromptService struct {
+	DB *gorm.DB
+}
+
+// NewPromptService creates a PromptService.
+func NewPromptService(db *gorm.DB) *PromptService {
+	return &PromptService{DB: db}
+}
+
+// Variables collects template values for user chatting in session.
+func (s *PromptService) Variables(user models.User, session models.Session) PromptVars {
+	now := time.Now()
+	vars := PromptVars{
+		"date":          now.Format("2006-01-02"),
+		"time":          now.Format("15:04"),
+		"weekday":       now.Weekday().String(),
+		"username":      user.Username,
+		"model":         session.Model.Model,
+		"session_title": session.Title,
+	}
+
+	sandbox := NewSandboxService(s.DB)
+	switch paths := sandbox.GetAllowedPaths(); {
+	case !sandbox.IsEnabled():
+		vars["allowed_paths"] = "unrestricted (sandbox disabled)"
+	case len(paths) == 0:
+		vars["allowed_paths"] = "none"
+	default:
+		vars["allowed_paths"] = strings.Join(paths, ", ")
+	}
+
+	var skills []models.Skill
+	s.DB.Where("enabled = ? AND user_id = ?", true, user.ID).Order("priority desc, name asc").Find(&skills)
+	if len(skills) == 0 {
+		vars["skills"] = "- none"
+	} else {
+		lines := make([]string, len(skills))
+		for i, sk := range skills {
+			lines[i] = fmt.Sprintf("- %s: %s", sk.Name, sk.Description)
+		}
+		vars["skills"] = strings.Join(lines, "\n")
+	}
+	return vars
+}
+
+// Templates returns the prompts that apply to a session, in order: enabled global
+// prompts, the owner's user prompts, then the session persona.
+func (s *PromptService) Templates(user models.User, session models.Session) ([]models.SystemPrompt, error) {
+	var prompts []models.SystemPrompt
+	if err := s.DB.Where("enabled = ? AND ((scope = ?) OR (scope = ? AND user_id = ?))",
+		true, models.PromptScopeGlobal, models.PromptScopeUser, user.ID).
+		Order("CASE scope WHEN 'global' THEN 0 ELSE 1 END, id asc").
+		Find(&prompts).Error; err != nil {
+		return nil, err
+	}
+
+	if session.PersonaID != nil {
+		var persona models.SystemPrompt
+		err := s.DB.Where("id = ? AND scope = ? AND enabled = ? AND (user_id = ? OR user_id = 0)", *session.PersonaID, models.PromptScopePersona, true, user.ID).
+			First(&persona).Error
+		if err == nil {
+			prompts = append(prompts, persona)
+		} else if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+	}
+	return prompts, nil
+}
+
+// BuildSystemPrompt renders and joins the templates that apply to session.
+// It returns "" when no prompt is configured.
+func (s *PromptService) BuildSystemPrompt(user models.User, session models.Session) (string, error) {
+	prompts, err := s.Templates(user, session)
+	if err != nil || len(prompts) == 0 {
+		return "", err
+	}
+	vars := s.Variables(user, session)
+	parts := make([]string, 0, len(prompts))
+	for _, p := range prompts {
+		if text := strings.TrimSpace(RenderPrompt(p.Content, vars)); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
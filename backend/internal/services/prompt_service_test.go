@@ -0,0 +1,51 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"fnchatbot/internal/models"
+)
+
+func TestRenderPrompt(t *testing.T) {
+	got := RenderPrompt("Hi {{username}}, today is {{ date }}. {{unknown}}", PromptVars{"username": "alice", "date": "2025-01-02"})
+	want := "Hi alice, today is 2025-01-02. {{unknown}}"
+	if got != want {
+		t.Errorf("RenderPrompt() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildSystemPrompt_Layers(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.SystemPrompt{}, &models.Skill{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	svc := NewPromptService(db)
+	user := models.User{ID: 2, Username: "alice"}
+
+	persona := models.SystemPrompt{Name: "Translator", Scope: models.PromptScopePersona, UserID: 2, Content: "Translate everything.", Enabled: true}
+	prompts := []models.SystemPrompt{
+		{Name: "Mine", Scope: models.PromptScopeUser, UserID: 2, Content: "Call me {{username}}.", Enabled: true},
+		{Name: "Global", Scope: models.PromptScopeGlobal, Content: "You are FnChatBot.", Enabled: true},
+		{Name: "Other", Scope: models.PromptScopeUser, UserID: 3, Content: "Not for alice.", Enabled: true},
+	}
+	db.Create(&prompts)
+	db.Create(&persona)
+
+	got, err := svc.BuildSystemPrompt(user, models.Session{PersonaID: &persona.ID})
+	if err != nil {
+		t.Fatalf("BuildSystemPrompt failed: %v", err)
+	}
+	want := "You are FnChatBot.\n\nCall me alice.\n\nTranslate everything."
+	if got != want {
+		t.Errorf("BuildSystemPrompt() = %q, want %q", got, want)
+	}
+
+	// Another user's persona is ignored.
+	other := models.SystemPrompt{Name: "Pirate", Scope: models.PromptScopePersona, UserID: 3, Content: "Arr.", Enabled: true}
+	db.Create(&other)
+	got, _ = svc.BuildSystemPrompt(user, models.Session{PersonaID: &other.ID})
+	if strings.Contains(got, "Arr.") {
+		t.Errorf("expected foreign persona to be ignored, got %q", got)
+	}
+}
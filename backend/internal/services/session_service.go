@@ -0,0 +1,239 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/memory"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxTitleRunes  = 200
+	maxFolderRunes = 100
+	maxTagRunes    = 50
+	maxTags        = 20
+)
+
+// Archived filter values of SessionFilter
+const (
+	ArchivedExclude = "" // only conversations that are not archived
+	ArchivedOnly    = "only"
+	ArchivedAll     = "all"
+)
+
+// SessionFilter selects and orders a user's conversations. Pinned conversations come
+// first in every order.
+type SessionFilter struct {
+	Archived string
+	Pinned   *bool
+	Folder   *string // "" selects conversations outside any folder
+	Tag      string
+	Query    string // part of the title
+	ModelID  uint
+	Sort     string // created (default), updated or title
+	Desc     bool
+	Page     int // 1-based; 0 returns every conversation
+	PageSize int
+}
+
+// SessionUpdate holds the fields of a conversation to change; nil fields are kept.
+type SessionUpdate struct {
+	Title    *string   `json:"title"`
+	ModelID  *uint     `json:"model_id"`
+	Pinned   *bool     `json:"pinned"`
+	Archived *bool     `json:"archived"`
+	Folder   *string   `json:"folder"`
+	Tags     *[]string `json:"tags"`
+	// Tool loop limits of replies; 0 inherits the model config's limits
+	MaxTurns           *int `json:"max_turns"`
+	MaxToolCalls       *int `json:"max_tool_calls"`
+	MaxDurationSeconds *int `json:"max_duration_seconds"`
+}
+
+// HasAgentLimits reports whether u changes any tool loop limit.
+func (u SessionUpdate) HasAgentLimits() bool {
+	return u.MaxTurns != nil || u.MaxToolCalls != nil || u.MaxDurationSeconds != nil
+}
+
+// AgentLimits returns current with the limits set in u applied.
+func (u SessionUpdate) AgentLimits(current models.AgentLimits) models.AgentLimits {
+	if u.MaxTurns != nil {
+		current.MaxTurns = *u.MaxTurns
+	}
+	if u.MaxToolCalls != nil {
+		current.MaxToolCalls = *u.MaxToolCalls
+	}
+	if u.MaxDurationSeconds != nil {
+		current.MaxDurationSeconds = *u.MaxDurationSeconds
+	}
+	return current
+}
+
+// SessionService manages the conversations of users.
+type SessionService struct {
+	DB *gorm.DB
+}
+
+// NewSessionService creates a SessionService.
+func NewSessionService(db *gorm.DB) *SessionService {
+	return &SessionService{DB: db}
+}
+
+// List returns the user's conversations selected by f and the number selected before
+// pagination.
+func (s *SessionService) List(userID uint, f SessionFilter) ([]models.Session, int64, error) {
+	q := s.DB.Model(&models.Session{}).Where("user_id = ?", userID)
+	switch f.Archived {
+	case ArchivedExclude:
+		q = q.Where("archived = ?", false)
+	case ArchivedOnly:
+		q = q.Where("archived = ?", true)
+	case ArchivedAll:
+	default:
+		return nil, 0, fmt.Errorf("archived must be true, false or all")
+	}
+	if f.Pinned != nil {
+		q = q.Where("pinned = ?", *f.Pinned)
+	}
+	if f.Folder != nil {
+		q = q.Where("COALESCE(folder, '') = ?", strings.TrimSpace(*f.Folder))
+	}
+	if f.Tag != "" {
+		q = q.Where("EXISTS (SELECT 1 FROM json_each(sessions.tags) WHERE json_each.value = ?)", f.Tag)
+	}
+	if f.Query != "" {
+		q = q.Where("title LIKE ? ESCAPE '\\'", "%"+memory.EscapeLike(f.Query)+"%")
+	}
+	if f.ModelID != 0 {
+		q = q.Where("model_id = ?", f.ModelID)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var column string
+	switch f.Sort {
+	case "", "created":
+		column = "created_at"
+	case "updated":
+		column = "updated_at"
+	case "title":
+		column = "title COLLATE NOCASE"
+	default:
+		return nil, 0, fmt.Errorf("sort must be created, updated or title")
+	}
+	dir := "asc"
+	if f.Desc {
+		dir = "desc"
+	}
+	q = q.Order("pinned desc").Order(column + " " + dir).Order("id " + dir)
+	if f.Page > 0 {
+		q = q.Limit(f.PageSize).Offset((f.Page - 1) * f.PageSize)
+	}
+
+	var sessions []models.Session
+	if err := q.Find(&sessions).Error; err != nil {
+		return nil, 0, err
+	}
+	return sessions, total, nil
+}
+
+// Update applies u to the conversation. A title set here is kept from then on; an empty
+// title lets the next reply name the conversation again.
+func (s *SessionService) Update(session *models.Session, u SessionUpdate) error {
+	changes := map[string]interface{}{}
+	if u.Title != nil {
+		title := strings.TrimSpace(*u.Title)
+		if utf8.RuneCountInString(title) > maxTitleRunes {
+			return fmt.Errorf("title must be at most %d characters", maxTitleRunes)
+		}
+		source := models.TitleSourceUser
+		if title == "" {
+			source = ""
+		}
+		changes["title"], changes["title_source"] = title, source
+	}
+	if u.ModelID != nil {
+		var count int64
+		if err := s.DB.Model(&models.ModelConfig{}).Where("id = ? AND user_id = ?", *u.ModelID, session.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("model config not found")
+		}
+		changes["model_id"] = *u.ModelID
+	}
+	if u.Pinned != nil {
+		changes["pinned"] = *u.Pinned
+	}
+	if u.Archived != nil {
+		changes["archived"] = *u.Archived
+	}
+	if u.Folder != nil {
+		folder := strings.TrimSpace(*u.Folder)
+		if utf8.RuneCountInString(folder) > maxFolderRunes {
+			return fmt.Errorf("folder must be at most %d characters", maxFolderRunes)
+		}
+		changes["folder"] = folder
+	}
+	if u.Tags != nil {
+		tags, err := NormalizeTags(*u.Tags)
+		if err != nil {
+			return err
+		}
+		session.Tags = tags
+	}
+	if u.HasAgentLimits() {
+		limits := u.AgentLimits(session.AgentLimits)
+		changes["max_turns"] = limits.MaxTurns
+		changes["max_tool_calls"] = limits.MaxToolCalls
+		changes["max_duration_seconds"] = limits.MaxDurationSeconds
+	}
+	if len(changes) == 0 && u.Tags == nil {
+		return nil
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if len(changes) > 0 {
+			if err := tx.Model(session).Updates(changes).Error; err != nil {
+				return err
+			}
+		}
+		if u.Tags != nil {
+			// Through the struct so the serializer encodes the list
+			if err := tx.Model(session).Select("Tags").Updates(models.Session{Tags: session.Tags}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.First(session, session.ID).Error
+	})
+}
+
+// NormalizeTags trims, de-duplicates and sorts tags, dropping empty ones.
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > maxTagRunes {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagRunes)
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("a conversation can have at most %d tags", maxTags)
+	}
+	sort.Strings(out)
+	return out, nil
+}
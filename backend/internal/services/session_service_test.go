@@ -0,0 +1,126 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/models"
+)
+
+func sessionTitles(sessions []models.Session) []string {
+	titles := make([]string, len(sessions))
+	for i, s := range sessions {
+		titles[i] = s.Title
+	}
+	return titles
+}
+
+func TestSessionService_UpdateAndList(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.ModelConfig{}, &models.Session{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	svc := NewSessionService(db)
+	if err := db.Create(&models.ModelConfig{ID: 3, Name: "cheap", Provider: "openai", Model: "mini", UserID: 1}).Error; err != nil {
+		t.Fatalf("failed to create model config: %v", err)
+	}
+	if err := db.Create(&models.ModelConfig{ID: 4, Name: "other", Provider: "openai", Model: "mini", UserID: 2}).Error; err != nil {
+		t.Fatalf("failed to create model config: %v", err)
+	}
+
+	start := time.Now()
+	for i, title := range []string{"alpha", "Beta", "gamma", "delta"} {
+		s := models.Session{Title: title, UserID: 1, ModelID: 1, CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		if err := db.Create(&s).Error; err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+	}
+	if err := db.Create(&models.Session{Title: "someone else's", UserID: 2}).Error; err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	get := func(title string) *models.Session {
+		t.Helper()
+		var s models.Session
+		if err := db.Where("title = ?", title).First(&s).Error; err != nil {
+			t.Fatalf("session %s not found: %v", title, err)
+		}
+		return &s
+	}
+	update := func(s *models.Session, u SessionUpdate) {
+		t.Helper()
+		if err := svc.Update(s, u); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+	yes, no := true, false
+	str := func(s string) *string { return &s }
+
+	beta := get("Beta")
+	update(beta, SessionUpdate{Pinned: &yes, Folder: str(" work "), Tags: &[]string{"go", " ", "db", "go"}})
+	if !beta.Pinned || beta.Folder != "work" || !reflect.DeepEqual(beta.Tags, []string{"db", "go"}) {
+		t.Fatalf("unexpected session after update: %+v", beta)
+	}
+	update(get("gamma"), SessionUpdate{Archived: &yes, Tags: &[]string{"go"}})
+	delta := get("delta")
+	update(delta, SessionUpdate{Title: str("Delta renamed")})
+	if delta.Title != "Delta renamed" || delta.TitleSource != models.TitleSourceUser {
+		t.Fatalf("unexpected title: %q (%q)", delta.Title, delta.TitleSource)
+	}
+	update(delta, SessionUpdate{Title: str("")})
+	if delta.TitleSource != "" {
+		t.Fatalf("clearing the title should allow automatic titles again, got %q", delta.TitleSource)
+	}
+
+	// Model configs of other users are rejected
+	if err := svc.Update(delta, SessionUpdate{ModelID: func() *uint { id := uint(4); return &id }()}); err == nil {
+		t.Fatalf("expected another user's model config to be rejected")
+	}
+	update(delta, SessionUpdate{ModelID: func() *uint { id := uint(3); return &id }()})
+
+	// Agent limits left out of the body are kept
+	turns, calls := 5, 20
+	update(delta, SessionUpdate{MaxTurns: &turns, MaxToolCalls: &calls})
+	turns = 8
+	update(delta, SessionUpdate{MaxTurns: &turns})
+	if delta.MaxTurns != 8 || delta.MaxToolCalls != 20 || delta.MaxDurationSeconds != 0 {
+		t.Fatalf("unexpected agent limits: %+v", delta.AgentLimits)
+	}
+
+	list := func(f SessionFilter) ([]string, int64) {
+		t.Helper()
+		sessions, total, err := svc.List(1, f)
+		if err != nil {
+			t.Fatalf("list failed: %v", err)
+		}
+		return sessionTitles(sessions), total
+	}
+	cases := []struct {
+		name   string
+		filter SessionFilter
+		want   []string
+	}{
+		{"default: pinned first, newest first, archived hidden", SessionFilter{Desc: true}, []string{"Beta", "", "alpha"}},
+		{"archived only", SessionFilter{Archived: ArchivedOnly}, []string{"gamma"}},
+		{"all by title", SessionFilter{Archived: ArchivedAll, Sort: "title"}, []string{"Beta", "", "alpha", "gamma"}},
+		{"tag", SessionFilter{Archived: ArchivedAll, Tag: "go"}, []string{"Beta", "gamma"}},
+		{"folder", SessionFilter{Folder: str("work")}, []string{"Beta"}},
+		{"no folder", SessionFilter{Folder: str("")}, []string{"alpha", ""}},
+		{"not pinned", SessionFilter{Pinned: &no}, []string{"alpha", ""}},
+		{"title query", SessionFilter{Query: "ALP"}, []string{"alpha"}},
+		{"model", SessionFilter{ModelID: 3}, []string{""}},
+	}
+	for _, c := range cases {
+		if got, _ := list(c.filter); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+	}
+
+	got, total := list(SessionFilter{Archived: ArchivedAll, Desc: true, Page: 2, PageSize: 3})
+	if total != 4 || !reflect.DeepEqual(got, []string{"alpha"}) {
+		t.Fatalf("unexpected page: %q of %d", got, total)
+	}
+	if _, _, err := svc.List(1, SessionFilter{Sort: "size"}); err == nil {
+		t.Fatalf("expected an unknown sort to be rejected")
+	}
+}
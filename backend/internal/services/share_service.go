@@ -0,0 +1,123 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrShareNotFound is returned for unknown and revoked share tokens.
+var ErrShareNotFound = errors.New("share link not found")
+
+// ErrShareExpired is returned for share tokens past their expiry.
+var ErrShareExpired = errors.New("share link has expired")
+
+// ShareOptions configure a new share link.
+type ShareOptions struct {
+	ExpiresAt         *time.Time `json:"expires_at"`
+	RedactToolOutputs bool       `json:"redact_tool_outputs"`
+}
+
+// ShareService manages read-only links to conversations.
+type ShareService struct {
+	DB *gorm.DB
+}
+
+// NewShareService creates a ShareService.
+func NewShareService(db *gorm.DB) *ShareService {
+	return &ShareService{DB: db}
+}
+
+// Create makes a share link for one of the user's conversations.
+func (s *ShareService) Create(userID, sessionID uint, opts ShareOptions) (*models.ShareLink, error) {
+	var count int64
+	if err := s.DB.Model(&models.Session{}).Where("id = ? AND user_id = ?", sessionID, userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("conversation not found")
+	}
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	link := models.ShareLink{
+		Token:             token,
+		SessionID:         sessionID,
+		UserID:            userID,
+		RedactToolOutputs: opts.RedactToolOutputs,
+		ExpiresAt:         opts.ExpiresAt,
+	}
+	if err := s.DB.Create(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// List returns the share links of one of the user's conversations, newest first.
+func (s *ShareService) List(userID, sessionID uint) ([]models.ShareLink, error) {
+	var links []models.ShareLink
+	err := s.DB.Where("session_id = ? AND user_id = ?", sessionID, userID).Order("id desc").Find(&links).Error
+	return links, err
+}
+
+// Revoke deletes a share link of the user's conversation; it stops working at once.
+func (s *ShareService) Revoke(userID, sessionID, linkID uint) error {
+	res := s.DB.Where("id = ? AND session_id = ? AND user_id = ?", linkID, sessionID, userID).Delete(&models.ShareLink{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// RevokeAll deletes every share link of the user's conversation.
+func (s *ShareService) RevokeAll(userID, sessionID uint) error {
+	return s.DB.Where("session_id = ? AND user_id = ?", sessionID, userID).Delete(&models.ShareLink{}).Error
+}
+
+// Resolve returns the link of token with its conversation, ErrShareNotFound or
+// ErrShareExpired.
+func (s *ShareService) Resolve(token string) (*models.ShareLink, *models.Session, error) {
+	if token == "" {
+		return nil, nil, ErrShareNotFound
+	}
+	var link models.ShareLink
+	if err := s.DB.Where("token = ?", token).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrShareNotFound
+		}
+		return nil, nil, err
+	}
+	if link.Expired(time.Now()) {
+		return nil, nil, ErrShareExpired
+	}
+	var session models.Session
+	if err := s.DB.Preload("Model").Where("id = ? AND user_id = ?", link.SessionID, link.UserID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrShareNotFound
+		}
+		return nil, nil, err
+	}
+	return &link, &session, nil
+}
+
+// newShareToken returns an unguessable URL-safe token.
+func newShareToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
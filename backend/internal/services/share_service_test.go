@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/models"
+)
+
+func TestShareService_Lifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.ModelConfig{}, &models.Session{}, &models.ShareLink{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	svc := NewShareService(db)
+	session := models.Session{Title: "shared", UserID: 1}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	if _, err := svc.Create(2, session.ID, ShareOptions{}); err == nil {
+		t.Fatalf("expected sharing another user's conversation to fail")
+	}
+	past := time.Now().Add(-time.Minute)
+	if _, err := svc.Create(1, session.ID, ShareOptions{ExpiresAt: &past}); err == nil {
+		t.Fatalf("expected an expiry in the past to be rejected")
+	}
+
+	link, err := svc.Create(1, session.ID, ShareOptions{RedactToolOutputs: true})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if len(link.Token) < 32 {
+		t.Fatalf("token too short: %q", link.Token)
+	}
+	got, shared, err := svc.Resolve(link.Token)
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if !got.RedactToolOutputs || shared.Title != "shared" {
+		t.Fatalf("unexpected link %+v for %+v", got, shared)
+	}
+	if _, _, err := svc.Resolve("nope"); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("expected ErrShareNotFound, got %v", err)
+	}
+
+	// Expired links stop working
+	soon := time.Now().Add(time.Hour)
+	expiring, err := svc.Create(1, session.ID, ShareOptions{ExpiresAt: &soon})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if err := db.Model(expiring).Update("expires_at", past).Error; err != nil {
+		t.Fatalf("failed to expire link: %v", err)
+	}
+	if _, _, err := svc.Resolve(expiring.Token); !errors.Is(err, ErrShareExpired) {
+		t.Fatalf("expected ErrShareExpired, got %v", err)
+	}
+
+	links, err := svc.List(1, session.ID)
+	if err != nil || len(links) != 2 || links[0].ID != expiring.ID {
+		t.Fatalf("unexpected links %+v: %v", links, err)
+	}
+	if err := svc.Revoke(2, session.ID, link.ID); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("expected another user's revoke to fail, got %v", err)
+	}
+	if err := svc.Revoke(1, session.ID, link.ID); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if _, _, err := svc.Resolve(link.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("expected revoked link to be gone, got %v", err)
+	}
+
+	// Deleting the conversation breaks its links even before they are cleaned up
+	again, err := svc.Create(1, session.ID, ShareOptions{})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if err := db.Delete(&session).Error; err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if _, _, err := svc.Resolve(again.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("expected link of a deleted conversation to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"fnchatbot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// toolPolicyRank orders policies from the most to the least permissive.
+var toolPolicyRank = map[string]int{
+	models.ToolPolicyAuto: 0,
+	models.ToolPolicyAsk:  1,
+	models.ToolPolicyDeny: 2,
+}
+
+// ValidToolPolicy reports whether p is auto, ask or deny.
+func ValidToolPolicy(p string) bool {
+	_, ok := toolPolicyRank[p]
+	return ok
+}
+
+// ToolPolicyName is the name policies use for t: the tool name for built-in and skill
+// tools, "server__tool" for MCP tools.
+func ToolPolicyName(t Tool) string {
+	if t.Server == "" || t.Server == ToolOriginBuiltin || t.Server == ToolOriginSkill {
+		return t.Function.Name
+	}
+	return t.Server + "__" + t.Function.Name
+}
+
+// ToolPolicies are the policies that apply to one user.
+type ToolPolicies struct {
+	global map[string]string
+	user   map[string]string
+}
+
+// For returns the policy of t: the stricter of the admin-wide and the user's policy,
+// auto when neither is set.
+func (p ToolPolicies) For(t Tool) string {
+	name := ToolPolicyName(t)
+	policy := models.ToolPolicyAuto
+	for _, set := range []map[string]string{p.global, p.user} {
+		if v := lookupToolPolicy(set, t, name); toolPolicyRank[v] > toolPolicyRank[policy] {
+			policy = v
+		}
+	}
+	return policy
+}
+
+// lookupToolPolicy finds the policy of a tool by name, then by its MCP server wildcard.
+func lookupToolPolicy(set map[string]string, t Tool, name string) string {
+	if v, ok := set[name]; ok {
+		return v
+	}
+	if name != t.Function.Name {
+		return set[t.Server+"__*"]
+	}
+	return ""
+}
+
+// ToolPolicyService stores tool approval policies.
+type ToolPolicyService struct {
+	DB *gorm.DB
+}
+
+// NewToolPolicyService creates a ToolPolicyService.
+func NewToolPolicyService(db *gorm.DB) *ToolPolicyService {
+	return &ToolPolicyService{DB: db}
+}
+
+// List returns the admin-wide policies followed by the user's own.
+func (s *ToolPolicyService) List(userID uint) ([]models.ToolPolicy, error) {
+	var policies []models.ToolPolicy
+	err := s.DB.Where("user_id IN ?", []uint{0, userID}).Order("user_id asc, tool asc").Find(&policies).Error
+	return policies, err
+}
+
+// Policies loads the policies that apply to a user.
+func (s *ToolPolicyService) Policies(userID uint) (ToolPolicies, error) {
+	rows, err := s.List(userID)
+	if err != nil {
+		return ToolPolicies{}, err
+	}
+	p := ToolPolicies{global: make(map[string]string), user: make(map[string]string)}
+	for _, r := range rows {
+		if r.UserID == 0 {
+			p.global[r.Tool] = r.Policy
+		} else {
+			p.user[r.Tool] = r.Policy
+		}
+	}
+	return p, nil
+}
+
+// Set creates or replaces the policy of a tool; userID 0 sets the admin-wide policy.
+func (s *ToolPolicyService) Set(userID uint, tool, policy string) (*models.ToolPolicy, error) {
+	tool = strings.TrimSpace(tool)
+	if tool == "" {
+		return nil, fmt.Errorf("tool is required")
+	}
+	if !ValidToolPolicy(policy) {
+		return nil, fmt.Errorf("policy must be auto, ask or deny")
+	}
+	var row models.ToolPolicy
+	if err := s.DB.Where("user_id = ? AND tool = ?", userID, tool).Limit(1).Find(&row).Error; err != nil {
+		return nil, err
+	}
+	row.UserID = userID
+	row.Tool = tool
+	row.Policy = policy
+	if err := s.DB.Save(&row).Error; err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"testing"
+
+	"fnchatbot/internal/models"
+)
+
+func TestToolPolicies_StricterWinsAndServerWildcard(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.ToolPolicy{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	svc := NewToolPolicyService(db)
+
+	mustSet := func(userID uint, tool, policy string) {
+		t.Helper()
+		if _, err := svc.Set(userID, tool, policy); err != nil {
+			t.Fatalf("set %s failed: %v", tool, err)
+		}
+	}
+	mustSet(0, "mail__*", models.ToolPolicyAsk)
+	mustSet(0, "TodoWrite", models.ToolPolicyAuto)
+	mustSet(7, "mail__delete", models.ToolPolicyDeny)
+	// A user cannot relax an admin-wide policy
+	mustSet(7, "mail__send", models.ToolPolicyAuto)
+	mustSet(7, "TodoWrite", models.ToolPolicyAsk)
+	// Setting again replaces the row
+	mustSet(7, "TodoWrite", models.ToolPolicyAsk)
+
+	if _, err := svc.Set(7, "TodoWrite", "sometimes"); err == nil {
+		t.Fatalf("expected invalid policy to be rejected")
+	}
+
+	policies, err := svc.Policies(7)
+	if err != nil {
+		t.Fatalf("policies failed: %v", err)
+	}
+	cases := []struct {
+		tool Tool
+		want string
+	}{
+		{Tool{Function: ToolSchema{Name: "send"}, Server: "mail"}, models.ToolPolicyAsk},
+		{Tool{Function: ToolSchema{Name: "delete"}, Server: "mail"}, models.ToolPolicyDeny},
+		{Tool{Function: ToolSchema{Name: "list"}, Server: "mail"}, models.ToolPolicyAsk},
+		{Tool{Function: ToolSchema{Name: "TodoWrite"}, Server: ToolOriginBuiltin}, models.ToolPolicyAsk},
+		{Tool{Function: ToolSchema{Name: "read_file"}, Server: "filesystem"}, models.ToolPolicyAuto},
+	}
+	for _, c := range cases {
+		if got := policies.For(c.tool); got != c.want {
+			t.Errorf("%s: expected %s, got %s", ToolPolicyName(c.tool), c.want, got)
+		}
+	}
+
+	// Other users only see the admin-wide policies
+	others, err := svc.Policies(8)
+	if err != nil {
+		t.Fatalf("policies failed: %v", err)
+	}
+	if got := others.For(Tool{Function: ToolSchema{Name: "TodoWrite"}, Server: ToolOriginBuiltin}); got != models.ToolPolicyAuto {
+		t.Errorf("expected auto for another user, got %s", got)
+	}
+	rows, _ := svc.List(7)
+	if len(rows) != 5 {
+		t.Errorf("expected 5 policies, got %d", len(rows))
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"fnchatbot/internal/db"
 	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/llm"
 
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -16,11 +18,24 @@ import (
 
 const (
 	ToolTypeFunction = "function"
+
+	// ToolOriginBuiltin and ToolOriginSkill are the Server of tools not provided by an MCP server
+	ToolOriginBuiltin = "builtin"
+	ToolOriginSkill   = "skill"
 )
 
+// imageToolTimeout bounds ImageGenerate calls, which take much longer than other tools.
+const imageToolTimeout = 3 * time.Minute
+
 type Tool struct {
 	Type     string     `json:"type"`
 	Function ToolSchema `json:"function"`
+	// Server is the MCP server providing the tool, or ToolOriginBuiltin / ToolOriginSkill
+	Server string `json:"server,omitempty"`
+	// Sequential tools change state and are not run concurrently with other calls
+	Sequential bool `json:"-"`
+	// Timeout overrides the configured per-call timeout when set
+	Timeout time.Duration `json:"-"`
 }
 
 type ToolSchema struct {
@@ -65,6 +80,8 @@ func (s *ToolService) GetAvailableTools() ([]Tool, error) {
 				"required": []string{"items"},
 			},
 		},
+		// Each call replaces the whole task list, so the order of calls matters
+		Sequential: true,
 	})
 
 	tools = append(tools, Tool{
@@ -99,6 +116,50 @@ func (s *ToolService) GetAvailableTools() ([]Tool, error) {
 		},
 	})
 
+	var knowledgeCount int64
+	db.DB.Model(&models.KnowledgeBase{}).Where("user_id = ?", s.UserID).Count(&knowledgeCount)
+	if knowledgeCount > 0 {
+		tools = append(tools, Tool{
+			Type: ToolTypeFunction,
+			Function: ToolSchema{
+				Name:        "KnowledgeSearch",
+				Description: "Search the user's indexed documents on the NAS (knowledge bases) and return the most relevant passages with their file paths. Use this for questions about the user's own files.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"query":              map[string]interface{}{"type": "string", "description": "What to look for, phrased as a question or keywords"},
+						"knowledge_base_ids": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}, "description": "Restrict the search to these knowledge bases (default: all)"},
+						"top_k":              map[string]interface{}{"type": "integer", "description": "Number of passages to return (default 5)"},
+					},
+					"required": []string{"query"},
+				},
+			},
+		})
+	}
+
+	if _, err := NewImageService(db.DB).DefaultModel(); err == nil {
+		tools = append(tools, Tool{
+			Type: ToolTypeFunction,
+			Function: ToolSchema{
+				Name:        "ImageGenerate",
+				Description: "Generate an image from a text description. The image is shown to the user; describe the subject, style and composition in detail.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"prompt": map[string]interface{}{"type": "string", "description": "Detailed description of the image"},
+						"size":   map[string]interface{}{"type": "string", "description": "Image size such as 1024x1024, 1536x1024 or 1024x1536 (optional)"},
+					},
+					"required": []string{"prompt"},
+				},
+			},
+			Timeout: imageToolTimeout,
+		})
+	}
+
+	for i := range tools {
+		tools[i].Server = ToolOriginBuiltin
+	}
+
 	var skills []models.Skill
 	if err := db.DB.Where("enabled = ? AND user_id = ?", true, s.UserID).Find(&skills).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch skills: %v", err)
@@ -153,14 +214,23 @@ func (s *ToolService) convertSkillToTool(skill models.Skill) (Tool, error) {
 		}
 	}
 
-	return Tool{
+	tool := Tool{
 		Type: ToolTypeFunction,
 		Function: ToolSchema{
 			Name:        skill.Name,
 			Description: skill.Description,
 			Parameters:  paramsMap,
 		},
-	}, nil
+		Server: ToolOriginSkill,
+	}
+	// Skills opt out of concurrent execution with "parallel": false
+	if parallel, ok := configMap["parallel"].(bool); ok && !parallel {
+		tool.Sequential = true
+	}
+	if secs, ok := configMap["timeout_seconds"].(float64); ok && secs > 0 {
+		tool.Timeout = time.Duration(secs * float64(time.Second))
+	}
+	return tool, nil
 }
 
 // listMCPTools calls MCP ListTools and converts result to our Tool slice.
@@ -187,12 +257,26 @@ func (s *ToolService) listMCPTools(ctx context.Context, serverName string, c *cl
 				Description: t.Description,
 				Parameters:  params,
 			},
+			Server:     serverName,
+			Sequential: !mcpToolParallel(t.Annotations),
 		})
 	}
 	return out, nil
 }
 
-func (s *ToolService) ExecuteSkill(name string, args string) (string, error) {
+// mcpToolParallel reports whether an MCP tool may run alongside other calls: it must be
+// annotated read-only or explicitly non-destructive. Per the MCP spec, tools without
+// annotations are assumed to have destructive side effects.
+func mcpToolParallel(a mcp.ToolAnnotation) bool {
+	if a.ReadOnlyHint != nil && *a.ReadOnlyHint {
+		return true
+	}
+	return a.DestructiveHint != nil && !*a.DestructiveHint
+}
+
+// ExecuteSkill runs a tool call. Cancelling ctx, which also carries the per-call
+// timeout, aborts calls still in flight.
+func (s *ToolService) ExecuteSkill(ctx context.Context, name string, args string) (string, error) {
 	if name == "TodoWrite" {
 		return fmt.Sprintf("Tasks updated. Current state: %s", args), nil
 	}
@@ -238,6 +322,15 @@ func (s *ToolService) ExecuteSkill(name string, args string) (string, error) {
 Skill loaded successfully. You can now use this knowledge to assist the user.`, skillArgs.Name, skillArgs.Name), nil
 	}
 
+	if name == "KnowledgeSearch" {
+		return s.searchKnowledge(ctx, args)
+	}
+
+	if name == "ImageGenerate" {
+		result, _, err := s.GenerateImage(ctx, args)
+		return result, err
+	}
+
 	if name == "get_current_time" {
 		return "2023-10-27 10:00:00", nil
 	}
@@ -245,8 +338,6 @@ Skill loaded successfully. You can now use this knowledge to assist the user.`,
 	// Execute via MCP client CallTool
 	if DefaultMCPService != nil {
 		clients := DefaultMCPService.GetConnectedClients()
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
 		var argsMap map[string]interface{}
 		if args != "" {
 			_ = json.Unmarshal([]byte(args), &argsMap)
@@ -267,6 +358,73 @@ Skill loaded successfully. You can now use this knowledge to assist the user.`,
 	return fmt.Sprintf("Tool %s not found or execution failed", name), fmt.Errorf("tool not found")
 }
 
+// GenerateImage runs an ImageGenerate tool call with the default image model and returns
+// the tool result text together with the images, which the caller stores and displays.
+func (s *ToolService) GenerateImage(ctx context.Context, args string) (string, []llm.GeneratedImage, error) {
+	var imageArgs struct {
+		Prompt string `json:"prompt"`
+		Size   string `json:"size"`
+	}
+	if err := json.Unmarshal([]byte(args), &imageArgs); err != nil {
+		return "", nil, fmt.Errorf("invalid image args: %v", err)
+	}
+
+	svc := NewImageService(db.DB)
+	model, err := svc.DefaultModel()
+	if err != nil {
+		return "", nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, imageToolTimeout)
+	defer cancel()
+	images, err := svc.Generate(ctx, llm.UsageContext{UserID: s.UserID}, *model, imageArgs.Prompt, llm.ImageOptions{Size: imageArgs.Size})
+	if err != nil {
+		return "", nil, err
+	}
+
+	result := fmt.Sprintf("Generated %d image(s) with %s. They are already displayed to the user; do not repeat them.", len(images), model.ModelID)
+	if images[0].RevisedPrompt != "" {
+		result += "\nRevised prompt: " + images[0].RevisedPrompt
+	}
+	return result, images, nil
+}
+
+// searchKnowledge runs a KnowledgeSearch tool call over the user's knowledge bases.
+func (s *ToolService) searchKnowledge(ctx context.Context, args string) (string, error) {
+	var searchArgs struct {
+		Query            string `json:"query"`
+		KnowledgeBaseIDs []uint `json:"knowledge_base_ids"`
+		TopK             int    `json:"top_k"`
+	}
+	if err := json.Unmarshal([]byte(args), &searchArgs); err != nil {
+		return "", fmt.Errorf("invalid knowledge search args: %v", err)
+	}
+
+	// The query embedding is billed to the user like any other model call
+	var user models.User
+	if err := db.DB.First(&user, s.UserID).Error; err != nil {
+		return "", fmt.Errorf("failed to load user: %w", err)
+	}
+	if err := NewBudgetService(db.DB).Check(&user); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	hits, err := NewKnowledgeService(db.DB).Search(ctx, s.UserID, searchArgs.Query, searchArgs.KnowledgeBaseIDs, searchArgs.TopK)
+	if err != nil {
+		return "", err
+	}
+	if len(hits) == 0 {
+		return "No matching passages found in the knowledge base.", nil
+	}
+
+	var b strings.Builder
+	for i, h := range hits {
+		fmt.Fprintf(&b, "[%d] %s (knowledge base %q, score %.2f)\n%s\n\n", i+1, h.Path, h.KnowledgeBase, h.Score, h.Content)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
 func formatCallToolResult(res *mcp.CallToolResult) string {
 	if res == nil {
 		return ""
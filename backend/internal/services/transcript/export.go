@@ -0,0 +1,344 @@
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Export formats
+const (
+	FormatMarkdown    = "markdown"
+	FormatJSON        = "json"
+	FormatHTML        = "html"
+	FormatOpenAIJSONL = "openai-jsonl"
+)
+
+// Formats lists the export formats.
+var Formats = []string{FormatMarkdown, FormatJSON, FormatHTML, FormatOpenAIJSONL}
+
+// Extension returns the file extension of an export format, or "" if it is unknown.
+func Extension(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return "md"
+	case FormatJSON:
+		return "json"
+	case FormatHTML:
+		return "html"
+	case FormatOpenAIJSONL:
+		return "jsonl"
+	}
+	return ""
+}
+
+// ContentType returns the MIME type of an export format.
+func ContentType(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case FormatJSON:
+		return "application/json"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatOpenAIJSONL:
+		return "application/jsonl"
+	}
+	return "application/octet-stream"
+}
+
+// Write exports doc in format. JSON holds every branch; the other formats hold the
+// active branch.
+func Write(w io.Writer, doc *Document, format string) error {
+	switch format {
+	case FormatMarkdown:
+		return writeMarkdown(w, doc)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case FormatHTML:
+		return writeHTML(w, doc)
+	case FormatOpenAIJSONL:
+		return writeOpenAI(w, doc)
+	}
+	return fmt.Errorf("unknown export format %q, use one of %s", format, strings.Join(Formats, ", "))
+}
+
+// toolCalls decodes a tool_calls part. The parts hold llms.ToolCall as marshaled by
+// langchaingo, whose UnmarshalJSON loses the function, so they are decoded here.
+func toolCalls(p Part) []llms.ToolCall {
+	var stored []struct {
+		ToolCall struct {
+			ID       string             `json:"id"`
+			Type     string             `json:"type"`
+			Function *llms.FunctionCall `json:"function"`
+		} `json:"tool_call"`
+	}
+	_ = json.Unmarshal([]byte(p.Content), &stored)
+	calls := make([]llms.ToolCall, 0, len(stored))
+	for _, s := range stored {
+		calls = append(calls, llms.ToolCall{ID: s.ToolCall.ID, Type: s.ToolCall.Type, FunctionCall: s.ToolCall.Function})
+	}
+	return calls
+}
+
+// toolCallID returns the call a tool_result part answers.
+func toolCallID(p Part) string {
+	var meta struct {
+		ToolCallID string `json:"tool_call_id"`
+	}
+	_ = json.Unmarshal(p.Meta, &meta)
+	return meta.ToolCallID
+}
+
+func roleTitle(role models.MessageRole) string {
+	switch role {
+	case models.RoleUser:
+		return "User"
+	case models.RoleAssistant:
+		return "Assistant"
+	case models.RoleSystem:
+		return "System"
+	}
+	return "Tool"
+}
+
+// fence returns a code fence longer than any backtick run in s.
+func fence(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+func writeMarkdown(w io.Writer, doc *Document) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", doc.Title)
+	fmt.Fprintf(&b, "*Created %s", doc.CreatedAt.Format("2006-01-02 15:04"))
+	if doc.Model != "" {
+		fmt.Fprintf(&b, " · Model: %s", doc.Model)
+	}
+	b.WriteString("*\n")
+
+	for _, m := range doc.ActivePath() {
+		fmt.Fprintf(&b, "\n---\n\n## %s\n\n*%s*\n", roleTitle(m.Role), m.CreatedAt.Format("2006-01-02 15:04"))
+		for _, p := range m.Parts {
+			b.WriteString("\n")
+			switch p.Type {
+			case models.PartTypeText:
+				b.WriteString(p.Content + "\n")
+			case models.PartTypeReasoning:
+				b.WriteString("<details>\n<summary>Thinking</summary>\n\n" + p.Content + "\n\n</details>\n")
+			case models.PartTypeToolCall:
+				for _, tc := range toolCalls(p) {
+					if tc.FunctionCall == nil {
+						continue
+					}
+					f := fence(tc.FunctionCall.Arguments)
+					fmt.Fprintf(&b, "**Tool call** `%s` (%s)\n\n%sjson\n%s\n%s\n", tc.FunctionCall.Name, tc.ID, f, tc.FunctionCall.Arguments, f)
+				}
+			case models.PartTypeToolResult:
+				f := fence(p.Content)
+				fmt.Fprintf(&b, "**Result** (%s)\n\n%s\n%s\n%s\n", toolCallID(p), f, p.Content, f)
+			case models.PartTypeFile:
+				meta := fileMeta(p)
+				if url := dataURL(p, meta); url != "" && isImage(meta) {
+					fmt.Fprintf(&b, "![%s](%s)\n", meta.Filename, url)
+				} else {
+					fmt.Fprintf(&b, "*Attachment: %s*\n", meta.Filename)
+				}
+			}
+		}
+		if m.Interrupted {
+			b.WriteString("\n*(stopped)*\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlTemplate = template.Must(template.New("conversation").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 52rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+.meta { color: #656d76; font-size: .85rem; }
+.message { border-top: 1px solid #d0d7de; padding: 1rem 0; }
+.role { font-weight: 600; }
+.text { white-space: pre-wrap; }
+pre { background: #f6f8fa; padding: .75rem; overflow-x: auto; white-space: pre-wrap; }
+details { color: #656d76; }
+img { max-width: 100%; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Created {{.Created}}{{if .Model}} · Model: {{.Model}}{{end}}</p>
+{{range .Messages}}<div class="message">
+<div><span class="role">{{.Role}}</span> <span class="meta">{{.Time}}</span></div>
+{{range .Parts}}{{if eq .Kind "text"}}<div class="text">{{.Text}}</div>
+{{else if eq .Kind "reasoning"}}<details><summary>Thinking</summary><div class="text">{{.Text}}</div></details>
+{{else if eq .Kind "tool_call"}}<p>Tool call <code>{{.Name}}</code> <span class="meta">{{.ID}}</span></p><pre>{{.Text}}</pre>
+{{else if eq .Kind "tool_result"}}<p>Result <span class="meta">{{.ID}}</span></p><pre>{{.Text}}</pre>
+{{else if eq .Kind "image"}}<img src="{{.URL}}" alt="{{.Name}}">
+{{else}}<p class="meta">Attachment: {{.Name}}</p>
+{{end}}{{end}}{{if .Interrupted}}<p class="meta">(stopped)</p>{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+type htmlPart struct {
+	Kind, Text, Name, ID string
+	URL                  template.URL
+}
+
+func writeHTML(w io.Writer, doc *Document) error {
+	type htmlMessage struct {
+		Role, Time  string
+		Interrupted bool
+		Parts       []htmlPart
+	}
+	data := struct {
+		Title, Created, Model string
+		Messages              []htmlMessage
+	}{Title: doc.Title, Created: doc.CreatedAt.Format("2006-01-02 15:04"), Model: doc.Model}
+
+	for _, m := range doc.ActivePath() {
+		hm := htmlMessage{Role: roleTitle(m.Role), Time: m.CreatedAt.Format(time.DateTime), Interrupted: m.Interrupted}
+		for _, p := range m.Parts {
+			switch p.Type {
+			case models.PartTypeText, models.PartTypeReasoning:
+				hm.Parts = append(hm.Parts, htmlPart{Kind: string(p.Type), Text: p.Content})
+			case models.PartTypeToolCall:
+				for _, tc := range toolCalls(p) {
+					if tc.FunctionCall != nil {
+						hm.Parts = append(hm.Parts, htmlPart{Kind: "tool_call", Name: tc.FunctionCall.Name, ID: tc.ID, Text: tc.FunctionCall.Arguments})
+					}
+				}
+			case models.PartTypeToolResult:
+				hm.Parts = append(hm.Parts, htmlPart{Kind: "tool_result", ID: toolCallID(p), Text: p.Content})
+			case models.PartTypeFile:
+				meta := fileMeta(p)
+				part := htmlPart{Kind: "file", Name: meta.Filename}
+				// Only data URLs of images and remote URLs are embedded
+				if url := dataURL(p, meta); url != "" && isImage(meta) {
+					part.Kind, part.URL = "image", template.URL(url)
+				}
+				hm.Parts = append(hm.Parts, part)
+			}
+		}
+		data.Messages = append(data.Messages, hm)
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+// openAIMessage is a message of the OpenAI chat format, as used for fine-tuning data.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+// writeOpenAI writes the active branch as one line of OpenAI chat JSONL. Images become
+// image_url parts, documents their extracted text, and reasoning is left out.
+func writeOpenAI(w io.Writer, doc *Document) error {
+	var msgs []openAIMessage
+	for _, m := range doc.ActivePath() {
+		out := openAIMessage{Role: string(m.Role)}
+		if m.Role != models.RoleUser && m.Role != models.RoleAssistant && m.Role != models.RoleSystem {
+			out.Role = "tool"
+		}
+		var parts []openAIContentPart
+		hasImage := false
+		for _, p := range m.Parts {
+			switch p.Type {
+			case models.PartTypeText:
+				parts = append(parts, openAIContentPart{Type: "text", Text: p.Content})
+			case models.PartTypeToolResult:
+				parts = append(parts, openAIContentPart{Type: "text", Text: p.Content})
+				out.ToolCallID = toolCallID(p)
+			case models.PartTypeToolCall:
+				for _, tc := range toolCalls(p) {
+					if tc.FunctionCall == nil {
+						continue
+					}
+					call := openAIToolCall{ID: tc.ID, Type: "function"}
+					call.Function.Name, call.Function.Arguments = tc.FunctionCall.Name, tc.FunctionCall.Arguments
+					out.ToolCalls = append(out.ToolCalls, call)
+				}
+			case models.PartTypeFile:
+				meta := fileMeta(p)
+				if !isImage(meta) {
+					parts = append(parts, openAIContentPart{Type: "text", Text: fmt.Sprintf("[Attachment: %s]\n%s", meta.Filename, meta.Text)})
+					continue
+				}
+				// Images are only sent by users; generated ones are for display
+				url := dataURL(p, meta)
+				if m.Role != models.RoleUser || url == "" {
+					continue
+				}
+				img := openAIContentPart{Type: "image_url", ImageURL: &struct {
+					URL string `json:"url"`
+				}{URL: url}}
+				parts = append(parts, img)
+				hasImage = true
+			}
+		}
+
+		if hasImage {
+			out.Content = parts
+		} else {
+			texts := make([]string, len(parts))
+			for i, p := range parts {
+				texts[i] = p.Text
+			}
+			text := strings.Join(texts, "\n\n")
+			if text != "" || len(out.ToolCalls) == 0 {
+				out.Content = text
+			}
+		}
+		msgs = append(msgs, out)
+	}
+	return json.NewEncoder(w).Encode(struct {
+		Messages []openAIMessage `json:"messages"`
+	}{msgs})
+}
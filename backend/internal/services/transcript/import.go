@@ -0,0 +1,668 @@
+package transcript
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/memory"
+
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+// ErrUnrecognized is returned for files that are not a known export format.
+var ErrUnrecognized = errors.New("not a JSON, ChatGPT or Claude conversation export")
+
+// maxZipBytes bounds the data decompressed from all files of an imported zip together,
+// so a small archive cannot expand into gigabytes.
+const maxZipBytes = 256 << 20
+
+// ErrZipTooLarge is returned for zips holding more than maxZipBytes once decompressed.
+var ErrZipTooLarge = fmt.Errorf("zip holds more than %d MB once decompressed", maxZipBytes>>20)
+
+// Parse reads conversations from an export file: a JSON export of this application
+// (one conversation or a list), the conversations.json of a ChatGPT or Claude export,
+// or a zip holding any of them, such as the bulk export or the archives ChatGPT and
+// Claude send. Conversations are decoded one at a time and passed to fn, so the export
+// is never held in memory as a whole; an error from fn stops the import.
+func Parse(r io.ReaderAt, size int64, fn func(*Document) error) error {
+	magic := make([]byte, 4)
+	if n, _ := r.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, []byte("PK\x03\x04")) {
+		return parseZip(r, size, fn)
+	}
+	_, err := parseJSON(io.NewSectionReader(r, 0, size), fn)
+	return err
+}
+
+func parseZip(r io.ReaderAt, size int64, fn func(*Document) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip file: %w", err)
+	}
+	budget := &zipBudget{left: maxZipBytes}
+	found := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.EqualFold(path.Ext(f.Name), ".json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		n, err := parseJSON(&zipEntry{r: rc, budget: budget}, fn)
+		rc.Close()
+		found += n
+		if errors.Is(err, ErrUnrecognized) && n == 0 {
+			// Exports also hold user profiles, feedback and the like
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	if found == 0 {
+		return ErrUnrecognized
+	}
+	return nil
+}
+
+// zipBudget is what may still be decompressed from the files of a zip.
+type zipBudget struct {
+	left int64
+}
+
+// zipEntry reads a zip file, charging what it reads to the zip's budget.
+type zipEntry struct {
+	r      io.Reader
+	budget *zipBudget
+}
+
+func (e *zipEntry) Read(p []byte) (int, error) {
+	if e.budget.left <= 0 {
+		return 0, ErrZipTooLarge
+	}
+	if int64(len(p)) > e.budget.left {
+		p = p[:e.budget.left]
+	}
+	n, err := e.r.Read(p)
+	e.budget.left -= int64(n)
+	return n, err
+}
+
+// exportProbe holds the fields that tell the formats apart.
+type exportProbe struct {
+	Format       string          `json:"format"`
+	Mapping      json.RawMessage `json:"mapping"`
+	ChatMessages json.RawMessage `json:"chat_messages"`
+}
+
+// parseJSON decodes one conversation or a list of them from r, passing each to fn, and
+// returns how many were passed.
+func parseJSON(r io.Reader, fn func(*Document) error) (int, error) {
+	br := bufio.NewReader(r)
+	first, err := firstByte(br)
+	if err != nil {
+		return 0, ErrUnrecognized
+	}
+	dec := json.NewDecoder(br)
+	switch first {
+	case '{':
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return 0, fmt.Errorf("invalid JSON: %w", err)
+		}
+		doc, err := parseItem(item)
+		if err != nil {
+			return 0, err
+		}
+		if err := fn(doc); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	case '[':
+		if _, err := dec.Token(); err != nil {
+			return 0, fmt.Errorf("invalid JSON: %w", err)
+		}
+		n := 0
+		for dec.More() {
+			var item json.RawMessage
+			if err := dec.Decode(&item); err != nil {
+				return n, fmt.Errorf("invalid JSON: %w", err)
+			}
+			doc, err := parseItem(item)
+			if errors.Is(err, ErrUnrecognized) {
+				return n, err
+			}
+			if err != nil {
+				return n, fmt.Errorf("conversation %d: %w", n+1, err)
+			}
+			if err := fn(doc); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if _, err := dec.Token(); err != nil {
+			return n, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return n, nil
+	}
+	return 0, ErrUnrecognized
+}
+
+// firstByte skips leading white space and returns the next byte without consuming it.
+func firstByte(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// parseItem decodes a single conversation in any of the supported formats.
+func parseItem(item json.RawMessage) (*Document, error) {
+	var probe exportProbe
+	if err := json.Unmarshal(item, &probe); err != nil {
+		return nil, ErrUnrecognized
+	}
+	switch {
+	case probe.Format == FormatName:
+		doc := &Document{}
+		if err := json.Unmarshal(item, doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	case len(probe.Mapping) > 0:
+		return parseChatGPT(item)
+	case len(probe.ChatMessages) > 0:
+		return parseClaude(item)
+	}
+	return nil, ErrUnrecognized
+}
+
+// builder assigns IDs to the messages of an imported conversation.
+type builder struct {
+	doc *Document
+}
+
+func (b *builder) add(parent uint, role models.MessageRole, at time.Time, parts ...Part) uint {
+	id := uint(len(b.doc.Messages) + 1)
+	b.doc.Messages = append(b.doc.Messages, Message{ID: id, ParentID: parent, Role: role, CreatedAt: at, Parts: parts})
+	return id
+}
+
+func textPart(s string) Part {
+	return Part{Type: models.PartTypeText, Content: s}
+}
+
+func toolCallsPart(calls []llms.ToolCall) Part {
+	b, _ := json.Marshal(calls)
+	return Part{Type: models.PartTypeToolCall, Content: string(b)}
+}
+
+func toolResultPart(callID, output string) Part {
+	meta, _ := json.Marshal(map[string]string{"tool_call_id": callID})
+	return Part{Type: models.PartTypeToolResult, Content: output, Meta: meta}
+}
+
+func documentPart(name, mime, text string) Part {
+	meta, _ := json.Marshal(models.FilePartMeta{Mime: mime, Filename: name, Text: text, Size: int64(len(text))})
+	return Part{Type: models.PartTypeFile, Content: base64.StdEncoding.EncodeToString([]byte(text)), Meta: meta}
+}
+
+func toolCall(id, name, args string) llms.ToolCall {
+	return llms.ToolCall{ID: id, Type: "function", FunctionCall: &llms.FunctionCall{Name: name, Arguments: args}}
+}
+
+// chatGPTConversation is a conversation of a ChatGPT export. Messages form a tree in
+// mapping; current_node is the end of the branch shown last.
+type chatGPTConversation struct {
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	CurrentNode string                 `json:"current_node"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+	Message  *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+		Thoughts    []struct {
+			Summary string `json:"summary"`
+			Content string `json:"content"`
+		} `json:"thoughts"`
+	} `json:"content"`
+	Recipient string `json:"recipient"`
+	Metadata  struct {
+		Hidden bool `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// text joins the text parts of a message; images, which the export keeps as separate
+// files, are marked by a placeholder.
+func (m *chatGPTMessage) text() string {
+	if m.Content.Text != "" {
+		return m.Content.Text
+	}
+	var out []string
+	for _, raw := range m.Content.Parts {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			if s != "" {
+				out = append(out, s)
+			}
+			continue
+		}
+		var obj struct {
+			ContentType string `json:"content_type"`
+		}
+		if json.Unmarshal(raw, &obj) == nil && strings.Contains(obj.ContentType, "image") {
+			out = append(out, "[image]")
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func unixTime(sec float64, fallback time.Time) time.Time {
+	if sec <= 0 {
+		return fallback
+	}
+	return time.Unix(0, int64(sec*float64(time.Second)))
+}
+
+// parseChatGPT converts a ChatGPT conversation. Code and tool messages addressed to
+// tools become tool calls, their outputs tool results, and thoughts the reasoning of
+// the next reply. Hidden and system messages are left out.
+func parseChatGPT(data []byte) (*Document, error) {
+	var conv chatGPTConversation
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, err
+	}
+	created := unixTime(conv.CreateTime, time.Now())
+	b := &builder{doc: &Document{Title: conv.Title, CreatedAt: created}}
+	ids := make(map[string]uint, len(conv.Mapping))
+	calls := make(map[uint]string) // assistant message -> its tool call
+
+	type frame struct {
+		node      string
+		parent    uint
+		reasoning string
+	}
+	// Depth-first from the roots, so parents are added before their children
+	var roots []string
+	for id, n := range conv.Mapping {
+		if _, ok := conv.Mapping[n.Parent]; n.Parent == "" || !ok {
+			roots = append(roots, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(roots)))
+	var stack []frame
+	for _, id := range roots {
+		stack = append(stack, frame{node: id})
+	}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n := conv.Mapping[f.node]
+		id, reasoning := f.parent, f.reasoning
+
+		if m := n.Message; m != nil && !m.Metadata.Hidden {
+			at := unixTime(m.CreateTime, created)
+			text := m.text()
+			switch {
+			case m.Author.Role == "user" && text != "":
+				id = b.add(f.parent, models.RoleUser, at, textPart(text))
+			case m.Author.Role == "assistant" && m.Content.ContentType == "thoughts":
+				for _, t := range m.Content.Thoughts {
+					reasoning = strings.TrimSpace(reasoning + "\n\n" + t.Content)
+				}
+			case m.Author.Role == "assistant" && (m.Content.ContentType == "code" || m.Recipient != "" && m.Recipient != "all"):
+				callID := "call_" + f.node
+				args, _ := json.Marshal(map[string]string{"input": text})
+				if m.Content.ContentType == "code" {
+					args, _ = json.Marshal(map[string]string{"code": text})
+				}
+				name := m.Recipient
+				if name == "" || name == "all" {
+					name = "python"
+				}
+				var parts []Part
+				if reasoning != "" {
+					parts = append(parts, Part{Type: models.PartTypeReasoning, Content: reasoning})
+				}
+				parts = append(parts, toolCallsPart([]llms.ToolCall{toolCall(callID, name, string(args))}))
+				id, reasoning = b.add(f.parent, models.RoleAssistant, at, parts...), ""
+				calls[id] = callID
+			case m.Author.Role == "assistant" && text != "":
+				var parts []Part
+				if reasoning != "" {
+					parts = append(parts, Part{Type: models.PartTypeReasoning, Content: reasoning})
+				}
+				parts = append(parts, textPart(text))
+				id, reasoning = b.add(f.parent, models.RoleAssistant, at, parts...), ""
+			case m.Author.Role == "tool" && calls[f.parent] != "":
+				id = b.add(f.parent, "tool", at, toolResultPart(calls[f.parent], text))
+			}
+		}
+		ids[f.node] = id
+		// Children are visited in order: push them in reverse
+		for i := len(n.Children) - 1; i >= 0; i-- {
+			if _, ok := conv.Mapping[n.Children[i]]; ok {
+				stack = append(stack, frame{node: n.Children[i], parent: id, reasoning: reasoning})
+			}
+		}
+	}
+	b.doc.ActiveID = ids[conv.CurrentNode]
+	return b.doc, nil
+}
+
+// claudeConversation is a conversation of a Claude export.
+type claudeConversation struct {
+	Name         string          `json:"name"`
+	CreatedAt    time.Time       `json:"created_at"`
+	CurrentLeaf  string          `json:"current_leaf_message_uuid"`
+	ChatMessages []claudeMessage `json:"chat_messages"`
+}
+
+type claudeMessage struct {
+	UUID       string    `json:"uuid"`
+	ParentUUID string    `json:"parent_message_uuid"`
+	Sender     string    `json:"sender"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+	Content    []struct {
+		Type     string          `json:"type"`
+		Text     string          `json:"text"`
+		Thinking string          `json:"thinking"`
+		ID       string          `json:"id"`
+		Name     string          `json:"name"`
+		Input    json.RawMessage `json:"input"`
+		Content  json.RawMessage `json:"content"`
+	} `json:"content"`
+	Attachments []struct {
+		FileName         string `json:"file_name"`
+		FileType         string `json:"file_type"`
+		ExtractedContent string `json:"extracted_content"`
+	} `json:"attachments"`
+	Files []struct {
+		FileName string `json:"file_name"`
+	} `json:"files"`
+}
+
+// resultText flattens the content of a Claude tool_result block.
+func resultText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(raw, &blocks)
+	var out []string
+	for _, bl := range blocks {
+		if bl.Text != "" {
+			out = append(out, bl.Text)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// parseClaude converts a Claude conversation. A reply that used tools is split into an
+// assistant message per step, each followed by the results of its tool calls.
+func parseClaude(data []byte) (*Document, error) {
+	var conv claudeConversation
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, err
+	}
+	b := &builder{doc: &Document{Title: conv.Name, CreatedAt: conv.CreatedAt}}
+	last := make(map[string]uint, len(conv.ChatMessages)) // message UUID -> its last message
+	var prev uint
+	for _, cm := range conv.ChatMessages {
+		parent := prev
+		if cm.ParentUUID != "" {
+			parent = last[cm.ParentUUID]
+		}
+		at := cm.CreatedAt
+		if at.IsZero() {
+			at = conv.CreatedAt
+		}
+
+		if cm.Sender == "human" {
+			text := cm.Text
+			for _, c := range cm.Content {
+				if c.Type == "text" && text == "" {
+					text = c.Text
+				}
+			}
+			for _, f := range cm.Files {
+				text = strings.TrimSpace(text + "\n[file: " + f.FileName + "]")
+			}
+			var parts []Part
+			if text != "" {
+				parts = append(parts, textPart(text))
+			}
+			for _, a := range cm.Attachments {
+				parts = append(parts, documentPart(a.FileName, "text/plain", a.ExtractedContent))
+			}
+			if len(parts) > 0 {
+				prev = b.add(parent, models.RoleUser, at, parts...)
+			}
+			last[cm.UUID] = prev
+			continue
+		}
+
+		// Assistant: text and tool calls accumulate until a tool result ends the step
+		var parts []Part
+		var calls []llms.ToolCall
+		var pending []string // calls without a result yet
+		flush := func() {
+			if len(calls) > 0 {
+				parts = append(parts, toolCallsPart(calls))
+			}
+			if len(parts) > 0 {
+				parent = b.add(parent, models.RoleAssistant, at, parts...)
+			}
+			parts, calls = nil, nil
+		}
+		for i, c := range cm.Content {
+			switch c.Type {
+			case "text":
+				if c.Text != "" {
+					parts = append(parts, textPart(c.Text))
+				}
+			case "thinking":
+				if c.Thinking != "" {
+					parts = append(parts, Part{Type: models.PartTypeReasoning, Content: c.Thinking})
+				}
+			case "tool_use":
+				id := c.ID
+				if id == "" {
+					id = fmt.Sprintf("toolu_%s_%d", cm.UUID, i)
+				}
+				args := string(c.Input)
+				if args == "" {
+					args = "{}"
+				}
+				calls = append(calls, toolCall(id, c.Name, args))
+				pending = append(pending, id)
+			case "tool_result":
+				if len(pending) == 0 && len(calls) == 0 {
+					continue
+				}
+				flush()
+				if len(pending) > 0 {
+					parent = b.add(parent, "tool", at, toolResultPart(pending[0], resultText(c.Content)))
+					pending = pending[1:]
+				}
+			}
+		}
+		if len(cm.Content) == 0 && cm.Text != "" {
+			parts = append(parts, textPart(cm.Text))
+		}
+		flush()
+		// Calls the export has no result for still need one for the history to be valid
+		for _, id := range pending {
+			parent = b.add(parent, "tool", at, toolResultPart(id, "(no result in export)"))
+		}
+		prev = parent
+		last[cm.UUID] = prev
+	}
+	if conv.CurrentLeaf != "" {
+		b.doc.ActiveID = last[conv.CurrentLeaf]
+	}
+	return b.doc, nil
+}
+
+// Save stores doc as a new conversation of the user with the given model config.
+// Message IDs of doc are replaced; the active branch is kept.
+func Save(ctx context.Context, db *gorm.DB, userID, modelID uint, doc *Document) (*models.Session, error) {
+	title := strings.TrimSpace(doc.Title)
+	if title == "" {
+		title = "Imported conversation"
+	}
+	if r := []rune(title); len(r) > 200 {
+		title = string(r[:200])
+	}
+	session := models.Session{
+		Title:       title,
+		TitleSource: models.TitleSourceUser,
+		ModelID:     modelID,
+		UserID:      userID,
+		Folder:      doc.Folder,
+		Tags:        doc.Tags,
+		CreatedAt:   doc.CreatedAt,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		hist := memory.NewSQLiteHistory(tx, session.ID)
+		ids := make(map[uint]uint, len(doc.Messages))
+		for _, m := range doc.Messages {
+			parent := ids[m.ParentID]
+			msg, ok := chatMessage(m)
+			if !ok {
+				ids[m.ID] = parent
+				continue
+			}
+			saved, err := hist.SaveMessageUnder(ctx, parent, msg)
+			if err != nil {
+				return err
+			}
+			ids[m.ID] = saved.ID
+			updates := map[string]interface{}{"interrupted": m.Interrupted}
+			if !m.CreatedAt.IsZero() {
+				updates["created_at"] = m.CreatedAt
+			}
+			if err := tx.Model(saved).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if leaf := ids[doc.ActiveID]; leaf != 0 {
+			return hist.Activate(ctx, leaf)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// chatMessage converts a message for saving with its parts mapped back to part types.
+// System messages and messages left empty are skipped.
+func chatMessage(m Message) (llms.ChatMessage, bool) {
+	var texts []string
+	var reasoning, result, callID string
+	var calls []llms.ToolCall
+	var remote []llms.ContentPart
+	var attachments []memory.Attachment
+	for _, p := range m.Parts {
+		switch p.Type {
+		case models.PartTypeText:
+			texts = append(texts, p.Content)
+		case models.PartTypeReasoning:
+			reasoning = p.Content
+		case models.PartTypeToolCall:
+			calls = append(calls, toolCalls(p)...)
+		case models.PartTypeToolResult:
+			result, callID = p.Content, toolCallID(p)
+		case models.PartTypeFile:
+			meta := fileMeta(p)
+			if strings.HasPrefix(p.Content, "http") {
+				remote = append(remote, llms.ImageURLPart(p.Content))
+				continue
+			}
+			data, err := base64.StdEncoding.DecodeString(p.Content)
+			if err != nil || (len(data) == 0 && meta.Text == "") {
+				continue
+			}
+			attachments = append(attachments, memory.Attachment{
+				Filename:  meta.Filename,
+				Mime:      meta.Mime,
+				Data:      data,
+				Text:      meta.Text,
+				Pages:     meta.Pages,
+				Truncated: meta.Truncated,
+			})
+		}
+	}
+	text := strings.Join(texts, "\n\n")
+
+	switch m.Role {
+	case models.RoleUser:
+		if text == "" && len(remote) == 0 && len(attachments) == 0 {
+			return nil, false
+		}
+		if len(remote) > 0 {
+			// Remote images are saved from the parts, so the text must be one too
+			remote = append([]llms.ContentPart{llms.TextPart(text)}, remote...)
+		}
+		return memory.MultiModalMessage{Type: llms.ChatMessageTypeHuman, Content: text, Parts: remote, Attachments: attachments}, true
+	case models.RoleAssistant:
+		if text == "" && reasoning == "" && len(calls) == 0 && len(attachments) == 0 {
+			return nil, false
+		}
+		if len(attachments) > 0 && len(calls) == 0 {
+			return memory.MultiModalMessage{Type: llms.ChatMessageTypeAI, Content: text, Attachments: attachments}, true
+		}
+		return llms.AIChatMessage{Content: text, ReasoningContent: reasoning, ToolCalls: calls}, true
+	case models.RoleSystem:
+		return nil, false
+	}
+	if callID == "" {
+		return nil, false
+	}
+	return memory.MultiModalMessage{Type: llms.ChatMessageTypeTool, Content: result, ToolCallID: callID, Attachments: attachments}, true
+}
@@ -0,0 +1,95 @@
+package transcript
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/memory"
+
+	"gorm.io/gorm"
+)
+
+// RedactedOutput replaces tool results in shared conversations that redact them.
+const RedactedOutput = "[redacted]"
+
+// LoadShared reads what Sanitize keeps of a session: only the messages of the active
+// branch are loaded, and only images have their data inlined.
+func LoadShared(ctx context.Context, db *gorm.DB, session models.Session) (*Document, error) {
+	hist := memory.NewSQLiteHistory(db, session.ID)
+	tree, err := hist.Tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := tree.Path(tree.Leaf())
+	var msgs []models.Message
+	if len(ids) > 0 {
+		if err := db.Where("id IN ?", ids).Preload("Parts").Find(&msgs).Error; err != nil {
+			return nil, err
+		}
+	}
+	order := make(map[uint]int, len(ids))
+	for i, id := range ids {
+		order[id] = i
+	}
+	sort.Slice(msgs, func(i, j int) bool { return order[msgs[i].ID] < order[msgs[j].ID] })
+
+	return newDocument(session, tree.Leaf(), msgs, func(p models.Part) (Part, error) {
+		var meta models.FilePartMeta
+		_ = json.Unmarshal(p.Meta, &meta)
+		if !isImage(meta) {
+			meta.Hash, meta.URL = "", ""
+			b, err := json.Marshal(meta)
+			return Part{Type: p.Type, Meta: b}, err
+		}
+		return inlineFile(hist.Files, p)
+	})
+}
+
+// Sanitize returns the active branch of doc as shown to people it is shared with.
+// Other branches, the folder, tags and original message IDs are dropped, and so is
+// the data of documents, which keep their file name. With redactTools the results of
+// tool calls and the files tools returned are replaced by RedactedOutput.
+func Sanitize(doc *Document, redactTools bool) *Document {
+	out := &Document{
+		Format:    doc.Format,
+		Version:   doc.Version,
+		Title:     doc.Title,
+		Model:     doc.Model,
+		CreatedAt: doc.CreatedAt,
+	}
+	for _, m := range doc.ActivePath() {
+		if m.Role == models.RoleSystem {
+			continue
+		}
+		id := uint(len(out.Messages) + 1)
+		msg := Message{ID: id, Role: m.Role, CreatedAt: m.CreatedAt, Interrupted: m.Interrupted, Parts: make([]Part, 0, len(m.Parts))}
+		if id > 1 {
+			msg.ParentID = id - 1
+		}
+		redact := redactTools && m.Role != models.RoleUser && m.Role != models.RoleAssistant
+		for _, p := range m.Parts {
+			switch {
+			case redact && p.Type == models.PartTypeToolResult:
+				p.Content = RedactedOutput
+			case redact && p.Type == models.PartTypeFile:
+				continue
+			case p.Type == models.PartTypeFile:
+				meta := fileMeta(p)
+				if isImage(meta) {
+					break
+				}
+				meta.Text, meta.Pages, meta.Truncated = "", 0, false
+				b, _ := json.Marshal(meta)
+				p.Content, p.Meta = "", b
+			}
+			msg.Parts = append(msg.Parts, p)
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+	if n := len(out.Messages); n > 0 {
+		out.ActiveID = out.Messages[n-1].ID
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+// Package transcript exports conversations as Markdown, JSON, HTML and OpenAI chat
+// JSONL, and imports JSON exports of this application, ChatGPT and Claude into new
+// conversations.
+package transcript
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/memory"
+	"fnchatbot/internal/storage"
+
+	"gorm.io/gorm"
+)
+
+// FormatName identifies JSON exports of this application.
+const FormatName = "fnchatbot"
+
+// FormatVersion is the version of the JSON export schema.
+const FormatVersion = 1
+
+// Document is a conversation with every branch of its message tree. It is the JSON
+// export format, and imports from other applications are converted to it first.
+type Document struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Title     string    `json:"title"`
+	Model     string    `json:"model,omitempty"`
+	Folder    string    `json:"folder,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// ActiveID is the last message of the active branch; 0 means the last message
+	ActiveID uint      `json:"active_message_id,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+// Message is a message of a Document. Parents come before their children.
+type Message struct {
+	ID          uint               `json:"id"`
+	ParentID    uint               `json:"parent_id,omitempty"`
+	Role        models.MessageRole `json:"role"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Interrupted bool               `json:"interrupted,omitempty"`
+	Parts       []Part             `json:"parts"`
+}
+
+// Part is a message part. Content holds the text, the tool calls as stored, or the
+// base64 data of a file; file metadata is kept in Meta.
+type Part struct {
+	Type    models.PartType `json:"type"`
+	Content string          `json:"content"`
+	Meta    json.RawMessage `json:"meta,omitempty"`
+}
+
+// Load reads a session into a Document, with the data of attached files inlined.
+func Load(ctx context.Context, db *gorm.DB, session models.Session) (*Document, error) {
+	hist := memory.NewSQLiteHistory(db, session.ID)
+	msgs, tree, err := hist.TreeMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newDocument(session, tree.Leaf(), msgs, func(p models.Part) (Part, error) {
+		return inlineFile(hist.Files, p)
+	})
+}
+
+// newDocument converts the messages of session into a Document; file parts are
+// converted by file.
+func newDocument(session models.Session, activeID uint, msgs []models.Message, file func(models.Part) (Part, error)) (*Document, error) {
+	doc := &Document{
+		Format:    FormatName,
+		Version:   FormatVersion,
+		Title:     session.Title,
+		Model:     session.Model.Model,
+		Folder:    session.Folder,
+		Tags:      session.Tags,
+		CreatedAt: session.CreatedAt,
+		ActiveID:  activeID,
+		Messages:  make([]Message, 0, len(msgs)),
+	}
+	for _, m := range msgs {
+		out := Message{
+			ID:          m.ID,
+			Role:        m.Role,
+			CreatedAt:   m.CreatedAt,
+			Interrupted: m.Interrupted,
+			Parts:       make([]Part, 0, len(m.Parts)),
+		}
+		if m.ParentID != nil {
+			out.ParentID = *m.ParentID
+		}
+		for _, p := range m.Parts {
+			part := Part{Type: p.Type, Content: p.Content, Meta: json.RawMessage(p.Meta)}
+			if p.Type == models.PartTypeFile {
+				var err error
+				if part, err = file(p); err != nil {
+					return nil, err
+				}
+			}
+			out.Parts = append(out.Parts, part)
+		}
+		doc.Messages = append(doc.Messages, out)
+	}
+	return doc, nil
+}
+
+// inlineFile replaces a stored file's hash with its base64 data, so exports are
+// self-contained. Files missing from the store are exported without data.
+func inlineFile(files *storage.FileStore, p models.Part) (Part, error) {
+	var meta models.FilePartMeta
+	_ = json.Unmarshal(p.Meta, &meta)
+	content := p.Content
+	if meta.Hash != "" {
+		content = ""
+		if files != nil {
+			if data, err := files.Get(meta.Hash); err == nil {
+				content = base64.StdEncoding.EncodeToString(data)
+			}
+		}
+		meta.Hash, meta.URL = "", ""
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return Part{}, err
+	}
+	return Part{Type: p.Type, Content: content, Meta: b}, nil
+}
+
+// ActivePath returns the messages of the active branch of doc.
+func (doc *Document) ActivePath() []Message {
+	if len(doc.Messages) == 0 {
+		return nil
+	}
+	byID := make(map[uint]int, len(doc.Messages))
+	for i, m := range doc.Messages {
+		byID[m.ID] = i
+	}
+	leaf := doc.ActiveID
+	if _, ok := byID[leaf]; !ok {
+		leaf = doc.Messages[len(doc.Messages)-1].ID
+	}
+	var path []Message
+	for id := leaf; id != 0 && len(path) <= len(doc.Messages); {
+		i, ok := byID[id]
+		if !ok {
+			break
+		}
+		path = append(path, doc.Messages[i])
+		id = doc.Messages[i].ParentID
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// fileMeta decodes the metadata of a file part.
+func fileMeta(p Part) models.FilePartMeta {
+	var meta models.FilePartMeta
+	_ = json.Unmarshal(p.Meta, &meta)
+	if meta.Filename == "" {
+		meta.Filename = "file"
+	}
+	return meta
+}
+
+// isImage reports whether a file part holds an image rather than a document.
+func isImage(meta models.FilePartMeta) bool {
+	return meta.Text == "" && (meta.Mime == "" || strings.HasPrefix(meta.Mime, "image/"))
+}
+
+// dataURL returns a file part as a data URL, its remote URL, or "" when the export has
+// no data for it.
+func dataURL(p Part, meta models.FilePartMeta) string {
+	if p.Content == "" || strings.HasPrefix(p.Content, "http") {
+		return p.Content
+	}
+	mime := meta.Mime
+	if mime == "" {
+		mime = "image/jpeg"
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mime, p.Content)
+}
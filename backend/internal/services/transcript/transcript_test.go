@@ -0,0 +1,320 @@
+package transcript
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"fnchatbot/internal/models"
+	"fnchatbot/internal/services/memory"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"gorm.io/gorm"
+)
+
+func setupDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Session{}, &models.Message{}, &models.Part{}))
+	return db
+}
+
+// parseAll collects the conversations Parse finds in data.
+func parseAll(data []byte) ([]*Document, error) {
+	var docs []*Document
+	err := Parse(bytes.NewReader(data), int64(len(data)), func(doc *Document) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	return docs, err
+}
+
+// texts returns the role and text of each message on the active branch.
+func texts(doc *Document) []string {
+	var out []string
+	for _, m := range doc.ActivePath() {
+		var s []string
+		for _, p := range m.Parts {
+			if p.Type == models.PartTypeText || p.Type == models.PartTypeToolResult {
+				s = append(s, p.Content)
+			}
+		}
+		out = append(out, string(m.Role)+":"+strings.Join(s, "|"))
+	}
+	return out
+}
+
+// seedConversation stores a conversation with an image, a tool call and two answers to
+// the first question; the first answer is active.
+func seedConversation(t *testing.T, db *gorm.DB) models.Session {
+	ctx := context.Background()
+	session := models.Session{Title: "Weather", UserID: 1, Tags: []string{"travel"}}
+	require.NoError(t, db.Create(&session).Error)
+	h := memory.NewSQLiteHistory(db, session.ID)
+	h.Files = nil
+
+	q, err := h.SaveMessage(ctx, memory.MultiModalMessage{
+		Type:    llms.ChatMessageTypeHuman,
+		Content: "Weather here?",
+		Attachments: []memory.Attachment{
+			{Filename: "photo.png", Mime: "image/png", Data: []byte("PNGDATA")},
+		},
+	})
+	require.NoError(t, err)
+	_, err = h.SaveMessage(ctx, llms.AIChatMessage{ReasoningContent: "Need the forecast", ToolCalls: []llms.ToolCall{
+		{ID: "call_1", Type: "function", FunctionCall: &llms.FunctionCall{Name: "weather", Arguments: `{"city":"Oslo"}`}},
+	}})
+	require.NoError(t, err)
+	_, err = h.SaveMessage(ctx, memory.MultiModalMessage{Type: llms.ChatMessageTypeTool, Content: "rain", ToolCallID: "call_1"})
+	require.NoError(t, err)
+	answer, err := h.SaveMessage(ctx, llms.AIChatMessage{Content: "It rains in Oslo."})
+	require.NoError(t, err)
+
+	_, err = h.SaveMessageUnder(ctx, q.ID, llms.AIChatMessage{Content: "I cannot tell."})
+	require.NoError(t, err)
+	require.NoError(t, h.Activate(ctx, answer.ID))
+	return session
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	db := setupDB(t)
+	ctx := context.Background()
+	doc, err := Load(ctx, db, seedConversation(t, db))
+	require.NoError(t, err)
+	require.Len(t, doc.Messages, 5)
+	want := []string{"user:Weather here?", "assistant:", "tool:rain", "assistant:It rains in Oslo."}
+	assert.Equal(t, want, texts(doc))
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, doc, FormatJSON))
+	docs, err := parseAll(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+
+	session, err := Save(ctx, db, 2, 7, docs[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Weather", session.Title)
+	assert.Equal(t, models.TitleSourceUser, session.TitleSource)
+	assert.Equal(t, []string{"travel"}, []string(session.Tags))
+
+	imported, err := Load(ctx, db, *session)
+	require.NoError(t, err)
+	require.Len(t, imported.Messages, 5)
+	assert.Equal(t, want, texts(imported))
+
+	// The attachment and tool call survive
+	path := imported.ActivePath()
+	var file Part
+	for _, p := range path[0].Parts {
+		if p.Type == models.PartTypeFile {
+			file = p
+		}
+	}
+	assert.Equal(t, "photo.png", fileMeta(file).Filename)
+	assert.Equal(t, "data:image/png;base64,UE5HREFUQQ==", dataURL(file, fileMeta(file)))
+	calls := toolCalls(path[1].Parts[1])
+	require.Len(t, calls, 1)
+	assert.Equal(t, "weather", calls[0].FunctionCall.Name)
+	assert.Equal(t, "Need the forecast", path[1].Parts[0].Content)
+
+	// The other branch can still be switched to
+	hist := memory.NewSQLiteHistory(db, session.ID)
+	tree, err := hist.Tree(ctx)
+	require.NoError(t, err)
+	assert.Len(t, tree.Siblings(path[1].ID), 2)
+}
+
+func TestWriteFormats(t *testing.T) {
+	db := setupDB(t)
+	doc, err := Load(context.Background(), db, seedConversation(t, db))
+	require.NoError(t, err)
+
+	var md bytes.Buffer
+	require.NoError(t, Write(&md, doc, FormatMarkdown))
+	assert.Contains(t, md.String(), "# Weather")
+	assert.Contains(t, md.String(), "**Tool call** `weather` (call_1)")
+	assert.Contains(t, md.String(), "![photo.png](data:image/png;base64,")
+	assert.Contains(t, md.String(), "It rains in Oslo.")
+	assert.NotContains(t, md.String(), "I cannot tell.")
+
+	var html bytes.Buffer
+	require.NoError(t, Write(&html, doc, FormatHTML))
+	assert.Contains(t, html.String(), `<code>weather</code>`)
+	assert.Contains(t, html.String(), `<img src="data:image/png;base64,`)
+
+	var jsonl bytes.Buffer
+	require.NoError(t, Write(&jsonl, doc, FormatOpenAIJSONL))
+	var line struct {
+		Messages []struct {
+			Role       string           `json:"role"`
+			Content    json.RawMessage  `json:"content"`
+			ToolCalls  []openAIToolCall `json:"tool_calls"`
+			ToolCallID string           `json:"tool_call_id"`
+		} `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal(jsonl.Bytes(), &line))
+	require.Len(t, line.Messages, 4)
+	assert.Contains(t, string(line.Messages[0].Content), `"image_url"`)
+	assert.Equal(t, "weather", line.Messages[1].ToolCalls[0].Function.Name)
+	assert.Equal(t, "null", string(line.Messages[1].Content))
+	assert.Equal(t, "tool", line.Messages[2].Role)
+	assert.Equal(t, "call_1", line.Messages[2].ToolCallID)
+
+	assert.Error(t, Write(&md, doc, "pdf"))
+}
+
+const chatGPTExport = `[{
+  "title": "Sorting",
+  "create_time": 1700000000.5,
+  "current_node": "a2",
+  "mapping": {
+    "root": {"id": "root", "parent": null, "children": ["sys"], "message": null},
+    "sys": {"id": "sys", "parent": "root", "children": ["u1"], "message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}, "metadata": {"is_visually_hidden_from_conversation": true}}},
+    "u1": {"id": "u1", "parent": "sys", "children": ["a1", "code"], "message": {"author": {"role": "user"}, "create_time": 1700000001, "content": {"content_type": "text", "parts": ["Sort [3,1,2]"]}}},
+    "a1": {"id": "a1", "parent": "u1", "children": [], "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["[1,2,3]"]}}},
+    "code": {"id": "code", "parent": "u1", "children": ["out"], "message": {"author": {"role": "assistant"}, "recipient": "python", "content": {"content_type": "code", "text": "sorted([3,1,2])"}}},
+    "out": {"id": "out", "parent": "code", "children": ["a2"], "message": {"author": {"role": "tool"}, "content": {"content_type": "execution_output", "text": "[1, 2, 3]"}}},
+    "a2": {"id": "a2", "parent": "out", "children": [], "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Sorted: [1,2,3]"]}}}
+  }
+}]`
+
+func TestParseChatGPT(t *testing.T) {
+	// ChatGPT sends its export as a zip
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("conversations.json")
+	require.NoError(t, err)
+	_, _ = w.Write([]byte(chatGPTExport))
+	w, err = zw.Create("user.json")
+	require.NoError(t, err)
+	_, _ = w.Write([]byte(`{"id": "user-1", "email": "a@example.com"}`))
+	require.NoError(t, zw.Close())
+
+	docs, err := parseAll(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	doc := docs[0]
+	assert.Equal(t, "Sorting", doc.Title)
+	assert.Equal(t, int64(1700000000), doc.CreatedAt.Unix())
+	require.Len(t, doc.Messages, 5)
+	assert.Equal(t, []string{"user:Sort [3,1,2]", "assistant:", "tool:[1, 2, 3]", "assistant:Sorted: [1,2,3]"}, texts(doc))
+
+	calls := toolCalls(doc.ActivePath()[1].Parts[0])
+	require.Len(t, calls, 1)
+	assert.Equal(t, "python", calls[0].FunctionCall.Name)
+	assert.JSONEq(t, `{"code":"sorted([3,1,2])"}`, calls[0].FunctionCall.Arguments)
+	assert.Equal(t, calls[0].ID, toolCallID(doc.ActivePath()[2].Parts[0]))
+
+	db := setupDB(t)
+	session, err := Save(context.Background(), db, 1, 0, doc)
+	require.NoError(t, err)
+	imported, err := Load(context.Background(), db, *session)
+	require.NoError(t, err)
+	assert.Equal(t, texts(doc), texts(imported))
+}
+
+const claudeExport = `[{
+  "uuid": "c1",
+  "name": "Files",
+  "created_at": "2024-05-01T10:00:00Z",
+  "chat_messages": [
+    {"uuid": "m1", "sender": "human", "text": "Summarize this", "created_at": "2024-05-01T10:00:00Z",
+     "content": [{"type": "text", "text": "Summarize this"}],
+     "attachments": [{"file_name": "notes.txt", "file_type": "txt", "extracted_content": "Buy milk"}]},
+    {"uuid": "m2", "sender": "assistant", "created_at": "2024-05-01T10:00:05Z", "content": [
+      {"type": "thinking", "thinking": "Look it up"},
+      {"type": "tool_use", "id": "toolu_1", "name": "search", "input": {"q": "milk"}},
+      {"type": "tool_result", "name": "search", "content": [{"type": "text", "text": "Milk is white"}]},
+      {"type": "text", "text": "Buy milk, which is white."}
+    ]}
+  ]
+}]`
+
+func TestParseClaude(t *testing.T) {
+	docs, err := parseAll([]byte(claudeExport))
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	doc := docs[0]
+	assert.Equal(t, "Files", doc.Title)
+	assert.Equal(t, []string{"user:Summarize this", "assistant:", "tool:Milk is white", "assistant:Buy milk, which is white."}, texts(doc))
+
+	path := doc.ActivePath()
+	assert.Equal(t, "Buy milk", fileMeta(path[0].Parts[1]).Text)
+	assert.Equal(t, models.PartTypeReasoning, path[1].Parts[0].Type)
+	calls := toolCalls(path[1].Parts[1])
+	require.Len(t, calls, 1)
+	assert.Equal(t, "toolu_1", calls[0].ID)
+	assert.JSONEq(t, `{"q":"milk"}`, calls[0].FunctionCall.Arguments)
+	assert.Equal(t, "toolu_1", toolCallID(path[2].Parts[0]))
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 5, 0, time.UTC), path[3].CreatedAt)
+
+	_, err = parseAll([]byte(`{"hello": "world"}`))
+	assert.ErrorIs(t, err, ErrUnrecognized)
+}
+
+func TestZipBudget(t *testing.T) {
+	budget := &zipBudget{left: 10}
+	b, err := io.ReadAll(&zipEntry{r: strings.NewReader("0123456"), budget: budget})
+	require.NoError(t, err)
+	assert.Equal(t, "0123456", string(b))
+
+	// The budget is shared by every file of the zip
+	_, err = io.ReadAll(&zipEntry{r: strings.NewReader("0123456"), budget: budget})
+	assert.ErrorIs(t, err, ErrZipTooLarge)
+}
+
+func TestLoadShared(t *testing.T) {
+	db := setupDB(t)
+	ctx := context.Background()
+	session := seedConversation(t, db)
+	h := memory.NewSQLiteHistory(db, session.ID)
+	h.Files = nil
+	_, err := h.SaveMessage(ctx, memory.MultiModalMessage{
+		Type:        llms.ChatMessageTypeHuman,
+		Content:     "And this?",
+		Attachments: []memory.Attachment{{Filename: "notes.txt", Mime: "text/plain", Data: []byte("secret notes"), Text: "secret notes"}},
+	})
+	require.NoError(t, err)
+
+	doc, err := LoadShared(ctx, db, session)
+	require.NoError(t, err)
+	require.Len(t, doc.Messages, 5, "only the active branch is loaded")
+	assert.Equal(t, []string{"user:Weather here?", "assistant:", "tool:rain", "assistant:It rains in Oslo.", "user:And this?"}, texts(doc))
+	assert.Equal(t, "UE5HREFUQQ==", doc.Messages[0].Parts[1].Content, "images are kept")
+	notes := doc.Messages[4].Parts[1]
+	assert.Empty(t, notes.Content)
+	assert.Equal(t, "notes.txt", fileMeta(notes).Filename)
+}
+
+func TestSanitize(t *testing.T) {
+	db := setupDB(t)
+	doc, err := Load(context.Background(), db, seedConversation(t, db))
+	require.NoError(t, err)
+	doc.Messages[0].Parts = append(doc.Messages[0].Parts, documentPart("notes.txt", "text/plain", "secret notes"))
+
+	shared := Sanitize(doc, false)
+	assert.Empty(t, shared.Tags)
+	require.Len(t, shared.Messages, 4)
+	assert.Equal(t, texts(doc), texts(shared))
+	assert.Equal(t, uint(4), shared.ActiveID)
+	assert.Equal(t, uint(3), shared.Messages[3].ParentID)
+	parts := shared.Messages[0].Parts
+	assert.Equal(t, "UE5HREFUQQ==", parts[1].Content, "images are kept")
+	assert.Empty(t, parts[2].Content)
+	assert.Equal(t, "notes.txt", fileMeta(parts[2]).Filename)
+	assert.Empty(t, fileMeta(parts[2]).Text)
+
+	redacted := Sanitize(doc, true)
+	assert.Equal(t, []string{"user:Weather here?", "assistant:", "tool:" + RedactedOutput, "assistant:It rains in Oslo."}, texts(redacted))
+	assert.Equal(t, "weather", toolCalls(redacted.Messages[1].Parts[1])[0].FunctionCall.Name)
+	assert.Equal(t, "Weather here?", doc.Messages[0].Parts[0].Content, "the original is left alone")
+	assert.Equal(t, "rain", doc.ActivePath()[2].Parts[0].Content)
+}
@@ -0,0 +1,117 @@
+// Package storage keeps chat attachments in a content-addressed file store so that
+// large binary data stays out of the SQLite database. Files are named by the SHA-256
+// of their content and sharded by the first two bytes: <root>/ab/cd/abcd....
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ErrNotFound is returned for hashes that are not in the store.
+var ErrNotFound = errors.New("file not found")
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidHash reports whether hash is a lowercase hex SHA-256 digest.
+func ValidHash(hash string) bool {
+	return hashPattern.MatchString(hash)
+}
+
+// HashOf returns the store key for data.
+func HashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileStore is a content-addressed directory of immutable files.
+type FileStore struct {
+	root string
+}
+
+// NewFileStore creates the store directory if needed.
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{root: root}, nil
+}
+
+// Path returns where hash is (or would be) stored.
+func (s *FileStore) Path(hash string) string {
+	return filepath.Join(s.root, hash[:2], hash[2:4], hash)
+}
+
+// Put stores data and returns its hash. Storing identical content twice is a no-op.
+func (s *FileStore) Put(data []byte) (string, error) {
+	hash := HashOf(data)
+	path := s.Path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Write to a temp file and rename so readers never see partial content.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get reads the content stored under hash.
+func (s *FileStore) Get(hash string) ([]byte, error) {
+	if !ValidHash(hash) {
+		return nil, fmt.Errorf("invalid file hash %q", hash)
+	}
+	data, err := os.ReadFile(s.Path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+var (
+	mu          sync.RWMutex
+	defaultFile *FileStore
+)
+
+// Init opens the process-wide store at root.
+func Init(root string) error {
+	fs, err := NewFileStore(root)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defaultFile = fs
+	mu.Unlock()
+	return nil
+}
+
+// Default returns the process-wide store, or nil when Init has not been called
+// (attachments are then kept inline in the database as before).
+func Default() *FileStore {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultFile
+}
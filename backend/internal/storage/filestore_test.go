@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorePutGet(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	hash, err := store.Put([]byte("hello"))
+	assert.NoError(t, err)
+	assert.True(t, ValidHash(hash))
+	assert.Equal(t, HashOf([]byte("hello")), hash)
+
+	// Same content, same key.
+	again, err := store.Put([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, again)
+
+	data, err := store.Get(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	_, err = store.Get(HashOf([]byte("missing")))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = store.Get("../../etc/passwd")
+	assert.Error(t, err)
+}
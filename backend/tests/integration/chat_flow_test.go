@@ -57,10 +57,15 @@ func setupTestDB() {
 	// Migrate schema (MCP config now in mcp.json, not DB)
 	err = db.DB.AutoMigrate(
 		&models.Provider{},
+		&models.ProviderKey{},
 		&models.ModelConfig{},
 		&models.Session{},
 		&models.Message{},
 		&models.Part{},
+		&models.TokenUsage{},
+		&models.Budget{},
+		&models.BudgetReset{},
+		&models.SystemPrompt{},
 		&models.Skill{},
 		&models.AgentTask{},
 	)